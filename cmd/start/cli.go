@@ -0,0 +1,104 @@
+package start
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go-zhihu/internal/service"
+	"os"
+)
+
+const cliUsage = `用法:
+  go-zhihu feed rebuild -user <id>     重建指定用户的时间线
+  go-zhihu feed rebuild -all           重算大V集合并分批重建全部时间线
+  go-zhihu feed verify [-sample N] [-repair]
+                                       抽样校验redis和数据库的偏差，-repair时重建有偏差的用户
+  go-zhihu relation recount            按关注关系重算所有人的关注数和粉丝数
+  go-zhihu similar rebuild             为所有已发布的文章计算相似度签名
+  go-zhihu digest send -user <id> [-weekly]
+                                       立即给指定用户发一封邮件摘要(忽略发送时间)`
+
+// 命令行维护工具，不启动http服务，也不会删表重建
+func RunCommand(ctx context.Context, svc *service.Service, args []string) error {
+	if len(args) < 2 {
+		return errors.New(cliUsage)
+	}
+	if args[0] == "relation" && args[1] == "recount" {
+		if err := svc.Relation.RecountFollows(ctx, nil); err != nil {
+			return err
+		}
+		fmt.Println("follow counts recounted")
+		return nil
+	}
+	if args[0] == "similar" && args[1] == "rebuild" {
+		count, err := svc.Similar.RebuildSignatures(ctx, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d post signatures rebuilt\n", count)
+		return nil
+	}
+	if args[0] == "digest" && args[1] == "send" {
+		fs := flag.NewFlagSet("digest send", flag.ContinueOnError)
+		userID := fs.Uint("user", 0, "用户ID")
+		weekly := fs.Bool("weekly", false, "按每周摘要发送")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		if *userID == 0 {
+			return errors.New(cliUsage)
+		}
+		if err := svc.Digest.SendDigest(ctx, nil, *userID, *weekly); err != nil {
+			return err
+		}
+		fmt.Println("digest processed")
+		return nil
+	}
+	if args[0] != "feed" {
+		return errors.New(cliUsage)
+	}
+	switch args[1] {
+	case "rebuild":
+		fs := flag.NewFlagSet("feed rebuild", flag.ContinueOnError)
+		userID := fs.Uint("user", 0, "用户ID")
+		all := fs.Bool("all", false, "重建全部用户")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		if *all {
+			status, err := svc.Feed.RebuildAll(ctx, nil)
+			printJSON(status)
+			return err
+		}
+		if *userID == 0 {
+			return errors.New(cliUsage)
+		}
+		if err := svc.Feed.RebuildInbox(ctx, nil, *userID); err != nil {
+			return err
+		}
+		fmt.Printf("feed of user %d rebuilt\n", *userID)
+		return nil
+	case "verify":
+		fs := flag.NewFlagSet("feed verify", flag.ContinueOnError)
+		sample := fs.Int("sample", 0, "抽样用户数(默认取config的feed.verify_sample_size)")
+		repair := fs.Bool("repair", false, "重建有偏差的用户")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		report, err := svc.Feed.Verify(ctx, nil, *sample, *repair)
+		if err != nil {
+			return err
+		}
+		printJSON(report)
+		return nil
+	}
+	return errors.New(cliUsage)
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
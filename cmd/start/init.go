@@ -1,11 +1,9 @@
 package start
 
 import (
-	"fmt"
 	"go-zhihu/internal/handler"
 	"go-zhihu/internal/middleware"
 	"go-zhihu/internal/repository"
-	"log"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -27,8 +25,13 @@ func SetRoute(r *gin.Engine, httpHandler *handler.Handler, repos *repository.Rep
 	{
 		publicGroup.POST("/register", httpHandler.Register)
 		publicGroup.POST("/login", httpHandler.Login)
-		publicGroup.GET("/posts/search", httpHandler.Search)
+		publicGroup.GET("/posts/search", middleware.OptionalAuth(), httpHandler.Search)
+		publicGroup.GET("/search", middleware.OptionalAuth(), httpHandler.UnifiedSearch)
+		publicGroup.GET("/search/suggest", httpHandler.GetSearchSuggestions)
+		publicGroup.GET("/search/trending", httpHandler.GetTrendingSearches)
 		publicGroup.GET("/posts/ranking", httpHandler.GetLeaderboard)
+		publicGroup.GET("/notifications/unsubscribe", httpHandler.UnsubscribeDigestPage)
+		publicGroup.POST("/notifications/unsubscribe", httpHandler.UnsubscribeDigest)
 	}
 	authGroup := r.Group("/user")
 	authGroup.Use(middleware.AuthMiddleware())
@@ -41,20 +44,59 @@ func SetRoute(r *gin.Engine, httpHandler *handler.Handler, repos *repository.Rep
 		writerGroup.POST("unfollow/:id", httpHandler.UnFollowUser)
 		writerGroup.GET("followers", httpHandler.GetFollowers)
 		writerGroup.GET("following", httpHandler.GetFollowees)
+		writerGroup.GET("relations/status", httpHandler.GetRelationStatus)
+		writerGroup.GET("suggestions", httpHandler.GetSuggestions)
+		writerGroup.POST("suggestions/:id/dismiss", httpHandler.DismissSuggestion)
+		writerGroup.POST("block/:id", httpHandler.BlockUser)
+		writerGroup.POST("unblock/:id", httpHandler.UnblockUser)
+		writerGroup.GET("blocks", httpHandler.GetBlockedUsers)
+		writerGroup.PUT("settings/privacy", httpHandler.UpdatePrivacySettings)
+		writerGroup.GET("follow-requests", httpHandler.GetFollowRequests)
+		writerGroup.POST("follow-requests/:id/approve", httpHandler.ApproveFollowRequest)
+		writerGroup.POST("follow-requests/:id/reject", httpHandler.RejectFollowRequest)
 		//用户信息
 		writerGroup.PUT("profile", httpHandler.UpdateProfile)
 		writerGroup.GET("profile", httpHandler.GetUserProfile)
 		writerGroup.GET(":id/posts", httpHandler.GetUserPosts)
+		writerGroup.GET("stats", httpHandler.GetAuthorStats)
 		//文章操作
 		writerGroup.GET("posts/drafts", httpHandler.GetDrafts)
 		writerGroup.GET("posts/lists", httpHandler.GetLatestPosts)
 		writerGroup.POST("posts", httpHandler.CreatPost)
+		writerGroup.POST("posts/duplicates", httpHandler.CheckDuplicates)
 		writerGroup.POST("posts/:id/publish", httpHandler.PublishPost)
 		writerGroup.PUT("posts/:id", httpHandler.UpdatePost)
 		writerGroup.DELETE("posts/:id", httpHandler.DeletePost)
 		//文章关注
 		writerGroup.POST("connection/:id", httpHandler.ToggleConn)
-		writerGroup.POST("connections", httpHandler.GetConn)
+		writerGroup.POST("questions/:id/follow", httpHandler.FollowQuestion)
+		writerGroup.POST("questions/:id/unfollow", httpHandler.UnfollowQuestion)
+		writerGroup.GET("connections", httpHandler.GetConn)
+		//收藏夹
+		writerGroup.POST("folders", httpHandler.CreateFolder)
+		writerGroup.GET("folders", httpHandler.GetMyFolders)
+		writerGroup.GET("folders/following", httpHandler.GetFollowedFolders)
+		writerGroup.POST("folders/move", httpHandler.MoveFolderPosts)
+		writerGroup.POST("folders/copy", httpHandler.CopyFolderPosts)
+		writerGroup.PUT("folders/:id", httpHandler.UpdateFolder)
+		writerGroup.DELETE("folders/:id", httpHandler.DeleteFolder)
+		writerGroup.GET("folders/:id/posts", httpHandler.GetFolderPosts)
+		writerGroup.POST("folders/:id/posts/:post_id", httpHandler.AddToFolder)
+		writerGroup.DELETE("folders/:id/posts/:post_id", httpHandler.RemoveFromFolder)
+		writerGroup.POST("folders/:id/follow", httpHandler.FollowFolder)
+		writerGroup.POST("folders/:id/unfollow", httpHandler.UnfollowFolder)
+		//阅读历史
+		writerGroup.GET("history", httpHandler.GetReadHistory)
+		writerGroup.GET("history/continue", httpHandler.GetContinueReading)
+		writerGroup.PUT("history/:post_id/progress", httpHandler.ReportReadProgress)
+		writerGroup.DELETE("history/:post_id", httpHandler.DeleteReadHistory)
+		writerGroup.DELETE("history", httpHandler.ClearReadHistory)
+		writerGroup.PUT("settings/history", httpHandler.SetHistoryTracking)
+		writerGroup.GET("recommend", httpHandler.GetRecommend)
+		//动态
+		writerGroup.GET("activities", httpHandler.GetActivities)
+		writerGroup.GET("settings/activities", httpHandler.GetActivitySettings)
+		writerGroup.PUT("settings/activities", httpHandler.UpdateActivitySettings)
 		//点赞文章
 		writerGroup.POST("like", httpHandler.ToggleLike)
 		//comment
@@ -63,20 +105,36 @@ func SetRoute(r *gin.Engine, httpHandler *handler.Handler, repos *repository.Rep
 		//通知中心
 		writerGroup.GET("notifications", httpHandler.GetNotifications)
 		writerGroup.GET("notifications/unread", httpHandler.GetUnreadCount)
-		writerGroup.PUT("notifications/read/:id", httpHandler.MarkNotificationRead)
+		writerGroup.PUT("notifications/read-all", httpHandler.MarkAllRead)
+		// 旧路径，保留兼容
 		writerGroup.PUT("notifications/read/read_all", httpHandler.MarkAllRead)
+		writerGroup.PUT("notifications/read/:id", httpHandler.MarkNotificationRead)
+		writerGroup.POST("notifications/delete", httpHandler.DeleteNotifications)
+		writerGroup.DELETE("notifications/:id", httpHandler.DeleteNotification)
+		writerGroup.GET("settings/notifications", httpHandler.GetNotificationSettings)
+		writerGroup.PUT("settings/notifications", httpHandler.UpdateNotificationSettings)
 		//私信
 		writerGroup.POST("messages", httpHandler.SendMsg)
 		writerGroup.GET("messages/conversations", httpHandler.GetConversations)
 		writerGroup.GET("messages/unread", httpHandler.GetTotalUnread)
 		writerGroup.GET("messages/:id", httpHandler.GetChatHistory)
+		writerGroup.POST("messages/:id/typing", httpHandler.SendTyping)
+	}
+	//实时推送，token可以放在access_token参数里
+	realtimeGroup := r.Group("/realtime")
+	realtimeGroup.Use(middleware.StreamAuth())
+	realtimeGroup.Use(middleware.CheckStatus(repos.User, db))
+	{
+		realtimeGroup.GET("/ws", httpHandler.RealtimeWebSocket)
+		realtimeGroup.GET("/sse", httpHandler.RealtimeSSE)
 	}
 	usersGroup := r.Group("/users")
 	{
 		usersGroup.GET("/:id/profile", httpHandler.GetUserProfile)
-		usersGroup.GET("/:id/posts", httpHandler.GetUserPosts)
+		usersGroup.GET("/:id/posts", middleware.OptionalAuth(), httpHandler.GetUserPosts)
+		usersGroup.GET("/:id/folders", middleware.OptionalAuth(), httpHandler.GetUserFolders)
 	}
-	publicGroup.GET("/posts/:id", httpHandler.GetPostDetail)
+	publicGroup.GET("/posts/:id", middleware.OptionalAuth(), httpHandler.GetPostDetail)
 	authGroup.GET("feed", httpHandler.GetFeed)
 
 	//administer
@@ -85,9 +143,14 @@ func SetRoute(r *gin.Engine, httpHandler *handler.Handler, repos *repository.Rep
 	{
 		adminGroup.POST("/ban/:id", httpHandler.BanUser)
 		adminGroup.POST("/unban/:id", httpHandler.UnbanUser)
-	}
-	fmt.Println("start service on 8080")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Failed to start service:", err)
+		adminGroup.POST("/feed/rebuild", httpHandler.RebuildAllFeeds)
+		adminGroup.GET("/feed/rebuild/status", httpHandler.GetFeedRebuildStatus)
+		adminGroup.POST("/feed/rebuild/:id", httpHandler.RebuildUserFeed)
+		adminGroup.POST("/feed/verify", httpHandler.VerifyFeeds)
+		adminGroup.GET("/search/suppressed", httpHandler.GetSuppressedSearches)
+		adminGroup.POST("/search/suppressed", httpHandler.SuppressSearch)
+		adminGroup.DELETE("/search/suppressed", httpHandler.UnsuppressSearch)
+		adminGroup.GET("/posts/:id/duplicates", httpHandler.GetPostDuplicates)
+		adminGroup.POST("/posts/:id/merge", httpHandler.MergePost)
 	}
 }
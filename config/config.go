@@ -13,6 +13,18 @@ type Config struct {
 	Redis     RedisConfig     `mapstructure:"redis"`
 	JWT       JWTConfig       `mapstructure:"jwt"`
 	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Rank      RankConfig      `mapstructure:"rank"`
+	View      ViewConfig      `mapstructure:"view"`
+	History   HistoryConfig   `mapstructure:"history"`
+	Feed      FeedConfig      `mapstructure:"feed"`
+	Job       JobConfig       `mapstructure:"job"`
+	Recommend RecommendConfig `mapstructure:"recommend"`
+	Suggest   SuggestConfig   `mapstructure:"suggest"`
+	Search    SearchConfig    `mapstructure:"search"`
+	Similar   SimilarConfig   `mapstructure:"similar"`
+	Realtime  RealtimeConfig  `mapstructure:"realtime"`
+	Notify    NotifyConfig    `mapstructure:"notify"`
+	Mail      MailConfig      `mapstructure:"mail"`
 }
 type ServerConfig struct {
 	Port int    `mapstructure:"port"`
@@ -62,12 +74,260 @@ type RateLimitConfig struct {
 	RequestsPerMinute int `mapstructure:"requests_per_minute"`
 }
 
+// 热榜重力公式：score = (点赞*w1 + 评论*w2 + 收藏*w3 + 阅读*w4) / (发布小时数 + base)^gravity
+type RankConfig struct {
+	LikeWeight     float64 `mapstructure:"like_weight"`
+	CommentWeight  float64 `mapstructure:"comment_weight"`
+	BookmarkWeight float64 `mapstructure:"bookmark_weight"`
+	ViewWeight     float64 `mapstructure:"view_weight"`
+	Gravity        float64 `mapstructure:"gravity"`
+	BaseHours      float64 `mapstructure:"base_hours"`
+	CandidateDays  int     `mapstructure:"candidate_days"`
+	RefreshSeconds int     `mapstructure:"refresh_seconds"`
+	BoardSize      int     `mapstructure:"board_size"`
+	BoardCacheSecs int     `mapstructure:"board_cache_seconds"`
+}
+
+// 阅读量：同一用户/IP在去重窗口内只计一次，定时从redis刷回mysql
+type ViewConfig struct {
+	DedupWindowMinutes int `mapstructure:"dedup_window_minutes"`
+	FlushSeconds       int `mapstructure:"flush_seconds"`
+}
+
+// 阅读历史：每个用户最多保留的条数，后台定时清理超出部分
+type HistoryConfig struct {
+	MaxPerUser   int `mapstructure:"max_per_user"`
+	PruneMinutes int `mapstructure:"prune_minutes"`
+}
+
+// 推拉结合：粉丝数达到阈值的作者发文只写发件箱，由粉丝读取时拉取
+type FeedConfig struct {
+	CelebrityThreshold int `mapstructure:"celebrity_threshold"`
+	PushBatchSize      int `mapstructure:"push_batch_size"`
+	OutboxSize         int `mapstructure:"outbox_size"`
+	InboxMaxLen        int `mapstructure:"inbox_max_len"`
+	// 重建与校验：每批处理的用户数、批间暂停毫秒数，校验时比较每人最近多少条
+	RebuildBatchSize int `mapstructure:"rebuild_batch_size"`
+	RebuildPauseMs   int `mapstructure:"rebuild_pause_ms"`
+	VerifyDepth      int `mapstructure:"verify_depth"`
+	VerifySampleSize int `mapstructure:"verify_sample_size"`
+}
+
+// 后台任务队列
+type JobConfig struct {
+	Workers           int `mapstructure:"workers"`
+	MaxAttempts       int `mapstructure:"max_attempts"`
+	BackoffSeconds    int `mapstructure:"backoff_seconds"`
+	MaxBackoffSeconds int `mapstructure:"max_backoff_seconds"`
+	TimeoutSeconds    int `mapstructure:"timeout_seconds"`
+	ClaimIdleSeconds  int `mapstructure:"claim_idle_seconds"`
+	ShutdownSeconds   int `mapstructure:"shutdown_seconds"`
+}
+
+// 个性化推荐：三路召回的权重、候选规模和在线重排参数
+type RecommendConfig struct {
+	HotWeight              float64 `mapstructure:"hot_weight"`
+	TopicWeight            float64 `mapstructure:"topic_weight"`
+	CFWeight               float64 `mapstructure:"cf_weight"`
+	WindowDays             int     `mapstructure:"window_days"`
+	CandidateSize          int     `mapstructure:"candidate_size"`
+	CandidateTTLHours      int     `mapstructure:"candidate_ttl_hours"`
+	RefreshMinutes         int     `mapstructure:"refresh_minutes"`
+	ActiveDays             int     `mapstructure:"active_days"`
+	MaxUsersPerRound       int     `mapstructure:"max_users_per_round"`
+	MaxPerAuthor           int     `mapstructure:"max_per_author"`
+	FreshnessHalfLifeHours float64 `mapstructure:"freshness_half_life_hours"`
+	CFRecentLikes          int     `mapstructure:"cf_recent_likes"`
+	CFMaxNeighbors         int     `mapstructure:"cf_max_neighbors"`
+}
+
+// 可能认识的人：三路召回的权重和缓存参数
+type SuggestConfig struct {
+	FriendWeight   float64 `mapstructure:"friend_weight"`
+	TopicWeight    float64 `mapstructure:"topic_weight"`
+	CoLikeWeight   float64 `mapstructure:"co_like_weight"`
+	WindowDays     int     `mapstructure:"window_days"`
+	CacheSize      int     `mapstructure:"cache_size"`
+	CacheTTLHours  int     `mapstructure:"cache_ttl_hours"`
+	RefreshMinutes int     `mapstructure:"refresh_minutes"`
+	ActiveDays     int     `mapstructure:"active_days"`
+	MaxUsers       int     `mapstructure:"max_users_per_round"`
+}
+
+// 搜索：engine可选mysql(全文索引)或memory(进程内倒排索引，仅适合单实例)
+type SearchConfig struct {
+	Engine           string  `mapstructure:"engine"`
+	MaxHits          int     `mapstructure:"max_hits"`
+	TitleBoost       float64 `mapstructure:"title_boost"`
+	RebuildBatchSize int     `mapstructure:"rebuild_batch_size"`
+	// 热搜：按小时分桶计数，合并最近若干小时并按半衰期衰减，低于最低分的不上榜
+	TrendingWindowHours   int     `mapstructure:"trending_window_hours"`
+	TrendingHalfLifeHours float64 `mapstructure:"trending_half_life_hours"`
+	TrendingMinScore      float64 `mapstructure:"trending_min_score"`
+	TrendingCacheSecs     int     `mapstructure:"trending_cache_secs"`
+	// 联想词：每个前缀保留的条数、最长前缀(字)、收录的文章数和重建间隔
+	CompletionPerPrefix   int `mapstructure:"completion_per_prefix"`
+	CompletionPrefixLen   int `mapstructure:"completion_prefix_len"`
+	CompletionMaxSources  int `mapstructure:"completion_max_sources"`
+	CompletionRefreshMins int `mapstructure:"completion_refresh_minutes"`
+}
+
+// 相似文章和重复问题：相似度为MinHash估计的Jaccard系数
+type SimilarConfig struct {
+	DuplicateThreshold  float64 `mapstructure:"duplicate_threshold"`
+	RelatedThreshold    float64 `mapstructure:"related_threshold"`
+	RelatedSize         int     `mapstructure:"related_size"`
+	CandidateLimit      int     `mapstructure:"candidate_limit"`
+	RelatedCacheMinutes int     `mapstructure:"related_cache_minutes"`
+}
+
+// 实时推送：websocket和sse共用，多实例之间通过redis pub/sub转发
+type RealtimeConfig struct {
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
+	// 每个连接的发送缓冲，写满说明客户端消费不过来，直接断开让它重连
+	SendBuffer      int `mapstructure:"send_buffer"`
+	MaxConnsPerUser int `mapstructure:"max_conns_per_user"`
+	// 心跳间隔，超过两个间隔没有收到客户端任何数据视为断线
+	PingSeconds         int `mapstructure:"ping_seconds"`
+	WriteTimeoutSeconds int `mapstructure:"write_timeout_seconds"`
+	MaxFrameBytes       int `mapstructure:"max_frame_bytes"`
+	// 同一对用户之间"正在输入"最多隔这么久转发一次
+	TypingThrottleSecs int `mapstructure:"typing_throttle_seconds"`
+}
+
+// 通知偏好的默认值：用户没有设置过时使用
+type NotifyConfig struct {
+	DefaultDigest   string `mapstructure:"default_digest"`
+	DefaultTimezone string `mapstructure:"default_timezone"`
+	// 邮件摘要：每天用户本地时间DigestHour点之后发送，每周的在DigestWeekday(0为周日)发送
+	DigestHour        int `mapstructure:"digest_hour"`
+	DigestWeekday     int `mapstructure:"digest_weekday"`
+	DigestScanMinutes int `mapstructure:"digest_scan_minutes"`
+	DigestBatchSize   int `mapstructure:"digest_batch_size"`
+	// 每一栏最多列出的条数
+	DigestMaxItems int `mapstructure:"digest_max_items"`
+	// 已读通知按最近触发时间保留的天数(0表示不清理)，每隔PruneMinutes分批清理一次
+	RetentionDays  int `mapstructure:"retention_days"`
+	PruneMinutes   int `mapstructure:"prune_minutes"`
+	PruneBatchSize int `mapstructure:"prune_batch_size"`
+}
+
+// 发信：driver为file时写入FileDir下的.eml文件(本地调试用)，为smtp时通过SMTP发送
+type MailConfig struct {
+	Driver       string `mapstructure:"driver"`
+	From         string `mapstructure:"from"`
+	FileDir      string `mapstructure:"file_dir"`
+	SMTPHost     string `mapstructure:"smtp_host"`
+	SMTPPort     int    `mapstructure:"smtp_port"`
+	SMTPUsername string `mapstructure:"smtp_username"`
+	SMTPPassword string `mapstructure:"smtp_password"`
+	// 邮件里链接的站点地址，以及退订链接的签名密钥(为空时使用jwt.secret)
+	SiteURL           string `mapstructure:"site_url"`
+	UnsubscribeSecret string `mapstructure:"unsubscribe_secret"`
+}
+
 var Setting *Config
 
+// 未在配置文件中给出时使用的默认值
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("server.port", 8080)
+	v.SetDefault("rank.like_weight", 1.0)
+	v.SetDefault("rank.comment_weight", 2.0)
+	v.SetDefault("rank.bookmark_weight", 3.0)
+	v.SetDefault("rank.view_weight", 0.05)
+	v.SetDefault("rank.gravity", 1.8)
+	v.SetDefault("rank.base_hours", 2.0)
+	v.SetDefault("rank.candidate_days", 30)
+	v.SetDefault("rank.refresh_seconds", 300)
+	v.SetDefault("rank.board_size", 1000)
+	v.SetDefault("rank.board_cache_seconds", 60)
+	v.SetDefault("view.dedup_window_minutes", 30)
+	v.SetDefault("view.flush_seconds", 60)
+	v.SetDefault("history.max_per_user", 1000)
+	v.SetDefault("history.prune_minutes", 60)
+	v.SetDefault("feed.celebrity_threshold", 5000)
+	v.SetDefault("feed.push_batch_size", 500)
+	v.SetDefault("feed.outbox_size", 500)
+	v.SetDefault("feed.inbox_max_len", 1000)
+	v.SetDefault("feed.rebuild_batch_size", 100)
+	v.SetDefault("feed.rebuild_pause_ms", 200)
+	v.SetDefault("feed.verify_depth", 50)
+	v.SetDefault("feed.verify_sample_size", 100)
+	v.SetDefault("job.workers", 4)
+	v.SetDefault("job.max_attempts", 5)
+	v.SetDefault("job.backoff_seconds", 2)
+	v.SetDefault("job.max_backoff_seconds", 300)
+	v.SetDefault("job.timeout_seconds", 30)
+	v.SetDefault("job.claim_idle_seconds", 60)
+	v.SetDefault("job.shutdown_seconds", 15)
+	v.SetDefault("recommend.hot_weight", 1.0)
+	v.SetDefault("recommend.topic_weight", 1.5)
+	v.SetDefault("recommend.cf_weight", 2.0)
+	v.SetDefault("recommend.window_days", 30)
+	v.SetDefault("recommend.candidate_size", 300)
+	v.SetDefault("recommend.candidate_ttl_hours", 24)
+	v.SetDefault("recommend.refresh_minutes", 30)
+	v.SetDefault("recommend.active_days", 7)
+	v.SetDefault("recommend.max_users_per_round", 5000)
+	v.SetDefault("recommend.max_per_author", 2)
+	v.SetDefault("recommend.freshness_half_life_hours", 72)
+	v.SetDefault("recommend.cf_recent_likes", 100)
+	v.SetDefault("recommend.cf_max_neighbors", 200)
+	v.SetDefault("suggest.friend_weight", 3.0)
+	v.SetDefault("suggest.topic_weight", 1.0)
+	v.SetDefault("suggest.co_like_weight", 2.0)
+	v.SetDefault("suggest.window_days", 30)
+	v.SetDefault("suggest.cache_size", 50)
+	v.SetDefault("suggest.cache_ttl_hours", 24)
+	v.SetDefault("suggest.refresh_minutes", 60)
+	v.SetDefault("suggest.active_days", 7)
+	v.SetDefault("suggest.max_users_per_round", 5000)
+	v.SetDefault("search.engine", "mysql")
+	v.SetDefault("search.max_hits", 1000)
+	v.SetDefault("search.title_boost", 3.0)
+	v.SetDefault("search.rebuild_batch_size", 500)
+	v.SetDefault("search.trending_window_hours", 48)
+	v.SetDefault("search.trending_half_life_hours", 6.0)
+	v.SetDefault("search.trending_min_score", 2.0)
+	v.SetDefault("search.trending_cache_secs", 60)
+	v.SetDefault("search.completion_per_prefix", 20)
+	v.SetDefault("search.completion_prefix_len", 10)
+	v.SetDefault("search.completion_max_sources", 10000)
+	v.SetDefault("search.completion_refresh_minutes", 30)
+	v.SetDefault("similar.duplicate_threshold", 0.5)
+	v.SetDefault("similar.related_threshold", 0.15)
+	v.SetDefault("similar.related_size", 5)
+	v.SetDefault("similar.candidate_limit", 200)
+	v.SetDefault("similar.related_cache_minutes", 30)
+	v.SetDefault("realtime.allowed_origins", []string{"http://localhost:3000", "http://127.0.0.1:3000"})
+	v.SetDefault("realtime.send_buffer", 64)
+	v.SetDefault("realtime.max_conns_per_user", 5)
+	v.SetDefault("realtime.ping_seconds", 25)
+	v.SetDefault("realtime.write_timeout_seconds", 10)
+	v.SetDefault("realtime.max_frame_bytes", 4096)
+	v.SetDefault("realtime.typing_throttle_seconds", 2)
+	v.SetDefault("notify.default_digest", "weekly")
+	v.SetDefault("notify.default_timezone", "Asia/Shanghai")
+	v.SetDefault("notify.digest_hour", 8)
+	v.SetDefault("notify.digest_weekday", 1)
+	v.SetDefault("notify.digest_scan_minutes", 15)
+	v.SetDefault("notify.digest_batch_size", 500)
+	v.SetDefault("notify.digest_max_items", 10)
+	v.SetDefault("notify.retention_days", 90)
+	v.SetDefault("notify.prune_minutes", 60)
+	v.SetDefault("notify.prune_batch_size", 1000)
+	v.SetDefault("mail.driver", "file")
+	v.SetDefault("mail.from", "go-zhihu <noreply@localhost>")
+	v.SetDefault("mail.file_dir", "tmp/mail")
+	v.SetDefault("mail.smtp_port", 587)
+	v.SetDefault("mail.site_url", "http://localhost:8080")
+}
+
 func Init(configPath string) error {
 	v := viper.New()
 	v.SetConfigFile(configPath)
 	v.SetConfigType("yaml")
+	setDefaults(v)
 
 	v.AutomaticEnv()
 	v.SetEnvPrefix("ZHIHU")
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ActivitySettingRequest struct {
+	Types map[string]bool `json:"types" binding:"required"` //类型名->是否显示，没传的类型不变
+}
+
+// GetActivities 获取关注的人的动态
+// @Summary 获取关注的人的动态
+// @Description 关注的人发布文章、提问、回答、赞同回答、赞文章、关注问题的动态，同一对象上的同类动态合并为一条
+// @Tags 动态
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param cursor query string false "上一页返回的next_cursor，第一页不传"
+// @Param page_size query int false "每页数量" default(10)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/activities [get]
+func (h *Handler) GetActivities(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	cursor, pageSize := parseCursor(c, 10, 50)
+	page, err := h.Service.Activity.GetActivities(ctx, tx, uid, cursor, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, page)
+}
+
+// GetActivitySettings 获取动态类型设置
+// @Summary 获取动态类型设置
+// @Description 返回每种动态类型是否显示
+// @Tags 动态
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/settings/activities [get]
+func (h *Handler) GetActivitySettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	settings, err := h.Service.Activity.GetSettings(ctx, tx, uid)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, settings)
+}
+
+// UpdateActivitySettings 修改动态类型设置
+// @Summary 修改动态类型设置
+// @Description 按类型名(publish_article,ask_question,answer,upvote_answer,like_article,follow_question)开关
+// @Tags 动态
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body ActivitySettingRequest true "类型开关"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/settings/activities [put]
+func (h *Handler) UpdateActivitySettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req ActivitySettingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Activity.UpdateSettings(ctx, tx, uid, req.Types); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BlockUser 拉黑用户
+// @Summary 拉黑用户
+// @Description 拉黑后自动解除双方的关注，互相看不到对方的内容，对方不能关注、评论、点赞或私信你
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "被拉黑用户ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/block/{id} [post]
+func (h *Handler) BlockUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	targetID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Block.BlockUser(ctx, tx, uid, targetID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// UnblockUser 取消拉黑
+// @Summary 取消拉黑
+// @Description 取消拉黑指定用户，之前解除的关注不会恢复
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/unblock/{id} [post]
+func (h *Handler) UnblockUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	targetID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Block.UnblockUser(ctx, tx, uid, targetID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// GetBlockedUsers 黑名单
+// @Summary 黑名单
+// @Description 获取当前用户拉黑的人
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/blocks [get]
+func (h *Handler) GetBlockedUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	page, pageSize := parsePage(c, 20, 100)
+	users, err := h.Service.Block.ListBlocked(ctx, tx, uid, page, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, users)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RebuildUserFeed 重建指定用户的时间线
+// @Summary 重建指定用户的时间线
+// @Description 管理员从关注关系和文章表重建某个用户的收件箱
+// @Tags 时间线维护
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /admin/feed/rebuild/{id} [post]
+func (h *Handler) RebuildUserFeed(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	userID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Feed.RebuildInbox(ctx, tx, userID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// RebuildAllFeeds 重建全部时间线
+// @Summary 重建全部时间线
+// @Description 后台重算大V集合并分批重建所有用户的收件箱，批大小和间隔在config的feed中配置；同一时间只能有一个重建任务
+// @Tags 时间线维护
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "已开始"
+// @Router /admin/feed/rebuild [post]
+func (h *Handler) RebuildAllFeeds(c *gin.Context) {
+	if err := h.Service.Feed.StartRebuildAll(c.Request.Context()); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// GetFeedRebuildStatus 查询全量重建进度
+// @Summary 查询全量重建进度
+// @Description 返回最近一次全量重建的进度
+// @Tags 时间线维护
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /admin/feed/rebuild/status [get]
+func (h *Handler) GetFeedRebuildStatus(c *gin.Context) {
+	status, err := h.Service.Feed.GetRebuildStatus(c.Request.Context())
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, status)
+}
+
+// VerifyFeeds 校验时间线一致性
+// @Summary 校验时间线一致性
+// @Description 抽样比较redis收件箱和数据库，返回偏差统计；repair为true时重建有偏差的用户
+// @Tags 时间线维护
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param sample query int false "抽样用户数" default(100)
+// @Param repair query bool false "是否修复" default(false)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /admin/feed/verify [post]
+func (h *Handler) VerifyFeeds(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	sample, _ := strconv.Atoi(c.Query("sample"))
+	repair, _ := strconv.ParseBool(c.Query("repair"))
+	report, err := h.Service.Feed.Verify(ctx, tx, sample, repair)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, report)
+}
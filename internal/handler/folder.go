@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateFolderRequest struct {
+	Name        string `json:"name" binding:"required,max=64"`
+	Description string `json:"description" binding:"omitempty,max=255"`
+	IsPublic    bool   `json:"is_public"`
+}
+type UpdateFolderRequest struct {
+	Name        string `json:"name" binding:"omitempty,max=64"`
+	Description string `json:"description" binding:"omitempty,max=255"`
+	IsPublic    *bool  `json:"is_public"` //用*区分不传与传false
+}
+type TransferPostsRequest struct {
+	FromFolderID uint   `json:"from_folder_id" binding:"required"`
+	ToFolderID   uint   `json:"to_folder_id" binding:"required"`
+	PostIDs      []uint `json:"post_ids" binding:"required,min=1,max=100"`
+}
+
+// CreateFolder 创建收藏夹
+// @Summary 创建收藏夹
+// @Description 创建一个新的收藏夹，可设置公开或私密
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body CreateFolderRequest true "收藏夹信息"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/folders [post]
+func (h *Handler) CreateFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	folder, err := h.Service.Folder.CreateFolder(ctx, tx, uid, req.Name, req.Description, req.IsPublic)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, folder)
+}
+
+// GetMyFolders 获取我的收藏夹
+// @Summary 获取我的收藏夹
+// @Description 获取当前用户的全部收藏夹(包括私密的)
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders [get]
+func (h *Handler) GetMyFolders(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folders, err := h.Service.Folder.ListFolders(ctx, tx, uid, uid)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, folders)
+}
+
+// GetUserFolders 获取指定用户的收藏夹
+// @Summary 获取指定用户的收藏夹
+// @Description 获取指定用户的公开收藏夹，本人访问时返回全部
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /users/{id}/folders [get]
+func (h *Handler) GetUserFolders(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	targetID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	folders, err := h.Service.Folder.ListFolders(ctx, tx, targetID, getOptionalUserID(c))
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, folders)
+}
+
+// UpdateFolder 修改收藏夹
+// @Summary 修改收藏夹
+// @Description 修改收藏夹名称、描述或公开状态
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "收藏夹ID"
+// @Param data body UpdateFolderRequest true "修改内容"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/folders/{id} [put]
+func (h *Handler) UpdateFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folderID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	var req UpdateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Folder.UpdateFolder(ctx, tx, uid, folderID, req.Name, req.Description, req.IsPublic); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// DeleteFolder 删除收藏夹
+// @Summary 删除收藏夹
+// @Description 删除收藏夹及其中的收藏，默认收藏夹不能删除
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "收藏夹ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/{id} [delete]
+func (h *Handler) DeleteFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folderID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Folder.DeleteFolder(ctx, tx, uid, folderID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// GetFolderPosts 获取收藏夹内容
+// @Summary 获取收藏夹内容
+// @Description 获取收藏夹内的文章，私密收藏夹只有本人可见
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "收藏夹ID"
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(10)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/{id}/posts [get]
+func (h *Handler) GetFolderPosts(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folderID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	page, pageSize := parsePage(c, 10, 50)
+	posts, err := h.Service.Folder.GetFolderPosts(ctx, tx, uid, folderID, page, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, posts)
+}
+
+// AddToFolder 收藏到收藏夹
+// @Summary 收藏到收藏夹
+// @Description 把文章收藏到指定收藏夹，同一篇文章可以收藏到多个收藏夹
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "收藏夹ID"
+// @Param post_id path int true "文章ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/{id}/posts/{post_id} [post]
+func (h *Handler) AddToFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folderID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	postID, err := parseIDParam(c, "post_id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Folder.AddToFolder(ctx, tx, uid, folderID, postID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// RemoveFromFolder 从收藏夹移除
+// @Summary 从收藏夹移除
+// @Description 把文章从指定收藏夹移除
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "收藏夹ID"
+// @Param post_id path int true "文章ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/{id}/posts/{post_id} [delete]
+func (h *Handler) RemoveFromFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folderID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	postID, err := parseIDParam(c, "post_id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Folder.RemoveFromFolder(ctx, tx, uid, folderID, postID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// MoveFolderPosts 移动收藏
+// @Summary 移动收藏
+// @Description 把文章从一个收藏夹移动到另一个收藏夹
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body TransferPostsRequest true "移动信息"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/move [post]
+func (h *Handler) MoveFolderPosts(c *gin.Context) {
+	h.transferFolderPosts(c, true)
+}
+
+// CopyFolderPosts 复制收藏
+// @Summary 复制收藏
+// @Description 把文章从一个收藏夹复制到另一个收藏夹
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body TransferPostsRequest true "复制信息"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/copy [post]
+func (h *Handler) CopyFolderPosts(c *gin.Context) {
+	h.transferFolderPosts(c, false)
+}
+func (h *Handler) transferFolderPosts(c *gin.Context, move bool) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req TransferPostsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Folder.TransferPosts(ctx, tx, uid, req.FromFolderID, req.ToFolderID, req.PostIDs, move); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// FollowFolder 关注收藏夹
+// @Summary 关注收藏夹
+// @Description 关注其他用户的公开收藏夹
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "收藏夹ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/{id}/follow [post]
+func (h *Handler) FollowFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folderID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Folder.FollowFolder(ctx, tx, uid, folderID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// UnfollowFolder 取消关注收藏夹
+// @Summary 取消关注收藏夹
+// @Description 取消关注收藏夹
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "收藏夹ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/{id}/unfollow [post]
+func (h *Handler) UnfollowFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	folderID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Folder.UnfollowFolder(ctx, tx, uid, folderID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// GetFollowedFolders 获取关注的收藏夹
+// @Summary 获取关注的收藏夹
+// @Description 获取当前用户关注的收藏夹列表
+// @Tags 收藏夹
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/folders/following [get]
+func (h *Handler) GetFollowedFolders(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	page, pageSize := parsePage(c, 20, 100)
+	folders, err := h.Service.Folder.GetFollowedFolders(ctx, tx, uid, page, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, folders)
+}
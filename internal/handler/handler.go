@@ -4,6 +4,7 @@ import (
 	"go-zhihu/internal/service"
 	"go-zhihu/pkg/e"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -47,6 +48,38 @@ func getUserID(c *gin.Context) (uint, bool) {
 	}
 	return uid, true
 }
+
+// 解析分页参数，page从1开始，page_size限制在[1,maxSize]
+func parsePage(c *gin.Context, defaultSize, maxSize int) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultSize)))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultSize
+	}
+	if pageSize > maxSize {
+		pageSize = maxSize
+	}
+	return page, pageSize
+}
+
+// 解析游标分页参数，cursor为上一页返回的next_cursor，第一页不传
+func parseCursor(c *gin.Context, defaultSize, maxSize int) (string, int) {
+	_, pageSize := parsePage(c, defaultSize, maxSize)
+	return c.Query("cursor"), pageSize
+}
+
+// 可选登录的接口获取用户id，游客返回0
+func getOptionalUserID(c *gin.Context) uint {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return 0
+	}
+	uid, _ := userID.(uint)
+	return uid
+}
 func parseIDParam(c *gin.Context, paramKey string) (uint, error) {
 	paramID := c.Param(paramKey)
 	id, err := strconv.ParseUint(paramID, 10, 32)
@@ -141,21 +174,24 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 
 // 处理文章/问题·相关
 type CreatePostRequest struct {
-	Title   string `json:"title" binding:"required"`
-	Content string `json:"content" binding:"required"`
-	Type    int    `json:"type" binding:"required,oneof=1 2"` //1.chapter 2.question
-	Status  int    `json:"status" binding:"required"`
+	Title   string   `json:"title" binding:"required"`
+	Content string   `json:"content" binding:"required"`
+	Type    int      `json:"type" binding:"required,oneof=1 2"` //1.chapter 2.question
+	Status  int      `json:"status" binding:"required"`
+	Topics  []string `json:"topics" binding:"omitempty,max=5"`
+	// 提问时忽略查重结果直接发布
+	Force bool `json:"force"`
 }
 
 // CreatPost 创建文章
 // @Summary 创建文章或问题
-// @Description 用户发布新的内容
+// @Description 用户发布新的内容；直接发布问题时先查重，发现可能重复的问题且force为false时不发布，created为false并返回duplicates
 // @Tags 文章
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param data body CreatePostRequest true "文章内容"
-// @Success 200 {object} map[string]interface{} "成功"
+// @Success 200 {object} service.CreatePostVO "成功"
 // @Router /user/posts [post]
 func (h *Handler) CreatPost(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -169,11 +205,12 @@ func (h *Handler) CreatPost(c *gin.Context) {
 		e.ErrorResponse(c, e.ErrInvalidArgs)
 		return
 	}
-	if err := h.Service.Post.CreatePost(ctx, tx, uid, req.Title, req.Content, req.Type, req.Status); err != nil {
+	result, err := h.Service.Post.CreatePost(ctx, tx, uid, req.Title, req.Content, req.Type, req.Status, req.Topics, req.Force)
+	if err != nil {
 		e.ErrorResponse(c, err)
 		return
 	}
-	e.SuccessResponse(c, nil)
+	e.SuccessResponse(c, result)
 }
 
 // 获取草稿箱列表
@@ -222,7 +259,7 @@ func (h *Handler) GetDrafts(c *gin.Context) {
 // @Tags 文章
 // @Accept json
 // @Produce json
-// @Param page query int false "页码" default(1)
+// @Param cursor query string false "上一页返回的next_cursor，第一页不传"
 // @Param page_size query int false "每页数量" default(10)
 // @Success 200 {object} map[string]interface{} "成功"
 // @Failure 500 {object} map[string]interface{} "服务器错误"
@@ -230,11 +267,8 @@ func (h *Handler) GetDrafts(c *gin.Context) {
 func (h *Handler) GetLatestPosts(c *gin.Context) {
 	ctx := c.Request.Context()
 	tx := h.db
-	pageStr := c.DefaultQuery("page", "1")
-	pageSizeStr := c.DefaultQuery("page_size", "10")
-	page, _ := strconv.Atoi(pageStr)
-	pageSize, _ := strconv.Atoi(pageSizeStr)
-	posts, err := h.Service.Post.GetLatestPosts(ctx, tx, page, pageSize)
+	cursor, pageSize := parseCursor(c, 10, 50)
+	posts, err := h.Service.Post.GetLatestPosts(ctx, tx, getOptionalUserID(c), cursor, pageSize)
 	if err != nil {
 		e.ErrorResponse(c, err)
 		return
@@ -261,7 +295,7 @@ func (h *Handler) GetComments(c *gin.Context) {
 		e.ErrorResponse(c, e.ErrInvalidArgs)
 		return
 	}
-	comments, err := h.Service.Interaction.GetComments(ctx, tx, postID)
+	comments, err := h.Service.Interaction.GetComments(ctx, tx, postID, getOptionalUserID(c))
 	if err != nil {
 		e.ErrorResponse(c, err)
 		return
@@ -271,7 +305,7 @@ func (h *Handler) GetComments(c *gin.Context) {
 
 // GetPostDetail 获取文章详情
 // @Summary 获取文章详情
-// @Description 根据ID获取文章详情
+// @Description 根据ID获取文章详情，同时记录阅读数(登录用户按ID、游客按IP去重)
 // @Tags 文章
 // @Accept json
 // @Produce json
@@ -286,7 +320,8 @@ func (h *Handler) GetPostDetail(c *gin.Context) {
 		e.ErrorResponse(c, e.ErrInvalidArgs)
 		return
 	}
-	post, err := h.Service.Post.GetPostDetail(ctx, tx, postID)
+	viewerID := getOptionalUserID(c)
+	post, err := h.Service.Post.GetPostDetail(ctx, tx, postID, viewerID, c.ClientIP())
 	if err != nil {
 		e.ErrorResponse(c, err)
 		return
@@ -395,7 +430,7 @@ func (h *Handler) DeletePost(c *gin.Context) {
 
 // Search 搜索文章
 // @Summary 搜索文章
-// @Description 根据关键词搜索文章列表
+// @Description 根据关键词搜索文章列表，按相关度排序
 // @Tags 文章
 // @Accept json
 // @Produce json
@@ -412,7 +447,7 @@ func (h *Handler) Search(c *gin.Context) {
 	pageSizeStr := c.DefaultQuery("page_size", "10")
 	page, _ := strconv.Atoi(pageStr)
 	pageSize, _ := strconv.Atoi(pageSizeStr)
-	posts, err := h.Service.Post.Search(ctx, tx, keyword, page, pageSize)
+	posts, err := h.Service.Search.SearchPosts(ctx, tx, keyword, getOptionalUserID(c), page, pageSize)
 	if err != nil {
 		e.ErrorResponse(c, err)
 		return
@@ -422,13 +457,13 @@ func (h *Handler) Search(c *gin.Context) {
 
 // FollowUser 关注用户
 // @Summary 关注用户
-// @Description 关注指定ID的用户
+// @Description 关注指定ID的用户，对方是私密账号时发出关注请求，返回status为following或requested
 // @Tags 用户关系
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param id path int true "被关注用户ID"
-// @Success 200 {object} map[string]interface{} "成功"
+// @Success 200 {object} service.FollowResultVO "成功"
 // @Failure 400 {object} map[string]interface{} "请求参数错误"
 // @Failure 401 {object} map[string]interface{} "未授权"
 // @Router /user/follow/{id} [post]
@@ -443,11 +478,12 @@ func (h *Handler) FollowUser(c *gin.Context) {
 	if err != nil {
 		e.ErrorResponse(c, e.ErrInvalidArgs)
 	}
-	if err := h.Service.Relation.FollowUser(ctx, tx, uid, targetID); err != nil {
+	status, err := h.Service.Relation.FollowUser(ctx, tx, uid, targetID)
+	if err != nil {
 		e.ErrorResponse(c, err)
 		return
 	}
-	e.SuccessResponse(c, nil)
+	e.SuccessResponse(c, service.FollowResultVO{Status: status})
 }
 
 // UnFollowUser 取消关注用户
@@ -529,7 +565,7 @@ func (h *Handler) AddComment(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Param page query int false "页码" default(1)
+// @Param cursor query string false "上一页返回的next_cursor，第一页不传"
 // @Param page_size query int false "每页数量" default(10)
 // @Success 200 {object} map[string]interface{} "成功"
 // @Failure 401 {object} map[string]interface{} "未授权"
@@ -541,11 +577,8 @@ func (h *Handler) GetFeed(c *gin.Context) {
 	if !ok {
 		return
 	}
-	pageStr := c.DefaultQuery("page", "1")
-	pageSizeStr := c.DefaultQuery("page_size", "10")
-	page, _ := strconv.Atoi(pageStr)
-	pageSize, _ := strconv.Atoi(pageSizeStr)
-	posts, err := h.Service.Feed.GetFeed(ctx, tx, uid, page, pageSize)
+	cursor, pageSize := parseCursor(c, 10, 50)
+	posts, err := h.Service.Feed.GetFeed(ctx, tx, uid, cursor, pageSize)
 	if err != nil {
 		e.ErrorResponse(c, err)
 		return
@@ -619,25 +652,39 @@ func (h *Handler) UnbanUser(c *gin.Context) {
 // 排行榜补充
 // GetLeaderboard 获取排行榜
 // @Summary 获取排行榜
-// @Description 获取热门文章排行榜
+// @Description 获取热榜(hot)、日榜(daily)、周榜(weekly)、月榜(monthly)或飙升榜(rising)，可按类型或话题过滤
 // @Tags 文章
 // @Accept json
 // @Produce json
-// @Param limit query int false "数量限制" default(10)
+// @Param board query string false "榜单" Enums(hot,daily,weekly,monthly,rising) default(hot)
+// @Param type query int false "类型(1:文章,2:问题)"
+// @Param topic_id query int false "话题ID(优先于类型)"
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(10)
 // @Success 200 {object} map[string]interface{} "成功"
 // @Router /posts/ranking [get]
 func (h *Handler) GetLeaderboard(c *gin.Context) {
 	ctx := c.Request.Context()
 	tx := h.db
-	limitStr := c.DefaultQuery("limit", "10")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil {
-		limit = 10
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
 	}
-	if limit > 100 {
-		limit = 100
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	board := c.DefaultQuery("board", service.BoardHot)
+	postType, _ := strconv.Atoi(c.DefaultQuery("type", "0"))
+	if postType != 0 && postType != 1 && postType != 2 {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
 	}
-	posts, err := h.Service.Post.GetLeaderboard(ctx, tx, limit)
+	topicID, _ := strconv.ParseUint(c.DefaultQuery("topic_id", "0"), 10, 32)
+	posts, err := h.Service.Rank.GetBoard(ctx, tx, board, postType, uint(topicID), page, pageSize)
 	if err != nil {
 		e.ErrorResponse(c, err)
 		return
@@ -720,6 +767,45 @@ func (h *Handler) GetFollowees(c *gin.Context) {
 	e.SuccessResponse(c, users)
 }
 
+// GetRelationStatus 批量查询关系
+// @Summary 批量查询关系
+// @Description 返回当前用户与每个用户的关系：following(我关注了对方)、followed_by(对方关注了我)、mutual(互相关注)，一次最多100个
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param ids query string true "用户ID，逗号分隔"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/relations/status [get]
+func (h *Handler) GetRelationStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var ids []uint
+	for _, part := range strings.Split(c.Query("ids"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			e.ErrorResponse(c, e.ErrInvalidArgs)
+			return
+		}
+		ids = append(ids, uint(id))
+	}
+	list, err := h.Service.Relation.GetRelationStatus(ctx, tx, uid, ids)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, list)
+}
+
 // 关注收藏文章或问题
 // ToggleConn 收藏/取消收藏文章
 // @Summary 收藏/取消收藏文章
@@ -763,7 +849,7 @@ func (h *Handler) ToggleConn(c *gin.Context) {
 // @Param page_size query int false "每页数量" default(10)
 // @Success 200 {object} map[string]interface{} "成功"
 // @Failure 401 {object} map[string]interface{} "未授权"
-// @Router /user/collections [get]
+// @Router /user/connections [get]
 func (h *Handler) GetConn(c *gin.Context) {
 	ctx := c.Request.Context()
 	tx := h.db
@@ -829,14 +915,15 @@ func (h *Handler) ToggleLike(c *gin.Context) {
 // 获取通知列表
 // GetNotifications 获取通知列表
 // @Summary 获取通知列表
-// @Description 获取当前用户的系统通知列表
+// @Description 获取当前用户的通知列表，按最近触发时间倒序，可按分类筛选。同一对象上的点赞、评论和关注合并为一条，actors为最近几个触发者，actor_count为总人数，有新的人触发时该条重新变为未读并排到最前；target_type(1文章,2评论,3用户)、target_id、post_id用于跳转，snippet为对象摘要
 // @Tags 通知
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Param page query int false "页码" default(1)
+// @Param category query string false "分类：likes,comments,follows,system,messages，不传为全部"
+// @Param cursor query string false "上一页返回的next_cursor，第一页不传"
 // @Param page_size query int false "每页数量" default(10)
-// @Success 200 {object} map[string]interface{} "成功"
+// @Success 200 {array} service.NotificationVO "成功"
 // @Failure 401 {object} map[string]interface{} "未授权"
 // @Failure 500 {object} map[string]interface{} "服务器错误"
 // @Router /user/notifications [get]
@@ -847,13 +934,10 @@ func (h *Handler) GetNotifications(c *gin.Context) {
 	if !ok {
 		return
 	}
-	pageStr := c.DefaultQuery("page", "1")
-	pageSizeStr := c.DefaultQuery("page_size", "10")
-	page, _ := strconv.Atoi(pageStr)
-	pageSize, _ := strconv.Atoi(pageSizeStr)
-	list, err := h.Service.Notification.GetNotifications(ctx, tx, uid, page, pageSize)
+	cursor, pageSize := parseCursor(c, 10, 50)
+	list, err := h.Service.Notification.GetNotifications(ctx, tx, uid, c.Query("category"), cursor, pageSize)
 	if err != nil {
-		e.ErrorResponse(c, e.ErrServer)
+		e.ErrorResponse(c, err)
 		return
 	}
 	e.SuccessResponse(c, list)
@@ -862,12 +946,12 @@ func (h *Handler) GetNotifications(c *gin.Context) {
 // 红标数量
 // GetUnreadCount 获取未读通知数量
 // @Summary 获取未读通知数量
-// @Description 获取当前用户的未读系统通知数量
+// @Description 获取当前用户的未读通知总数，以及每个分类(likes,comments,follows,system,messages)的未读数
 // @Tags 通知
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Success 200 {object} map[string]interface{} "成功"
+// @Success 200 {object} service.NotificationUnreadVO "成功"
 // @Failure 401 {object} map[string]interface{} "未授权"
 // @Failure 500 {object} map[string]interface{} "服务器错误"
 // @Router /user/notifications/unread [get]
@@ -878,12 +962,12 @@ func (h *Handler) GetUnreadCount(c *gin.Context) {
 	if !ok {
 		return
 	}
-	count, err := h.Service.Notification.GetUnreadCount(ctx, tx, uid)
+	counts, err := h.Service.Notification.GetUnreadCount(ctx, tx, uid)
 	if err != nil {
-		e.ErrorResponse(c, e.ErrServer)
+		e.ErrorResponse(c, err)
 		return
 	}
-	e.SuccessResponse(c, gin.H{"unread_count": count})
+	e.SuccessResponse(c, counts)
 }
 
 // 单条已读
@@ -920,14 +1004,17 @@ func (h *Handler) MarkNotificationRead(c *gin.Context) {
 
 // MarkAllRead 全部标记已读
 // @Summary 全部标记已读
-// @Description 将当前用户的所有通知标记为已读
+// @Description 将当前用户的所有通知(或指定分类的通知)标记为已读
 // @Tags 通知
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
+// @Param category query string false "分类：likes,comments,follows,system,messages，不传为全部"
 // @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
 // @Failure 401 {object} map[string]interface{} "未授权"
-// @Router /user/notifications/read/read-all [put]
+// @Router /user/notifications/read-all [put]
+// @Router /user/notifications/read/read_all [put]
 func (h *Handler) MarkAllRead(c *gin.Context) {
 	ctx := c.Request.Context()
 	tx := h.db
@@ -935,13 +1022,80 @@ func (h *Handler) MarkAllRead(c *gin.Context) {
 	if !ok {
 		return
 	}
-	if err := h.Service.Notification.MarkAllNotificationsRead(ctx, tx, uid); err != nil {
+	if err := h.Service.Notification.MarkAllNotificationsRead(ctx, tx, uid, c.Query("category")); err != nil {
 		e.ErrorResponse(c, err)
 		return
 	}
 	e.SuccessResponse(c, nil)
 }
 
+type DeleteNotificationsRequest struct {
+	IDs []uint `json:"ids" binding:"required,min=1,max=100"`
+}
+
+// DeleteNotification 删除通知
+// @Summary 删除通知
+// @Description 删除自己的一条通知；合并的通知被删除后再有人触发会作为新通知出现
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "通知ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/notifications/{id} [delete]
+func (h *Handler) DeleteNotification(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Notification.DeleteNotification(ctx, tx, uid, id); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// DeleteNotifications 批量删除通知
+// @Summary 批量删除通知
+// @Description 一次最多100条，不属于自己的ID会被忽略，返回实际删除的条数
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body DeleteNotificationsRequest true "通知ID列表"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/notifications/delete [post]
+func (h *Handler) DeleteNotifications(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req DeleteNotificationsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	deleted, err := h.Service.Notification.DeleteNotifications(ctx, tx, uid, req.IDs)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, gin.H{"deleted": deleted})
+}
+
 // 私信通知
 type SendMsgRequest struct {
 	ReceiverID uint   `json:"receiver_id" binding:"required"`
@@ -986,7 +1140,7 @@ func (h *Handler) SendMsg(c *gin.Context) {
 // @Produce json
 // @Security ApiKeyAuth
 // @Param id path int true "对方用户ID"
-// @Param page query int false "页码" default(1)
+// @Param cursor query string false "上一页返回的next_cursor，第一页不传"
 // @Param page_size query int false "每页数量" default(20)
 // @Success 200 {object} map[string]interface{} "成功"
 // @Failure 400 {object} map[string]interface{} "请求参数错误"
@@ -1005,9 +1159,8 @@ func (h *Handler) GetChatHistory(c *gin.Context) {
 		e.ErrorResponse(c, e.ErrInvalidArgs)
 		return
 	} //换个样子
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-	history, err := h.Service.Message.GetChatHistory(ctx, tx, uid, uint(peerID), page, pageSize)
+	cursor, pageSize := parseCursor(c, 20, 100)
+	history, err := h.Service.Message.GetChatHistory(ctx, tx, uid, uint(peerID), cursor, pageSize)
 	if err != nil {
 		e.ErrorResponse(c, err)
 		return
@@ -1096,12 +1249,12 @@ func (h *Handler) GetUserProfile(c *gin.Context) {
 
 // GetUserPosts 获取指定用户文章
 // @Summary 获取指定用户文章
-// @Description 获取指定ID用户发布的公开文章列表
+// @Description 获取指定ID用户发布的公开文章列表，私密账号仅本人和关注者可见
 // @Tags 用户
 // @Accept json
 // @Produce json
 // @Param id path int true "用户ID"
-// @Param page query int false "页码" default(1)
+// @Param cursor query string false "上一页返回的next_cursor，第一页不传"
 // @Param page_size query int false "每页数量" default(10)
 // @Success 200 {object} map[string]interface{} "成功"
 // @Failure 400 {object} map[string]interface{} "请求参数错误"
@@ -1115,17 +1268,10 @@ func (h *Handler) GetUserPosts(c *gin.Context) {
 		e.ErrorResponse(c, e.ErrInvalidArgs)
 		return
 	}
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
-	if page < 1 {
-		page = 1
-	}
-	if pageSize > 50 {
-		pageSize = 50
-	}
-	posts, err := h.Service.Post.GetUserPosts(ctx, tx, targetID, page, pageSize)
+	cursor, pageSize := parseCursor(c, 10, 50)
+	posts, err := h.Service.Post.GetUserPosts(ctx, tx, targetID, getOptionalUserID(c), cursor, pageSize)
 	if err != nil {
-		e.ErrorResponse(c, e.ErrServer)
+		e.ErrorResponse(c, err)
 		return
 	}
 	e.SuccessResponse(c, posts)
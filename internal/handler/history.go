@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReportProgressRequest struct {
+	Progress *int `json:"progress" binding:"required,min=0,max=100"` //用*区分不传与传0
+}
+type HistorySettingRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// GetReadHistory 获取阅读历史
+// @Summary 获取阅读历史
+// @Description 按最近阅读时间倒序返回阅读历史，可按标题关键词搜索
+// @Tags 阅读历史
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param keyword query string false "标题关键词"
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/history [get]
+func (h *Handler) GetReadHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	page, pageSize := parsePage(c, 20, 50)
+	histories, err := h.Service.History.GetHistory(ctx, tx, uid, c.Query("keyword"), page, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, histories)
+}
+
+// GetContinueReading 继续阅读
+// @Summary 继续阅读
+// @Description 返回读了一部分还没读完的文章
+// @Tags 阅读历史
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(10)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/history/continue [get]
+func (h *Handler) GetContinueReading(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	page, pageSize := parsePage(c, 10, 50)
+	histories, err := h.Service.History.GetContinueReading(ctx, tx, uid, page, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, histories)
+}
+
+// ReportReadProgress 上报阅读进度
+// @Summary 上报阅读进度
+// @Description 客户端上报文章的阅读进度(0-100)
+// @Tags 阅读历史
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param post_id path int true "文章ID"
+// @Param data body ReportProgressRequest true "阅读进度"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Failure 404 {object} map[string]interface{} "文章不存在"
+// @Router /user/history/{post_id}/progress [put]
+func (h *Handler) ReportReadProgress(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	postID, err := parseIDParam(c, "post_id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	var req ReportProgressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.History.ReportProgress(ctx, tx, uid, postID, *req.Progress); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// DeleteReadHistory 删除一条阅读历史
+// @Summary 删除一条阅读历史
+// @Tags 阅读历史
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param post_id path int true "文章ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/history/{post_id} [delete]
+func (h *Handler) DeleteReadHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	postID, err := parseIDParam(c, "post_id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.History.DeleteHistory(ctx, tx, uid, postID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// ClearReadHistory 清空阅读历史
+// @Summary 清空阅读历史
+// @Tags 阅读历史
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/history [delete]
+func (h *Handler) ClearReadHistory(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	if err := h.Service.History.ClearHistory(ctx, tx, uid); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// SetHistoryTracking 开关阅读记录
+// @Summary 开关阅读记录
+// @Description 关闭后不再记录阅读历史，并清空已有记录
+// @Tags 阅读历史
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body HistorySettingRequest true "是否开启"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/settings/history [put]
+func (h *Handler) SetHistoryTracking(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req HistorySettingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.History.SetTracking(ctx, tx, uid, *req.Enabled); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
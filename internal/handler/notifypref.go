@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"go-zhihu/internal/service"
+	"go-zhihu/pkg/e"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationSettingsRequest struct {
+	// 键为类型名：like,comment,follow,follow_request,message
+	Types      map[string]service.NotificationPrefPatch `json:"types"`
+	QuietHours *service.QuietHours                      `json:"quiet_hours"`
+	Timezone   *string                                  `json:"timezone"`
+}
+
+// GetNotificationSettings 获取通知设置
+// @Summary 获取通知设置
+// @Description 返回每种通知的站内开关、是否只接收关注的人、邮件摘要频率(daily/weekly/never)，以及免打扰时段和时区
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} service.NotificationSettingsVO "成功"
+// @Router /user/settings/notifications [get]
+func (h *Handler) GetNotificationSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	settings, err := h.Service.Notification.GetPreferences(ctx, tx, uid)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, settings)
+}
+
+// UpdateNotificationSettings 修改通知设置
+// @Summary 修改通知设置
+// @Description 按类型名修改，没传的类型和字段保持不变。quiet_hours为本地时间"HH:MM"，开始和结束相同表示关闭，可跨0点；timezone为IANA时区名(如Asia/Shanghai)。免打扰时段内通知照常进入通知中心，但不实时推送
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body NotificationSettingsRequest true "通知设置"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/settings/notifications [put]
+func (h *Handler) UpdateNotificationSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req NotificationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Notification.UpdatePreferences(ctx, tx, uid, req.Types, req.QuietHours, req.Timezone); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// 退订确认页：GET只展示，提交表单(POST)才真正退订，避免邮件安全网关预取链接时误退订
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head><meta charset="UTF-8"><title>退订摘要邮件</title></head>
+<body style="font-family:-apple-system,'PingFang SC','Microsoft YaHei',sans-serif;max-width:480px;margin:48px auto;color:#1a1a1a;">
+{{- if .Done}}
+<p>已退订全部摘要邮件，可以随时在通知设置中重新开启。</p>
+{{- else}}
+<p>确定不再接收任何摘要邮件吗？站内通知不受影响。</p>
+<form method="post" action="?token={{.Token}}"><button type="submit">确认退订</button></form>
+{{- end}}
+</body>
+</html>`))
+
+func renderUnsubscribePage(c *gin.Context, token string, done bool) {
+	var buf bytes.Buffer
+	if err := unsubscribePage.Execute(&buf, gin.H{"Token": token, "Done": done}); err != nil {
+		e.ErrorResponse(c, e.ErrServer)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// UnsubscribeDigestPage 退订确认页
+// @Summary 退订确认页
+// @Description 摘要邮件中的退订链接指向这里，只校验token并展示确认按钮，不修改设置
+// @Tags 通知
+// @Produce html
+// @Param token query string true "邮件中的退订令牌"
+// @Success 200 {string} string "确认页"
+// @Failure 400 {object} map[string]interface{} "退订链接无效"
+// @Router /notifications/unsubscribe [get]
+func (h *Handler) UnsubscribeDigestPage(c *gin.Context) {
+	token := c.Query("token")
+	if _, err := h.Service.Digest.VerifyUnsubscribe(token); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	renderUnsubscribePage(c, token, false)
+}
+
+// UnsubscribeDigest 退订摘要邮件
+// @Summary 退订摘要邮件
+// @Description 不需要登录，凭token把所有类型的邮件摘要改为never；确认页的表单和邮件客户端的一键退订(RFC 8058 List-Unsubscribe-Post)都走这里
+// @Tags 通知
+// @Produce html
+// @Param token query string true "邮件中的退订令牌"
+// @Success 200 {string} string "退订成功页"
+// @Failure 400 {object} map[string]interface{} "退订链接无效"
+// @Router /notifications/unsubscribe [post]
+func (h *Handler) UnsubscribeDigest(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	token := c.Query("token")
+	if err := h.Service.Digest.Unsubscribe(ctx, tx, token); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	renderUnsubscribePage(c, token, true)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PrivacySettingRequest struct {
+	Private *bool `json:"private" binding:"required"`
+}
+
+// UpdatePrivacySettings 设置私密账号
+// @Summary 设置私密账号
+// @Description 私密账号的文章只有本人和已通过的关注者可见，别人关注需要审核；改回公开时自动通过所有待审核的请求
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body PrivacySettingRequest true "是否私密"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/settings/privacy [put]
+func (h *Handler) UpdatePrivacySettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req PrivacySettingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Relation.SetPrivate(ctx, tx, uid, *req.Private); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// GetFollowRequests 待审核的关注请求
+// @Summary 待审核的关注请求
+// @Description 获取别人发给当前用户的关注请求，按申请时间倒序
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Router /user/follow-requests [get]
+func (h *Handler) GetFollowRequests(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	page, pageSize := parsePage(c, 20, 100)
+	users, err := h.Service.Relation.ListFollowRequests(ctx, tx, uid, page, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, users)
+}
+
+// ApproveFollowRequest 通过关注请求
+// @Summary 通过关注请求
+// @Description 通过指定用户的关注请求，对方成为粉丝并收到通知
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "申请人ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求不存在"
+// @Router /user/follow-requests/{id}/approve [post]
+func (h *Handler) ApproveFollowRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	requesterID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Relation.ApproveFollowRequest(ctx, tx, uid, requesterID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// RejectFollowRequest 拒绝关注请求
+// @Summary 拒绝关注请求
+// @Description 拒绝指定用户的关注请求，不会通知对方
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "申请人ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求不存在"
+// @Router /user/follow-requests/{id}/reject [post]
+func (h *Handler) RejectFollowRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	requesterID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Relation.RejectFollowRequest(ctx, tx, uid, requesterID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
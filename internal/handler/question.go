@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FollowQuestion 关注问题
+// @Summary 关注问题
+// @Description 关注问题后，问题有新回答时会出现在邮件摘要里，关注的人的动态中显示"关注了问题"。和收藏互相独立，收藏不会产生动态
+// @Tags 互动
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "问题ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/questions/{id}/follow [post]
+func (h *Handler) FollowQuestion(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	postID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Interaction.FollowQuestion(ctx, tx, uid, postID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// UnfollowQuestion 取消关注问题
+// @Summary 取消关注问题
+// @Description 取消关注问题，同时撤回"关注了问题"的动态
+// @Tags 互动
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "问题ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/questions/{id}/unfollow [post]
+func (h *Handler) UnfollowQuestion(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	postID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Interaction.UnfollowQuestion(ctx, tx, uid, postID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
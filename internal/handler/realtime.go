@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RealtimeWebSocket 实时推送(websocket)
+// @Summary 实时推送(websocket)
+// @Description 升级为websocket后推送json消息{"type","data"}：ready连接就绪，notification新通知，message新私信，unread未读数，typing对方正在输入，ping心跳(回{"type":"pong"}，两个心跳周期内没有任何消息会被断开)，error上一条消息处理失败。
+// @Description 客户端可发送{"type":"typing","to":对方ID}。浏览器无法设置请求头时token放在access_token参数中。消费过慢的连接会被断开，重连后以推送的unread为准
+// @Tags 实时推送
+// @Produce json
+// @Security ApiKeyAuth
+// @Param access_token query string false "JWT，不方便设置Authorization头时使用"
+// @Success 101 {string} string "切换协议"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /realtime/ws [get]
+func (h *Handler) RealtimeWebSocket(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	client, err := h.Service.Realtime.Connect(ctx, tx, uid)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	defer h.Service.Realtime.Disconnect(client)
+	h.Service.Realtime.ServeWebSocket(ctx, tx, c.Writer, c.Request, client)
+}
+
+// RealtimeSSE 实时推送(SSE)
+// @Summary 实时推送(SSE)
+// @Description websocket不可用时的降级方案，text/event-stream，每条data与websocket推送的json相同，心跳为注释行。正在输入改用POST /user/messages/{id}/typing
+// @Tags 实时推送
+// @Produce text/event-stream
+// @Security ApiKeyAuth
+// @Param access_token query string false "JWT，EventSource无法设置请求头时使用"
+// @Success 200 {string} string "事件流"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /realtime/sse [get]
+func (h *Handler) RealtimeSSE(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	client, err := h.Service.Realtime.Connect(ctx, tx, uid)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	defer h.Service.Realtime.Disconnect(client)
+	if err := h.Service.Realtime.ServeSSE(c.Writer, c.Request, client); err != nil {
+		e.ErrorResponse(c, err)
+	}
+}
+
+// SendTyping 正在输入
+// @Summary 正在输入
+// @Description 告诉对方自己正在输入，对方在线时收到typing推送；同一对用户之间有节流，给SSE客户端使用
+// @Tags 消息
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "对方用户ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/messages/{id}/typing [post]
+func (h *Handler) SendTyping(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	peerID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Realtime.Typing(ctx, tx, uid, peerID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
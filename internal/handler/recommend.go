@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRecommend 获取推荐流
+// @Summary 获取推荐流
+// @Description 综合热门、感兴趣的话题和相似用户的点赞给出推荐，已读过的不再出现，每次刷新返回新内容
+// @Tags 推荐
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page_size query int false "每页数量" default(10)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/recommend [get]
+func (h *Handler) GetRecommend(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	_, pageSize := parsePage(c, 10, 30)
+	posts, err := h.Service.Recommend.GetRecommend(ctx, tx, uid, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, posts)
+}
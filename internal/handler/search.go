@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"go-zhihu/internal/service"
+	"go-zhihu/pkg/e"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 日期过滤参数的格式，end当天也包含在内
+const searchDateLayout = "2006-01-02"
+
+// UnifiedSearch 综合搜索
+// @Summary 综合搜索
+// @Description 按关键词搜索文章、问题、用户和话题；type为all时按类型分组各返回前几条。作者、时间和文章类型过滤只对文章和问题生效，结果中带高亮片段(<em>标记)
+// @Tags 搜索
+// @Accept json
+// @Produce json
+// @Param keyword query string true "搜索关键词"
+// @Param type query string false "all/post/question/user/topic" default(all)
+// @Param sort query string false "relevance/time/hot" default(relevance)
+// @Param author_id query int false "作者ID"
+// @Param post_type query int false "文章类型(1:文章,2:问题)"
+// @Param start query string false "开始日期，如2024-01-01"
+// @Param end query string false "结束日期(含当天)，如2024-01-31"
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(10)
+// @Success 200 {object} service.SearchResultVO "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /search [get]
+func (h *Handler) UnifiedSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	page, pageSize := parsePage(c, 10, 50)
+	req := service.SearchRequest{
+		Keyword:  c.Query("keyword"),
+		Type:     c.Query("type"),
+		Sort:     c.Query("sort"),
+		ViewerID: getOptionalUserID(c),
+		Page:     page,
+		PageSize: pageSize,
+	}
+	if v := c.Query("author_id"); v != "" {
+		authorID, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			e.ErrorResponse(c, e.ErrInvalidArgs)
+			return
+		}
+		req.AuthorID = uint(authorID)
+	}
+	if v := c.Query("post_type"); v != "" {
+		postType, err := strconv.Atoi(v)
+		if err != nil {
+			e.ErrorResponse(c, e.ErrInvalidArgs)
+			return
+		}
+		req.PostType = postType
+	}
+	if v := c.Query("start"); v != "" {
+		start, err := time.ParseInLocation(searchDateLayout, v, time.Local)
+		if err != nil {
+			e.ErrorResponse(c, e.ErrInvalidArgs)
+			return
+		}
+		req.Start = start
+	}
+	if v := c.Query("end"); v != "" {
+		end, err := time.ParseInLocation(searchDateLayout, v, time.Local)
+		if err != nil {
+			e.ErrorResponse(c, e.ErrInvalidArgs)
+			return
+		}
+		req.End = end.AddDate(0, 0, 1)
+	}
+	result, err := h.Service.Search.Search(ctx, tx, req)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, result)
+}
+
+// GetSearchSuggestions 搜索联想词
+// @Summary 搜索联想词
+// @Description 按输入的前缀返回联想词，来源为热门文章标题、话题名和用户名
+// @Tags 搜索
+// @Accept json
+// @Produce json
+// @Param prefix query string true "已输入的前缀"
+// @Param limit query int false "条数(最多20)" default(10)
+// @Success 200 {array} service.SearchSuggestionVO "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /search/suggest [get]
+func (h *Handler) GetSearchSuggestions(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	list, err := h.Service.Search.Suggest(ctx, c.Query("prefix"), limit)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, list)
+}
+
+// GetTrendingSearches 热搜榜
+// @Summary 热搜榜
+// @Description 最近一段时间搜索最多的词，越近的搜索权重越高，管理员屏蔽的词不展示
+// @Tags 搜索
+// @Accept json
+// @Produce json
+// @Param limit query int false "条数(最多50)" default(10)
+// @Success 200 {array} service.TrendingQueryVO "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /search/trending [get]
+func (h *Handler) GetTrendingSearches(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	list, err := h.Service.Search.GetTrending(ctx, limit)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, list)
+}
+
+type SuppressQueryRequest struct {
+	Query string `json:"query" binding:"required"`
+}
+
+// GetSuppressedSearches 热搜屏蔽词列表
+// @Summary 热搜屏蔽词列表
+// @Description 管理员查看被屏蔽在热搜之外的词
+// @Tags 搜索管理
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} string "成功"
+// @Router /admin/search/suppressed [get]
+func (h *Handler) GetSuppressedSearches(c *gin.Context) {
+	ctx := c.Request.Context()
+	queries, err := h.Service.Search.ListSuppressed(ctx)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, queries)
+}
+
+// SuppressSearch 屏蔽热搜词
+// @Summary 屏蔽热搜词
+// @Description 被屏蔽的词不再出现在热搜榜上，但仍然可以正常搜索
+// @Tags 搜索管理
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body SuppressQueryRequest true "要屏蔽的词"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /admin/search/suppressed [post]
+func (h *Handler) SuppressSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req SuppressQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Search.SuppressTrending(ctx, req.Query); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
+
+// UnsuppressSearch 取消屏蔽热搜词
+// @Summary 取消屏蔽热搜词
+// @Description 恢复被屏蔽的词在热搜榜上的展示
+// @Tags 搜索管理
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param query query string true "要取消屏蔽的词"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /admin/search/suppressed [delete]
+func (h *Handler) UnsuppressSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	if err := h.Service.Search.UnsuppressTrending(ctx, c.Query("query")); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DuplicateCheckRequest struct {
+	Title   string `json:"title" binding:"required"`
+	Content string `json:"content"`
+}
+type MergePostRequest struct {
+	TargetID uint `json:"target_id" binding:"required"`
+}
+
+// CheckDuplicates 提问查重
+// @Summary 提问查重
+// @Description 发布问题前按标题和内容找出可能重复的已发布问题，按相似度从高到低
+// @Tags 文章
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param data body DuplicateCheckRequest true "问题标题和内容"
+// @Success 200 {array} service.SimilarPostVO "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/posts/duplicates [post]
+func (h *Handler) CheckDuplicates(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	var req DuplicateCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	list, err := h.Service.Similar.FindDuplicates(ctx, tx, uid, req.Title, req.Content)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, list)
+}
+
+// GetPostDuplicates 查看重复问题
+// @Summary 查看重复问题
+// @Description 管理员查看与指定问题可能重复的问题，用于合并
+// @Tags 文章管理
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "问题ID"
+// @Success 200 {array} service.SimilarPostVO "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /admin/posts/{id}/duplicates [get]
+func (h *Handler) GetPostDuplicates(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	postID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	list, err := h.Service.Similar.DuplicatesOf(ctx, tx, postID)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, list)
+}
+
+// MergePost 合并重复问题
+// @Summary 合并重复问题
+// @Description 管理员把指定问题合并到target_id：回答和收藏移过去，原问题不再单独展示，打开时返回合并后的问题，并通知原提问者
+// @Tags 文章管理
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "被合并的问题ID"
+// @Param data body MergePostRequest true "保留的问题ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /admin/posts/{id}/merge [post]
+func (h *Handler) MergePost(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	postID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	var req MergePostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Similar.MergeDuplicate(ctx, tx, uid, postID, req.TargetID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuthorStats 获取作者数据统计
+// @Summary 获取作者数据统计
+// @Description 按天统计当前用户文章的阅读、点赞、评论、收藏以及新增粉丝
+// @Tags 用户
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param days query int false "统计天数(最多90)" default(30)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/stats [get]
+func (h *Handler) GetAuthorStats(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	stats, err := h.Service.Stats.GetAuthorStats(ctx, tx, uid, days)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, stats)
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"go-zhihu/pkg/e"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSuggestions 可能认识的人
+// @Summary 可能认识的人
+// @Description 根据关注的人还关注了谁、感兴趣的话题和赞过相同文章的人推荐用户，每项带推荐理由；已关注和有拉黑关系的人不会出现
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page_size query int false "数量" default(10)
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 401 {object} map[string]interface{} "未授权"
+// @Router /user/suggestions [get]
+func (h *Handler) GetSuggestions(c *gin.Context) {
+	ctx := c.Request.Context()
+	tx := h.db
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	_, pageSize := parsePage(c, 10, 50)
+	list, err := h.Service.Suggest.GetSuggestions(ctx, tx, uid, pageSize)
+	if err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, list)
+}
+
+// DismissSuggestion 不感兴趣
+// @Summary 不感兴趣
+// @Description 以后不再推荐这个用户
+// @Tags 用户关系
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]interface{} "成功"
+// @Failure 400 {object} map[string]interface{} "请求参数错误"
+// @Router /user/suggestions/{id}/dismiss [post]
+func (h *Handler) DismissSuggestion(c *gin.Context) {
+	ctx := c.Request.Context()
+	uid, ok := getUserID(c)
+	if !ok {
+		return
+	}
+	targetID, err := parseIDParam(c, "id")
+	if err != nil {
+		e.ErrorResponse(c, e.ErrInvalidArgs)
+		return
+	}
+	if err := h.Service.Suggest.Dismiss(ctx, uid, targetID); err != nil {
+		e.ErrorResponse(c, err)
+		return
+	}
+	e.SuccessResponse(c, nil)
+}
@@ -0,0 +1,346 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-zhihu/config"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 基于redis stream的任务队列：消费组保证多实例只处理一次，失败按指数退避重试，超过次数进入死信
+const (
+	StreamKey  = "job:stream"
+	DelayedKey = "job:delayed"
+	DeadKey    = "job:dead"
+	GroupName  = "job-workers"
+	// 死信只保留最近的一部分，方便排查
+	deadMaxLen = 10000
+)
+
+// Handler 处理一种任务，返回错误时会重试
+type Handler func(ctx context.Context, payload []byte) error
+
+// 在stream和延迟队列之间流转的任务
+type Job struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Attempt int             `json:"attempt"`
+	// 延迟队列是zset，ID保证同样内容的两次重试不会被合并
+	ID string `json:"id,omitempty"`
+}
+
+// 到期的重试任务从延迟队列搬回stream：ZREM成功的调用方才写入，两步在一个脚本里完成，
+// 不会出现已经移出延迟队列却没写进stream的情况，多实例也只会搬一次
+var moveDelayedScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 1 then
+	redis.call("XADD", KEYS[2], "*", "type", ARGV[2], "payload", ARGV[3], "attempt", ARGV[4])
+	return 1
+end
+return 0`)
+
+type Queue struct {
+	rdb      *redis.Client
+	consumer string
+	cfg      config.JobConfig
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	wg       sync.WaitGroup
+}
+
+func NewQueue(rdb *redis.Client, cfg config.JobConfig) *Queue {
+	host, _ := os.Hostname()
+	return &Queue{
+		rdb:      rdb,
+		consumer: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		cfg:      cfg,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// 注册任务处理函数，需在Start之前调用
+func (q *Queue) Register(jobType string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+}
+
+// 投递任务，payload序列化为json
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return q.add(ctx, &Job{Type: jobType, Payload: raw})
+}
+
+// 不经过队列直接执行任务，投递失败(如redis不可用)时的兜底；同样有超时和panic保护
+func (q *Queue) RunInline(jobType string, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	q.mu.RLock()
+	handler, ok := q.handlers[jobType]
+	q.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for job type %q", jobType)
+	}
+	return q.run(handler, &Job{Type: jobType, Payload: raw})
+}
+func (q *Queue) add(ctx context.Context, job *Job) error {
+	return q.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: StreamKey,
+		Values: map[string]interface{}{
+			"type":    job.Type,
+			"payload": string(job.Payload),
+			"attempt": job.Attempt,
+		},
+	}).Err()
+}
+
+// 启动消费者、延迟队列搬运和超时任务认领，ctx取消后不再拉取新任务，已在处理的任务继续跑完
+func (q *Queue) Start(ctx context.Context) error {
+	err := q.rdb.XGroupCreateMkStream(ctx, StreamKey, GroupName, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	workers := q.cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+	q.wg.Add(2)
+	go q.moveDelayed(ctx)
+	go q.reclaim(ctx)
+	return nil
+}
+
+// 等待所有后台协程退出，超时返回false
+func (q *Queue) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+	for ctx.Err() == nil {
+		streams, err := q.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    GroupName,
+			Consumer: q.consumer,
+			Streams:  []string{StreamKey, ">"},
+			Count:    10,
+			Block:    2 * time.Second,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			log.Printf("job: read stream failed:%v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				q.process(msg)
+			}
+		}
+	}
+}
+
+// 处理一条消息，成功或失败后已放入延迟队列/死信时才ack；重试和死信都写不进去时不ack，
+// 消息留在pending里，空闲超时后由reclaim重新认领处理
+// 用独立的context，关闭时正在处理的任务能跑完
+func (q *Queue) process(msg redis.XMessage) {
+	ctx := context.Background()
+	job := parseMessage(msg)
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler for job type %q", job.Type)
+	} else {
+		err = q.run(handler, job)
+	}
+	if err != nil {
+		if err := q.fail(ctx, job, err, ok); err != nil {
+			log.Printf("job: keep %s pending:%v", msg.ID, err)
+			return
+		}
+	}
+	if err := q.rdb.XAck(ctx, StreamKey, GroupName, msg.ID).Err(); err != nil {
+		log.Printf("job: ack %s failed:%v", msg.ID, err)
+		return
+	}
+	q.rdb.XDel(ctx, StreamKey, msg.ID)
+}
+
+// 执行handler，panic按失败处理
+func (q *Queue) run(handler Handler, job *Job) (err error) {
+	timeout := time.Duration(q.cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job.Payload)
+}
+
+// 失败的任务放入延迟队列等待重试，超过次数或不可重试的写入死信；写入失败时返回错误
+func (q *Queue) fail(ctx context.Context, job *Job, cause error, retryable bool) error {
+	job.Attempt++
+	if !retryable || job.Attempt >= q.cfg.MaxAttempts {
+		log.Printf("job: %s dead after %d attempts:%v", job.Type, job.Attempt, cause)
+		err := q.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: DeadKey,
+			MaxLen: deadMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"type":    job.Type,
+				"payload": string(job.Payload),
+				"attempt": job.Attempt,
+				"error":   cause.Error(),
+			},
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("write dead letter: %w", err)
+		}
+		return nil
+	}
+	job.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	raw, _ := json.Marshal(job)
+	retryAt := time.Now().Add(q.backoff(job.Attempt))
+	if err := q.rdb.ZAdd(ctx, DelayedKey, &redis.Z{Score: float64(retryAt.Unix()), Member: raw}).Err(); err != nil {
+		return fmt.Errorf("schedule retry: %w", err)
+	}
+	return nil
+}
+
+// 指数退避：base * 2^(attempt-1)，不超过max
+func (q *Queue) backoff(attempt int) time.Duration {
+	base := float64(q.cfg.BackoffSeconds)
+	if base <= 0 {
+		base = 1
+	}
+	delay := base * math.Pow(2, float64(attempt-1))
+	if max := float64(q.cfg.MaxBackoffSeconds); max > 0 && delay > max {
+		delay = max
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// 把到期的重试任务搬回stream，搬运由moveDelayedScript原子完成
+func (q *Queue) moveDelayed(ctx context.Context) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		due, err := q.rdb.ZRangeByScore(ctx, DelayedKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().Unix(), 10),
+			Count: 100,
+		}).Result()
+		if err != nil {
+			continue
+		}
+		for _, raw := range due {
+			var job Job
+			if err := json.Unmarshal([]byte(raw), &job); err != nil {
+				log.Printf("job: bad delayed job:%v", err)
+				q.rdb.ZRem(ctx, DelayedKey, raw)
+				continue
+			}
+			err := moveDelayedScript.Run(ctx, q.rdb, []string{DelayedKey, StreamKey}, raw, job.Type, string(job.Payload), job.Attempt).Err()
+			if err != nil {
+				// 脚本没执行成功时任务还在延迟队列里，下次再搬
+				log.Printf("job: requeue failed:%v", err)
+			}
+		}
+	}
+}
+
+// 其他实例崩溃时留在pending里的任务，空闲超过ClaimIdleSeconds后由本实例认领处理
+func (q *Queue) reclaim(ctx context.Context) {
+	defer q.wg.Done()
+	idle := time.Duration(q.cfg.ClaimIdleSeconds) * time.Second
+	if idle <= 0 {
+		idle = time.Minute
+	}
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := "0-0"
+		for ctx.Err() == nil {
+			msgs, next, err := q.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   StreamKey,
+				Group:    GroupName,
+				Consumer: q.consumer,
+				MinIdle:  idle,
+				Start:    start,
+				Count:    50,
+			}).Result()
+			if err != nil {
+				log.Printf("job: autoclaim failed:%v", err)
+				break
+			}
+			for _, msg := range msgs {
+				q.process(msg)
+			}
+			if next == "0-0" || len(msgs) == 0 {
+				break
+			}
+			start = next
+		}
+	}
+}
+
+func parseMessage(msg redis.XMessage) *Job {
+	job := &Job{}
+	if v, ok := msg.Values["type"].(string); ok {
+		job.Type = v
+	}
+	if v, ok := msg.Values["payload"].(string); ok {
+		job.Payload = json.RawMessage(v)
+	}
+	if v, ok := msg.Values["attempt"].(string); ok {
+		job.Attempt, _ = strconv.Atoi(v)
+	}
+	return job
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer 不真正发信，每封邮件写成dir下的一个.eml文件，本地调试和测试时用邮件客户端直接打开查看
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+func NewFileMailer(from, dir string) *FileMailer {
+	return &FileMailer{from: from, dir: dir}
+}
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	raw, err := buildMessage(m.from, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	// 收件人地址里的特殊字符不进文件名
+	to := strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, msg.To)
+	name := fmt.Sprintf("%s_%s_%s.eml", time.Now().Format("20060102T150405"), to, randomID()[:6])
+	return os.WriteFile(filepath.Join(m.dir, name), raw, 0o644)
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"go-zhihu/config"
+	"net/mail"
+	"net/smtp"
+)
+
+// SMTPMailer 通过SMTP发信，配置了用户名时使用PLAIN认证(服务器支持时net/smtp会自动STARTTLS)
+type SMTPMailer struct {
+	cfg config.MailConfig
+}
+
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg}
+}
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	raw, err := buildMessage(m.cfg.From, msg)
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)
+	}
+	addr := fmt.Sprintf("%s:%d", m.cfg.SMTPHost, m.cfg.SMTPPort)
+	return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, raw)
+}
@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// 邮件模板：每种邮件一对<name>.html.tmpl和<name>.txt.tmpl，编译进二进制
+//
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html.tmpl"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt.tmpl"))
+)
+
+// Render 用同一份数据渲染HTML和纯文本两个版本
+func Render(name string, data interface{}) (html, text string, err error) {
+	var h, t bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&h, name+".html.tmpl", data); err != nil {
+		return "", "", err
+	}
+	if err := textTemplates.ExecuteTemplate(&t, name+".txt.tmpl", data); err != nil {
+		return "", "", err
+	}
+	return h.String(), t.String(), nil
+}
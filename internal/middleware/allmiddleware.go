@@ -55,6 +55,57 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// 可选登录：带了合法token就写入用户信息，没带或无效按游客处理
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if tokenString == "" {
+			c.Next()
+			return
+		}
+		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+			return []byte(config.Setting.JWT.Secret), nil
+		})
+		if err == nil && token.Valid {
+			if claims, ok := token.Claims.(*Claims); ok {
+				c.Set("user_id", claims.ID)
+				c.Set("username", claims.Username)
+				c.Set("role", claims.Role)
+			}
+		}
+		c.Next()
+	}
+}
+
+// 实时推送的登录校验：浏览器的WebSocket和EventSource无法设置请求头，token也可以放在access_token参数里
+func StreamAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if tokenString == "" {
+			tokenString = c.Query("access_token")
+		}
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "需要登录"})
+			c.Abort()
+			return
+		}
+		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+			return []byte(config.Setting.JWT.Secret), nil
+		})
+		if err != nil || !token.Valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token invalid"})
+			c.Abort()
+			return
+		}
+		if claims, ok := token.Claims.(*Claims); ok {
+			c.Set("user_id", claims.ID)
+			c.Set("username", claims.Username)
+			c.Set("role", claims.Role)
+		}
+		c.Next()
+	}
+}
+
 func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("role")
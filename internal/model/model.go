@@ -25,36 +25,102 @@ type Post struct {
 	Content  string `gorm:"type:longtext;not null;comment:内容(Markdown/HTML)" json:"content"`
 	Type     int    `gorm:"type:tinyint;not null;default:1;comment:类型(1:文章,2:问题)" json:"type"`
 	AuthorID uint   `gorm:"not null;index:idx_author;comment:作者ID" json:"authorID"`
-	Status   int    `gorm:"type:tinyint;not null;default:1;comment:状态(0:草稿,1:已发布,2:已删除)" json:"status"`
+	Status   int    `gorm:"type:tinyint;not null;default:1;comment:状态(0:草稿,1:已发布,2:已删除,3:已合并)" json:"status"`
+	// 重复的问题被合并后指向保留的那个问题
+	MergedIntoID uint `gorm:"not null;default:0;comment:合并到的问题ID" json:"merged_into_id,omitempty"`
 
-	Hotscore float64   `gorm:"type:float;default:0;comment:热度分数" json:"hot_score"`
-	Author   User      `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
-	Comments []Comment `gorm:"foreignKey:postID" json:"comments,omitempty"`
+	Hotscore  float64   `gorm:"column:hot_score;type:float;default:0;comment:热度分数" json:"hot_score"`
+	ViewCount int64     `gorm:"not null;default:0;comment:阅读数" json:"view_count"`
+	Author    User      `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Comments  []Comment `gorm:"foreignKey:postID" json:"comments,omitempty"`
+}
+
+// 话题
+type Topic struct {
+	gorm.Model
+	Name        string `gorm:"type:varchar(64);uniqueIndex;not null;comment:话题名" json:"name"`
+	Description string `gorm:"type:varchar(255);comment:话题简介" json:"description"`
+}
+
+// 文章与话题的关联
+type PostTopic struct {
+	ID      uint `gorm:"primaryKey"`
+	PostID  uint `gorm:"not null;uniqueIndex:idx_post_topic;comment:文章ID" json:"post_id"`
+	TopicID uint `gorm:"not null;uniqueIndex:idx_post_topic;index:idx_topic;comment:话题ID" json:"topic_id"`
 }
 
 // 用户
 type User struct {
 	gorm.Model
-	Username string    `gorm:"type:varchar(32);uniqueIndex;not null;comment:用户名" json:"username"`
-	Password string    `gorm:"type:varchar(128);not null;comment:密码(加盐hash)" json:"-"`
-	Email    string    `gorm:"type:varchar(64);uniqueIndex;comment:邮箱" json:"email"`
-	Avatar   string    `gorm:"type:varchar(255);comment:头像URL" json:"avatar"`
-	Bio      string    `gorm:"type:varchar(255);comment:头像URL" json:"bio"`
-	Role     int       `gorm:"type:tinyint;default:1;comment:角色(1:普通用户,2:管理员)" json:"role"`
-	Status   int       `gorm:"type:tinyint;default:1;comment;状态(0:禁言,1:正常)" json:"status"`
-	Posts    []Post    `gorm:"foreignKey:AuthorID" json:"posts,omitempty"`
-	Comments []Comment `gorm:"foreignKey:AuthorID" json:"comments,omitempty"`
-}
-
-// 用户关系
+	Username string `gorm:"type:varchar(32);uniqueIndex;not null;comment:用户名" json:"username"`
+	Password string `gorm:"type:varchar(128);not null;comment:密码(加盐hash)" json:"-"`
+	Email    string `gorm:"type:varchar(64);uniqueIndex;comment:邮箱" json:"email"`
+	Avatar   string `gorm:"type:varchar(255);comment:头像URL" json:"avatar"`
+	Bio      string `gorm:"type:varchar(255);comment:头像URL" json:"bio"`
+	Role     int    `gorm:"type:tinyint;default:1;comment:角色(1:普通用户,2:管理员)" json:"role"`
+	Status   int    `gorm:"type:tinyint;default:1;comment;状态(0:禁言,1:正常)" json:"status"`
+
+	HistoryDisabled bool      `gorm:"default:false;comment:是否关闭阅读历史记录" json:"history_disabled"`
+	HiddenActivity  int       `gorm:"not null;default:0;comment:不看的动态类型(按位,1<<类型)" json:"-"`
+	FollowerCount   int64     `gorm:"not null;default:0;comment:粉丝数" json:"follower_count"`
+	FolloweeCount   int64     `gorm:"not null;default:0;comment:关注数" json:"followee_count"`
+	IsPrivate       bool      `gorm:"default:false;comment:是否私密账号(关注需审核，文章仅关注者可见)" json:"is_private"`
+	QuietStart      int       `gorm:"not null;default:0;comment:免打扰开始(本地时间从0点起的分钟数，与结束相同表示关闭)" json:"-"`
+	QuietEnd        int       `gorm:"not null;default:0;comment:免打扰结束(分钟)" json:"-"`
+	Timezone        string    `gorm:"type:varchar(64);comment:IANA时区名，为空时用配置中的默认时区" json:"-"`
+	Posts           []Post    `gorm:"foreignKey:AuthorID" json:"posts,omitempty"`
+	Comments        []Comment `gorm:"foreignKey:AuthorID" json:"comments,omitempty"`
+}
+
+// 用户关系，同一对用户只有一条，取关时物理删除
 type Relation struct {
 	gorm.Model
-	FollowerID uint `gorm:"not null;index:idx_follower;comment:粉丝ID" json:"follower_id"`
-	FolloweeID uint `gorm:"not null;index:idx_followee;comment:被关注者ID" json:"followee_id"`
+	FollowerID uint `gorm:"not null;uniqueIndex:idx_follower_followee;comment:粉丝ID" json:"follower_id"`
+	FolloweeID uint `gorm:"not null;uniqueIndex:idx_follower_followee;index:idx_followee;comment:被关注者ID" json:"followee_id"`
 	Follower   User `gorm:"foreignKey:FollowerID" json:"follower,omitempty"`
 	Followee   User `gorm:"foreignKey:FolloweeID" json:"followee,omitempty"`
 }
 
+// 关注私密账号的待审核请求，通过后转为Relation，拒绝后删除
+type FollowRequest struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	RequesterID uint      `gorm:"not null;uniqueIndex:idx_requester_target;comment:申请者ID" json:"requester_id"`
+	TargetID    uint      `gorm:"not null;uniqueIndex:idx_requester_target;index:idx_target;comment:被申请关注的用户ID" json:"target_id"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// 文章的MinHash签名，用于找相似文章和重复问题
+type PostSignature struct {
+	PostID    uint      `gorm:"primaryKey;autoIncrement:false" json:"post_id"`
+	Signature []byte    `gorm:"type:blob;not null;comment:MinHash签名" json:"-"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// 签名按LSH分段后的桶，同一段落在同一个桶的文章互为候选
+type PostLSHBucket struct {
+	ID     uint   `gorm:"primaryKey"`
+	PostID uint   `gorm:"not null;index:idx_post;comment:文章ID"`
+	Band   int    `gorm:"not null;index:idx_band_bucket,priority:1;comment:段号"`
+	Bucket uint64 `gorm:"not null;index:idx_band_bucket,priority:2;comment:桶号"`
+}
+
+// 搜索日志，每次搜索第一页时记录一条，用于分析搜索词
+type SearchLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;default:0;index:idx_user;comment:搜索者ID(游客为0)" json:"user_id"`
+	Query     string    `gorm:"type:varchar(100);not null;index:idx_query;comment:归一化后的搜索词" json:"query"`
+	Type      string    `gorm:"type:varchar(16);not null;comment:搜索类型" json:"type"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index:idx_created" json:"created_at"`
+}
+
+// 拉黑，双方互相看不到对方的内容，也不能互相关注、评论、点赞、私信
+type Block struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	BlockerID uint      `gorm:"not null;uniqueIndex:idx_blocker_blocked;comment:拉黑者ID" json:"blocker_id"`
+	BlockedID uint      `gorm:"not null;uniqueIndex:idx_blocker_blocked;index:idx_blocked;comment:被拉黑者ID" json:"blocked_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
 // 点赞
 type Like struct {
 	gorm.Model
@@ -65,25 +131,127 @@ type Like struct {
 	User User `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
 
-// 收藏
+// 收藏，同一篇文章可以收藏到多个收藏夹
 type Connection struct {
 	ID        uint      `gorm:"primaryKey"`
-	UserID    uint      `gorm:"index;not null"`
-	PostID    uint      `gorm:"index;not null"`
+	UserID    uint      `gorm:"index;not null;uniqueIndex:idx_user_folder_post,priority:1"`
+	PostID    uint      `gorm:"index;not null;uniqueIndex:idx_user_folder_post,priority:3"`
+	FolderID  uint      `gorm:"index;not null;default:0;uniqueIndex:idx_user_folder_post,priority:2;comment:收藏夹ID(0为迁移前的旧数据)"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 }
 
+// 收藏夹
+type Folder struct {
+	gorm.Model
+	UserID        uint   `gorm:"not null;index;comment:创建者ID" json:"user_id"`
+	Name          string `gorm:"type:varchar(64);not null;comment:收藏夹名称" json:"name"`
+	Description   string `gorm:"type:varchar(255);comment:收藏夹描述" json:"description"`
+	IsPublic      bool   `gorm:"default:false;comment:是否公开" json:"is_public"`
+	IsDefault     bool   `gorm:"default:false;comment:是否默认收藏夹" json:"is_default"`
+	PostCount     int64  `gorm:"not null;default:0;comment:收藏数" json:"post_count"`
+	FollowerCount int64  `gorm:"not null;default:0;comment:关注人数" json:"follower_count"`
+}
+
+// 关注收藏夹
+type FolderFollow struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_folder;comment:关注者ID" json:"user_id"`
+	FolderID  uint      `gorm:"not null;uniqueIndex:idx_user_folder;index;comment:收藏夹ID" json:"folder_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// 关注的问题，和收藏分开：收藏是私人的，关注问题会记为一条动态
+type QuestionFollow struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_question;comment:关注者ID" json:"user_id"`
+	PostID    uint      `gorm:"not null;uniqueIndex:idx_user_question;index;comment:问题ID" json:"post_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+const DefaultFolderName = "默认收藏夹"
+
+// 文章每日阅读量，用于作者数据统计
+type PostDailyStat struct {
+	ID       uint      `gorm:"primaryKey"`
+	PostID   uint      `gorm:"not null;uniqueIndex:idx_post_day;comment:文章ID" json:"post_id"`
+	AuthorID uint      `gorm:"not null;index:idx_author_day;comment:作者ID" json:"author_id"`
+	Day      time.Time `gorm:"type:date;not null;uniqueIndex:idx_post_day;index:idx_author_day;comment:日期" json:"day"`
+	Views    int64     `gorm:"not null;default:0;comment:当日阅读数" json:"views"`
+}
+
+// 阅读历史，同一篇文章只保留最近一次
+type ReadHistory struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	UserID   uint      `gorm:"not null;uniqueIndex:idx_user_post;index:idx_user_read,priority:1;comment:用户ID" json:"user_id"`
+	PostID   uint      `gorm:"not null;uniqueIndex:idx_user_post;comment:文章ID" json:"post_id"`
+	Progress int       `gorm:"type:tinyint;not null;default:0;comment:阅读进度(0-100)" json:"progress"`
+	ReadAt   time.Time `gorm:"not null;index:idx_user_read,priority:2;comment:最近阅读时间" json:"read_at"`
+	Post     Post      `gorm:"foreignKey:PostID" json:"post,omitempty"`
+}
+
+// 动态：关注的人做了什么，只记录谁、什么类型、对哪个对象
+type Activity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ActorID   uint      `gorm:"not null;uniqueIndex:idx_activity,priority:1;index:idx_actor_time,priority:1;comment:触发者ID" json:"actor_id"`
+	Type      int       `gorm:"type:tinyint;not null;uniqueIndex:idx_activity,priority:2;comment:类型(1:发布文章,2:提问,3:回答问题,4:赞同回答,5:赞了文章,6:关注问题)" json:"type"`
+	PostID    uint      `gorm:"not null;uniqueIndex:idx_activity,priority:3;comment:关联的文章或问题ID" json:"post_id"`
+	CommentID uint      `gorm:"not null;default:0;uniqueIndex:idx_activity,priority:4;comment:关联的回答ID(0表示没有)" json:"comment_id"`
+	CreatedAt time.Time `gorm:"not null;index:idx_actor_time,priority:2" json:"created_at"`
+}
+
+const (
+	ActivityPublishArticle = 1
+	ActivityAskQuestion    = 2
+	ActivityAnswer         = 3
+	ActivityUpvoteAnswer   = 4
+	ActivityLikeArticle    = 5
+	ActivityFollowQuestion = 6
+)
+
 // 通知模型
+// 同类型、同对象的点赞/评论/关注合并为一条(GroupKey相同)，ActorID为最近一个触发者，全部触发者在notification_actors中
 type Notification struct {
 	gorm.Model
-	RecipientID uint   `gorm:"not null;index;comment:接受者ID" json:"recipient_id"`
+	RecipientID uint   `gorm:"not null;index;uniqueIndex:idx_notify_group;comment:接受者ID" json:"recipient_id"`
 	ActorID     uint   `gorm:"not null;comment:触发者ID" json:"actor_id"`
 	Type        int    `gorm:"not null;comment:类型(1:点赞,2:评论,3:关注,4:系统)" json:"type"`
 	Content     string `gorm:"type:varchar(255);comment:通知内容" json:"content"`
+	TargetType  int    `gorm:"not null;default:0;comment:关联对象类型(1:文章,2:评论,3:用户)" json:"target_type"`
 	TargetID    uint   `gorm:"comment:关联对象ID(如文章ID)" json:"target_id"`
-	IsRead      bool   `gorm:"default:false;comment:是否已读" json:"is_read"`
-	Actor       User   `gorm:"foreignKey:ActorID" json:"actor"`
-	Recipient   User   `gorm:"foreignKey:RecipientID" json:"-"`
+	PostID      uint   `gorm:"not null;default:0;comment:关联对象所在的文章ID(评论用于跳转)" json:"post_id"`
+	Snippet     string `gorm:"type:varchar(255);comment:关联对象摘要(文章标题或评论内容)" json:"snippet"`
+	// 不合并的通知为NULL，不受唯一索引约束
+	GroupKey   *string   `gorm:"type:varchar(64);uniqueIndex:idx_notify_group;comment:合并分组" json:"-"`
+	ActorCount int       `gorm:"not null;default:1;comment:合并的触发人数" json:"actor_count"`
+	LatestAt   time.Time `gorm:"index;comment:最近一次触发时间" json:"latest_at"`
+	IsRead     bool      `gorm:"default:false;comment:是否已读" json:"is_read"`
+	Actor      User      `gorm:"foreignKey:ActorID" json:"actor"`
+	Recipient  User      `gorm:"foreignKey:RecipientID" json:"-"`
+}
+
+// 每种通知的偏好，没有记录的类型按默认值：站内通知开启、不限来源、邮件摘要按配置
+type NotificationPreference struct {
+	ID            uint      `gorm:"primaryKey"`
+	UserID        uint      `gorm:"not null;uniqueIndex:idx_notify_pref;comment:用户ID"`
+	Type          int       `gorm:"not null;uniqueIndex:idx_notify_pref;comment:通知类型"`
+	InApp         bool      `gorm:"not null;comment:是否接收站内通知"`
+	FollowingOnly bool      `gorm:"not null;comment:只接收我关注的人触发的"`
+	Digest        string    `gorm:"type:varchar(16);not null;comment:邮件摘要(daily/weekly/never)"`
+	UpdatedAt     time.Time `gorm:"comment:修改时间"`
+}
+
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+	DigestNever  = "never"
+)
+
+// 合并通知的触发者，同一个人重复触发(取消后再赞)只记一次
+type NotificationActor struct {
+	ID             uint      `gorm:"primaryKey"`
+	NotificationID uint      `gorm:"not null;uniqueIndex:idx_notify_actor;comment:通知ID"`
+	ActorID        uint      `gorm:"not null;uniqueIndex:idx_notify_actor;comment:触发者ID"`
+	CreatedAt      time.Time `gorm:"comment:触发时间"`
 }
 
 const (
@@ -93,15 +261,20 @@ const (
 	NotifyTypeSystem  = 4
 	NotifyType        = 5
 	NotifyTypeMessage = 6
+	// 关注私密账号的请求，ActorID为申请者，可在通知中心直接通过或拒绝
+	NotifyTypeFollowRequest = 7
 )
 const (
 	PostStatusDraft     = 0
 	PostStatusPublished = 1
 	PostStatusDeleted   = 2
+	PostStatusMerged    = 3
 )
 const (
 	TargetTypePost    = 1
 	TargetTypeComment = 2
+	// 仅用于通知，如"关注了你"
+	TargetTypeUser = 3
 )
 
 // 私信模型
@@ -110,7 +283,7 @@ type Message struct {
 	SenderID   uint   `gorm:"not null;index;comment:发送者ID" json:"sender_id"`
 	ReceiverID uint   `gorm:"not null;index;comment:接受者ID" json:"receiver_id"`
 	Content    string `gorm:"type:text;not null;comment:消息内容" json:"content"`
-	Session    string `gorm:"index;not null;comment:会话ID(用于分组)" json:"session_id"`
+	Session    string `gorm:"column:session_id;index;not null;comment:会话ID(用于分组)" json:"session_id"`
 	IsRead     bool   `gorm:"default:false;comment:是否已读" json:"is_read"`
 
 	Sender   User `gorm:"foreignKey:SenderID" json:"sender"`
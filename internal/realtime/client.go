@@ -0,0 +1,72 @@
+package realtime
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Client 一个在线连接(一个浏览器标签页或一台设备)，由传输层(websocket/sse)从Send读出并写给客户端
+type Client struct {
+	UserID    uint
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	// 是否因为消费太慢被断开
+	mu      sync.Mutex
+	dropped bool
+}
+
+func newClient(userID uint, buffer int) *Client {
+	return &Client{
+		UserID: userID,
+		send:   make(chan []byte, buffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// 待写出的消息
+func (c *Client) Send() <-chan []byte {
+	return c.send
+}
+
+// 连接被关闭(停机、被挤掉或消费太慢)时关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// 只推给这一个连接，用于连接建立时下发初始状态
+func (c *Client) Push(ev Event) error {
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	c.push(raw)
+	return nil
+}
+
+// 不阻塞：缓冲满了说明客户端跟不上，断开后由客户端重连并重新拉取未读数
+func (c *Client) push(raw []byte) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	select {
+	case c.send <- raw:
+	default:
+		c.mu.Lock()
+		c.dropped = true
+		c.mu.Unlock()
+		c.Close()
+	}
+}
+
+func (c *Client) Close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *Client) Dropped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
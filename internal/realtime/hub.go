@@ -0,0 +1,161 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-zhihu/config"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 实时推送的连接管理：每个实例只持有连到自己的客户端，
+// 推送先发到redis频道，所有实例都订阅该频道，再各自投递给本地在线的用户
+const Channel = "realtime:events"
+
+var (
+	ErrTooManyConns = errors.New("too many realtime connections")
+	ErrHubClosed    = errors.New("realtime hub closed")
+)
+
+// Event 推送给客户端的一条消息
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// 频道里传递的消息，event已编码好，各实例直接转发原始字节
+type envelope struct {
+	UserIDs []uint          `json:"user_ids"`
+	Event   json.RawMessage `json:"event"`
+}
+
+type Hub struct {
+	rdb     *redis.Client
+	cfg     config.RealtimeConfig
+	mu      sync.RWMutex
+	clients map[uint]map[*Client]struct{}
+	closed  bool
+}
+
+func NewHub(rdb *redis.Client, cfg config.RealtimeConfig) *Hub {
+	return &Hub{
+		rdb:     rdb,
+		cfg:     cfg,
+		clients: make(map[uint]map[*Client]struct{}),
+	}
+}
+
+// 登记一个新连接，同一用户的连接数有上限(多端同时在线)
+func (h *Hub) Register(userID uint) (*Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+	conns := h.clients[userID]
+	if conns == nil {
+		conns = make(map[*Client]struct{})
+		h.clients[userID] = conns
+	}
+	if len(conns) >= h.cfg.MaxConnsPerUser {
+		return nil, ErrTooManyConns
+	}
+	c := newClient(userID, h.cfg.SendBuffer)
+	conns[c] = struct{}{}
+	return c, nil
+}
+
+// 连接断开后注销，可重复调用
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	if conns := h.clients[c.UserID]; conns != nil {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(h.clients, c.UserID)
+		}
+	}
+	h.mu.Unlock()
+	c.Close()
+}
+
+// 推送给若干用户，不论他们连在哪个实例上；redis不可用时退化为只投递本实例
+func (h *Hub) Publish(ctx context.Context, userIDs []uint, ev Event) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(envelope{UserIDs: userIDs, Event: raw})
+	if err != nil {
+		return err
+	}
+	if err := h.rdb.Publish(ctx, Channel, msg).Err(); err != nil {
+		h.deliver(userIDs, raw)
+		return err
+	}
+	return nil
+}
+
+// 订阅频道并投递到本地连接，ctx取消时退出；go-redis断线后会自动重新订阅
+func (h *Hub) Run(ctx context.Context) {
+	pubsub := h.rdb.Subscribe(ctx, Channel)
+	defer func() { _ = pubsub.Close() }()
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			h.CloseAll()
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var env envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				log.Printf("realtime decode event failed:%v", err)
+				continue
+			}
+			h.deliver(env.UserIDs, env.Event)
+		}
+	}
+}
+
+// 投递给本实例上的连接；发送缓冲写满的连接会被断开，不拖慢其他人
+func (h *Hub) deliver(userIDs []uint, raw []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, uid := range userIDs {
+		for c := range h.clients[uid] {
+			c.push(raw)
+		}
+	}
+}
+
+// 关闭全部连接并拒绝新连接，停机时调用，否则sse长连接会拖住http server的优雅退出
+func (h *Hub) CloseAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for uid, conns := range h.clients {
+		for c := range conns {
+			c.Close()
+		}
+		delete(h.clients, uid)
+	}
+}
+
+// 本实例上的连接数
+func (h *Hub) LocalConns() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for _, conns := range h.clients {
+		n += len(conns)
+	}
+	return n
+}
@@ -0,0 +1,125 @@
+package realtime
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// 心跳消息，客户端收到后回任意一条消息即可(约定回{"type":"pong"})
+var pingFrame = []byte(`{"type":"ping"}`)
+
+var errNotFlusher = errors.New("response writer does not support flush")
+
+// 浏览器发起的握手只接受配置中的来源，没有Origin的非浏览器客户端直接放行(靠token鉴权)
+func (h *Hub) checkOrigin(cfg *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" || len(h.cfg.AllowedOrigins) == 0 {
+		return nil
+	}
+	for _, allowed := range h.cfg.AllowedOrigins {
+		if origin == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+// ServeWebSocket 把client的推送写到websocket连接上，直到任意一方断开。
+// 读协程负责收客户端消息并刷新读超时，超过两个心跳间隔没有收到数据就断开；
+// onFrame处理客户端发来的原始消息，返回的Event(可为nil)只回给这个连接
+func (h *Hub) ServeWebSocket(w http.ResponseWriter, req *http.Request, client *Client, onFrame func([]byte) *Event) {
+	interval := time.Duration(h.cfg.PingSeconds) * time.Second
+	writeTimeout := time.Duration(h.cfg.WriteTimeoutSeconds) * time.Second
+	server := websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = h.cfg.MaxFrameBytes
+			go func() {
+				defer client.Close()
+				for {
+					_ = ws.SetReadDeadline(time.Now().Add(2 * interval))
+					var raw []byte
+					if err := websocket.Message.Receive(ws, &raw); err != nil {
+						return
+					}
+					if reply := onFrame(raw); reply != nil {
+						_ = client.Push(*reply)
+					}
+				}
+			}()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			write := func(raw []byte) error {
+				_ = ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+				return websocket.Message.Send(ws, string(raw))
+			}
+			for {
+				select {
+				case <-client.Done():
+					return
+				case raw := <-client.Send():
+					if err := write(raw); err != nil {
+						return
+					}
+				case <-ticker.C:
+					if err := write(pingFrame); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+	server.ServeHTTP(w, req)
+}
+
+// ServeSSE 不支持websocket时的降级方案：每条推送是一个data行，内容和websocket一致；
+// 心跳用注释行保持连接，客户端要发的消息(正在输入)改走普通接口
+func (h *Hub) ServeSSE(w http.ResponseWriter, req *http.Request, client *Client) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errNotFlusher
+	}
+	interval := time.Duration(h.cfg.PingSeconds) * time.Second
+	writeTimeout := time.Duration(h.cfg.WriteTimeoutSeconds) * time.Second
+	rc := http.NewResponseController(w)
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 关掉nginx的响应缓冲，否则推送会被攒着
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	write := func(format string, args ...interface{}) error {
+		_ = rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := write("retry: %d\n\n", 3000); err != nil {
+		return nil
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return nil
+		case <-client.Done():
+			return nil
+		case raw := <-client.Send():
+			if err := write("data: %s\n\n", raw); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if err := write(": ping\n\n"); err != nil {
+				return nil
+			}
+		}
+	}
+}
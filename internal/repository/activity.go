@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 动态
+type ActivityRepository struct {
+	DB *gorm.DB
+}
+
+func NewActivityRepository(db *gorm.DB) *ActivityRepository {
+	return &ActivityRepository{DB: db}
+}
+
+// 记录动态，同一个人对同一对象的同类动态只保留一条，重复时刷新时间
+func (r *ActivityRepository) Upsert(ctx context.Context, tx *gorm.DB, activity *model.Activity) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "actor_id"}, {Name: "type"}, {Name: "post_id"}, {Name: "comment_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"created_at"}),
+	}).Create(activity).Error
+}
+
+// 撤销动态(取消点赞、取消关注问题)
+func (r *ActivityRepository) Remove(ctx context.Context, tx *gorm.DB, actorID uint, activityType int, postID, commentID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Where("actor_id=? AND type=? AND post_id=? AND comment_id=?", actorID, activityType, postID, commentID).Delete(&model.Activity{}).Error
+}
+
+// 按时间倒序取一批人的动态，跳过已下架的文章、被封禁的人、excludeAuthorIDs写的文章和viewerID看不到的私密文章
+func (r *ActivityRepository) ListByActors(ctx context.Context, tx *gorm.DB, viewerID uint, actorIDs []uint, types []int, excludeAuthorIDs []uint, beforeScore int64, beforeID uint, limit int) ([]model.Activity, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var activities []model.Activity
+	query := visibleToViewer(applyCursor(db.WithContext(ctx), "activities", beforeScore, beforeID), "posts", viewerID)
+	if len(excludeAuthorIDs) > 0 {
+		query = query.Where("posts.author_id NOT IN ?", excludeAuthorIDs)
+	}
+	err := query.Table("activities").Select("activities.*").
+		Joins("JOIN posts ON posts.id = activities.post_id AND posts.status = ? AND posts.deleted_at IS NULL", model.PostStatusPublished).
+		Joins("JOIN users ON users.id = activities.actor_id AND users.status = 1").
+		Where("activities.actor_id IN ? AND activities.type IN ?", actorIDs, types).
+		Order("activities.created_at DESC, activities.id DESC").Limit(limit).Find(&activities).Error
+	return activities, err
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 拉黑关系
+type BlockRepository struct {
+	DB *gorm.DB
+}
+
+func NewBlockRepository(db *gorm.DB) *BlockRepository {
+	return &BlockRepository{DB: db}
+}
+
+// 重复拉黑不报错
+func (r *BlockRepository) Block(ctx context.Context, tx *gorm.DB, blockerID, blockedID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	block := &model.Block{BlockerID: blockerID, BlockedID: blockedID}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(block).Error
+}
+func (r *BlockRepository) Unblock(ctx context.Context, tx *gorm.DB, blockerID, blockedID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Where("blocker_id=? AND blocked_id=?", blockerID, blockedID).Delete(&model.Block{}).Error
+}
+
+// 任意一方拉黑了另一方
+func (r *BlockRepository) IsBlockedEither(ctx context.Context, tx *gorm.DB, a, b uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var count int64
+	err := db.WithContext(ctx).Model(&model.Block{}).
+		Where("(blocker_id=? AND blocked_id=?) OR (blocker_id=? AND blocked_id=?)", a, b, b, a).Count(&count).Error
+	return count > 0, err
+}
+
+// 我拉黑的人和拉黑我的人
+func (r *BlockRepository) RelatedUserIDs(ctx context.Context, tx *gorm.DB, userID uint) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Raw("SELECT blocked_id FROM blocks WHERE blocker_id = ? UNION SELECT blocker_id FROM blocks WHERE blocked_id = ?", userID, userID).Scan(&ids).Error
+	return ids, err
+}
+
+// 我的黑名单
+func (r *BlockRepository) ListBlocked(ctx context.Context, tx *gorm.DB, userID uint, offset, limit int) ([]model.User, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var users []model.User
+	err := db.WithContext(ctx).Table("users").Select("users.id,users.username,users.avatar,users.bio,users.created_at").
+		Joins("JOIN blocks ON users.id = blocks.blocked_id").Where("blocks.blocker_id = ?", userID).
+		Order("blocks.created_at DESC").Offset(offset).Limit(limit).Find(&users).Error
+	return users, err
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 邮件摘要用到的查询
+
+// 有邮箱且未被封禁的用户，按id分批遍历
+func (r *UserRepository) ListDigestCandidates(ctx context.Context, tx *gorm.DB, afterID uint, limit int) ([]model.User, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var users []model.User
+	err := db.WithContext(ctx).Select("id,username,email,status,quiet_start,quiet_end,timezone").
+		Where("id > ? AND email <> '' AND status = 1", afterID).
+		Order("id ASC").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+// since之后的指定类型的未读通知，按最近触发时间倒序，同时返回总条数
+func (r *NotificationRepository) UnreadSince(ctx context.Context, tx *gorm.DB, userID uint, types []int, excludeActorIDs []uint, since time.Time, limit int) ([]model.Notification, int64, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	query := db.WithContext(ctx).Model(&model.Notification{}).
+		Where("recipient_id = ? AND is_read = ? AND latest_at >= ? AND type IN ?", userID, false, since, types)
+	if len(excludeActorIDs) > 0 {
+		query = query.Where("(actor_id NOT IN ? OR actor_count > 1)", excludeActorIDs)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.Notification
+	err := query.Order("latest_at DESC, id DESC").Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// since之后关注的人发布的文章，按热度取前几篇
+func (r *PostRepository) TopFromFollowees(ctx context.Context, tx *gorm.DB, userID uint, excludeAuthorIDs []uint, since time.Time, limit int) ([]model.Post, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	query := db.WithContext(ctx).
+		Where("author_id IN (SELECT followee_id FROM relations WHERE follower_id = ? AND deleted_at IS NULL)", userID).
+		Where("status = ? AND created_at >= ?", model.PostStatusPublished, since)
+	query = visibleToViewer(query, "posts", userID)
+	if len(excludeAuthorIDs) > 0 {
+		query = query.Where("author_id NOT IN ?", excludeAuthorIDs)
+	}
+	var posts []model.Post
+	err := query.Preload("Author").Order("hot_score DESC, id DESC").Limit(limit).Find(&posts).Error
+	return posts, err
+}
+
+// since之后别人在我关注的问题下的回答，从新到旧
+func (r *CommentRepository) AnswersToFollowedQuestions(ctx context.Context, tx *gorm.DB, userID uint, excludeAuthorIDs []uint, since time.Time, limit int) ([]model.Comment, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	questions := visibleToViewer(db.Model(&model.Post{}).Select("id").
+		Where("type = ? AND status = ?", 2, model.PostStatusPublished), "posts", userID)
+	query := db.WithContext(ctx).
+		Where("parent_id = 0 AND author_id <> ? AND created_at >= ?", userID, since).
+		Where("post_id IN (SELECT post_id FROM question_follows WHERE user_id = ?)", userID).
+		Where("post_id IN (?)", questions)
+	if len(excludeAuthorIDs) > 0 {
+		query = query.Where("author_id NOT IN ?", excludeAuthorIDs)
+	}
+	var comments []model.Comment
+	err := query.Preload("Author").Preload("Post").Order("created_at DESC, id DESC").Limit(limit).Find(&comments).Error
+	return comments, err
+}
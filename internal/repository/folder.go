@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FolderRepository struct {
+	DB *gorm.DB
+}
+
+func NewFolderRepository(db *gorm.DB) *FolderRepository {
+	return &FolderRepository{DB: db}
+}
+
+// 收藏夹的增删改查
+func (r *FolderRepository) CreateFolder(ctx context.Context, tx *gorm.DB, folder *model.Folder) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Create(folder).Error
+}
+func (r *FolderRepository) FindFolderByID(ctx context.Context, tx *gorm.DB, id uint) (*model.Folder, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var folder model.Folder
+	if err := db.WithContext(ctx).First(&folder, id).Error; err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+func (r *FolderRepository) UpdateFolder(ctx context.Context, tx *gorm.DB, folderID uint, updates map[string]interface{}) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Model(&model.Folder{}).Where("id=?", folderID).Updates(updates).Error
+}
+func (r *FolderRepository) DeleteFolder(ctx context.Context, tx *gorm.DB, folderID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Delete(&model.Folder{}, folderID).Error
+}
+
+// 用户的收藏夹列表，onlyPublic为true时只返回公开的
+func (r *FolderRepository) ListByUser(ctx context.Context, tx *gorm.DB, userID uint, onlyPublic bool) ([]model.Folder, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var folders []model.Folder
+	query := db.WithContext(ctx).Where("user_id=?", userID)
+	if onlyPublic {
+		query = query.Where("is_public=?", true)
+	}
+	err := query.Order("is_default DESC, created_at ASC").Find(&folders).Error
+	return folders, err
+}
+
+// 获取默认收藏夹，没有就创建
+func (r *FolderRepository) GetOrCreateDefault(ctx context.Context, tx *gorm.DB, userID uint) (*model.Folder, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var folder model.Folder
+	err := db.WithContext(ctx).Where("user_id=? AND is_default=?", userID, true).First(&folder).Error
+	if err == nil {
+		return &folder, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	folder = model.Folder{UserID: userID, Name: model.DefaultFolderName, IsDefault: true}
+	if err := db.WithContext(ctx).Create(&folder).Error; err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// 原子增减收藏数
+func (r *FolderRepository) IncrPostCount(ctx context.Context, tx *gorm.DB, folderIDs []uint, delta int64) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	if len(folderIDs) == 0 || delta == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Model(&model.Folder{}).Where("id IN ?", folderIDs).UpdateColumn("post_count", gorm.Expr("GREATEST(post_count + ?, 0)", delta)).Error
+}
+
+// 关注收藏夹，返回是否新增
+func (r *FolderRepository) Follow(ctx context.Context, tx *gorm.DB, userID, folderID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&model.FolderFollow{UserID: userID, FolderID: folderID})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	err := db.WithContext(ctx).Model(&model.Folder{}).Where("id=?", folderID).UpdateColumn("follower_count", gorm.Expr("follower_count + 1")).Error
+	return true, err
+}
+func (r *FolderRepository) Unfollow(ctx context.Context, tx *gorm.DB, userID, folderID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Where("user_id=? AND folder_id=?", userID, folderID).Delete(&model.FolderFollow{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return db.WithContext(ctx).Model(&model.Folder{}).Where("id=?", folderID).UpdateColumn("follower_count", gorm.Expr("GREATEST(follower_count - 1, 0)")).Error
+}
+func (r *FolderRepository) RemoveFollowsByFolder(ctx context.Context, tx *gorm.DB, folderID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Where("folder_id=?", folderID).Delete(&model.FolderFollow{}).Error
+}
+
+// 用户关注的收藏夹，只包含仍然公开的
+func (r *FolderRepository) ListFollowed(ctx context.Context, tx *gorm.DB, userID uint, offset, limit int) ([]model.Folder, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var folders []model.Folder
+	err := db.WithContext(ctx).Joins("JOIN folder_follows ON folders.id = folder_follows.folder_id").Where("folder_follows.user_id=? AND folders.is_public=?", userID, true).Order("folder_follows.created_at DESC").Offset(offset).Limit(limit).Find(&folders).Error
+	return folders, err
+}
+
+// 把没有收藏夹的旧收藏迁移到各自用户的默认收藏夹
+func (r *FolderRepository) MigrateLegacyConnections(ctx context.Context, tx *gorm.DB) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var userIDs []uint
+	if err := db.WithContext(ctx).Model(&model.Connection{}).Where("folder_id=?", 0).Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, uid := range userIDs {
+		err := db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+			folder, err := r.GetOrCreateDefault(ctx, txFn, uid)
+			if err != nil {
+				return err
+			}
+			// 默认收藏夹里已有的文章直接删掉旧行，避免唯一索引冲突
+			if err := txFn.Exec(`DELETE FROM connections WHERE user_id = ? AND folder_id = 0 AND post_id IN (
+				SELECT post_id FROM (SELECT post_id FROM connections WHERE folder_id = ?) AS t)`, uid, folder.ID).Error; err != nil {
+				return err
+			}
+			if err := txFn.Model(&model.Connection{}).Where("user_id=? AND folder_id=?", uid, 0).Update("folder_id", folder.ID).Error; err != nil {
+				return err
+			}
+			var count int64
+			if err := txFn.Model(&model.Connection{}).Where("folder_id=?", folder.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			return txFn.Model(&model.Folder{}).Where("id=?", folder.ID).UpdateColumn("post_count", count).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
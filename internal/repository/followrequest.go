@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 关注私密账号的待审核请求
+type FollowRequestRepository struct {
+	DB *gorm.DB
+}
+
+func NewFollowRequestRepository(db *gorm.DB) *FollowRequestRepository {
+	return &FollowRequestRepository{DB: db}
+}
+
+// 重复申请不报错，返回是否新建了请求
+func (r *FollowRequestRepository) Create(ctx context.Context, tx *gorm.DB, requesterID, targetID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	request := &model.FollowRequest{RequesterID: requesterID, TargetID: targetID}
+	result := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(request)
+	return result.RowsAffected > 0, result.Error
+}
+
+// 删除请求(通过、拒绝或撤回)，返回请求是否存在
+func (r *FollowRequestRepository) Delete(ctx context.Context, tx *gorm.DB, requesterID, targetID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Where("requester_id=? AND target_id=?", requesterID, targetID).Delete(&model.FollowRequest{})
+	return result.RowsAffected > 0, result.Error
+}
+func (r *FollowRequestRepository) Exists(ctx context.Context, tx *gorm.DB, requesterID, targetID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var count int64
+	err := db.WithContext(ctx).Model(&model.FollowRequest{}).Where("requester_id=? AND target_id=?", requesterID, targetID).Count(&count).Error
+	return count > 0, err
+}
+
+// targetIDs中requesterID已发出请求还没审核的(批量查询关系状态用)
+func (r *FollowRequestRepository) RequestedAmong(ctx context.Context, tx *gorm.DB, requesterID uint, targetIDs []uint) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Model(&model.FollowRequest{}).Where("requester_id=? AND target_id IN ?", requesterID, targetIDs).Pluck("target_id", &ids).Error
+	return ids, err
+}
+
+// 待我审核的请求，按申请时间倒序
+func (r *FollowRequestRepository) ListPending(ctx context.Context, tx *gorm.DB, targetID uint, offset, limit int) ([]model.User, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var users []model.User
+	err := db.WithContext(ctx).Table("users").Select("users.id,users.username,users.avatar,users.bio,users.follower_count,users.followee_count,users.is_private,users.created_at").
+		Joins("JOIN follow_requests ON users.id = follow_requests.requester_id").Where("follow_requests.target_id = ?", targetID).
+		Order("follow_requests.created_at DESC").Offset(offset).Limit(limit).Find(&users).Error
+	return users, err
+}
+func (r *FollowRequestRepository) PendingRequesterIDs(ctx context.Context, tx *gorm.DB, targetID uint) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Model(&model.FollowRequest{}).Where("target_id=?", targetID).Pluck("requester_id", &ids).Error
+	return ids, err
+}
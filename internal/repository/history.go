@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type HistoryRepository struct {
+	DB *gorm.DB
+}
+
+func NewHistoryRepository(db *gorm.DB) *HistoryRepository {
+	return &HistoryRepository{DB: db}
+}
+
+// 记录一次阅读，已读过的只刷新阅读时间，保留原来的进度
+func (r *HistoryRepository) RecordRead(ctx context.Context, tx *gorm.DB, userID, postID uint, readAt time.Time) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	history := &model.ReadHistory{UserID: userID, PostID: postID, ReadAt: readAt}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "post_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"read_at"}),
+	}).Create(history).Error
+}
+
+// 客户端上报阅读进度
+func (r *HistoryRepository) UpdateProgress(ctx context.Context, tx *gorm.DB, userID, postID uint, progress int, readAt time.Time) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	history := &model.ReadHistory{UserID: userID, PostID: postID, Progress: progress, ReadAt: readAt}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "post_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"progress", "read_at"}),
+	}).Create(history).Error
+}
+
+// 阅读历史列表，keyword不为空时按标题搜索；onlyUnfinished只返回读了一半的
+func (r *HistoryRepository) ListHistory(ctx context.Context, tx *gorm.DB, userID uint, keyword string, onlyUnfinished bool, offset, limit int) ([]model.ReadHistory, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var histories []model.ReadHistory
+	query := db.WithContext(ctx).Model(&model.ReadHistory{}).
+		Joins("JOIN posts ON posts.id = read_histories.post_id").
+		Where("read_histories.user_id = ? AND posts.status = ? AND posts.deleted_at IS NULL", userID, model.PostStatusPublished)
+	if keyword != "" {
+		// 转义%、_和\，用户输入只按字面匹配
+		query = query.Where(`posts.title LIKE ? ESCAPE '\\'`, likePattern(keyword))
+	}
+	if onlyUnfinished {
+		query = query.Where("read_histories.progress > 0 AND read_histories.progress < 100")
+	}
+	err := query.Preload("Post").Preload("Post.Author").Order("read_histories.read_at DESC").Offset(offset).Limit(limit).Find(&histories).Error
+	return histories, err
+}
+
+func (r *HistoryRepository) DeleteHistory(ctx context.Context, tx *gorm.DB, userID, postID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Where("user_id = ? AND post_id = ?", userID, postID).Delete(&model.ReadHistory{}).Error
+}
+func (r *HistoryRepository) ClearHistory(ctx context.Context, tx *gorm.DB, userID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Where("user_id = ?", userID).Delete(&model.ReadHistory{}).Error
+}
+
+// 只保留每个用户最近的maxPerUser条，返回删除的条数
+func (r *HistoryRepository) PruneHistory(ctx context.Context, tx *gorm.DB, maxPerUser int) (int64, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var userIDs []uint
+	err := db.WithContext(ctx).Model(&model.ReadHistory{}).Select("user_id").Group("user_id").Having("COUNT(*) > ?", maxPerUser).Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, uid := range userIDs {
+		var boundary model.ReadHistory
+		err := db.WithContext(ctx).Select("id, read_at").Where("user_id = ?", uid).Order("read_at DESC, id DESC").Offset(maxPerUser).Limit(1).Take(&boundary).Error
+		if err != nil {
+			return total, err
+		}
+		result := db.WithContext(ctx).Where("user_id = ? AND (read_at < ? OR (read_at = ? AND id <= ?))", uid, boundary.ReadAt, boundary.ReadAt, boundary.ID).Delete(&model.ReadHistory{})
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+	}
+	return total, nil
+}
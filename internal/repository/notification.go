@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-zhihu/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 写入一条可合并的通知(n.GroupKey不为空)：该分组还没有通知时新建；已有未读的通知时把新的触发者记进去，
+// 人数加一并更新最近触发时间；已读的通知保持原样，新的触发另起一条排到最前。
+// 同一个人重复触发不计数，返回false表示通知没有变化。返回后n为该分组当前的通知
+func (r *NotificationRepository) Aggregate(ctx context.Context, tx *gorm.DB, n *model.Notification) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	changed := false
+	err := db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		var existing model.Notification
+		err := txFn.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("recipient_id = ? AND group_key = ?", n.RecipientID, n.GroupKey).
+			First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && existing.DeletedAt.Valid {
+			// 用户删掉的旧分组不再复活，清掉后按新通知重建
+			if err := txFn.Where("notification_id = ?", existing.ID).Delete(&model.NotificationActor{}).Error; err != nil {
+				return err
+			}
+			if err := txFn.Unscoped().Delete(&existing).Error; err != nil {
+				return err
+			}
+			err = gorm.ErrRecordNotFound
+		}
+		if err == nil && existing.IsRead {
+			var seen int64
+			if err := txFn.Model(&model.NotificationActor{}).
+				Where("notification_id = ? AND actor_id = ?", existing.ID, n.ActorID).Count(&seen).Error; err != nil {
+				return err
+			}
+			if seen > 0 {
+				*n = existing
+				return nil
+			}
+			// 已读的旧分组退出合并，留在列表原来的位置
+			if err := txFn.Model(&existing).Update("group_key", nil).Error; err != nil {
+				return err
+			}
+			err = gorm.ErrRecordNotFound
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			n.ActorCount = 1
+			// 并发时另一条已经建好了分组，唯一索引冲突交给任务重试
+			if err := txFn.Create(n).Error; err != nil {
+				return err
+			}
+			changed = true
+			return txFn.Create(&model.NotificationActor{NotificationID: n.ID, ActorID: n.ActorID, CreatedAt: n.LatestAt}).Error
+		}
+		result := txFn.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.NotificationActor{NotificationID: existing.ID, ActorID: n.ActorID, CreatedAt: n.LatestAt})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			*n = existing
+			return nil
+		}
+		err = txFn.Model(&existing).Updates(map[string]interface{}{
+			"actor_id":    n.ActorID,
+			"actor_count": gorm.Expr("actor_count + 1"),
+			"content":     n.Content,
+			"snippet":     n.Snippet,
+			"latest_at":   n.LatestAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		changed = true
+		return txFn.First(n, existing.ID).Error
+	})
+	return changed, err
+}
+
+// 每条通知最近的几个触发者，排除屏蔽的人，按触发时间从新到旧
+func (r *NotificationRepository) RecentActors(ctx context.Context, tx *gorm.DB, notificationIDs, excludeActorIDs []uint, perNotification int) (map[uint][]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := make(map[uint][]uint)
+	if len(notificationIDs) == 0 {
+		return result, nil
+	}
+	inner := db.WithContext(ctx).Model(&model.NotificationActor{}).
+		Select("notification_id, actor_id, ROW_NUMBER() OVER (PARTITION BY notification_id ORDER BY id DESC) AS rn").
+		Where("notification_id IN ?", notificationIDs)
+	if len(excludeActorIDs) > 0 {
+		inner = inner.Where("actor_id NOT IN ?", excludeActorIDs)
+	}
+	var rows []model.NotificationActor
+	err := db.WithContext(ctx).Table("(?) AS t", inner).
+		Select("notification_id, actor_id").
+		Where("rn <= ?", perNotification).
+		Order("notification_id, rn").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.NotificationID] = append(result[row.NotificationID], row.ActorID)
+	}
+	return result, nil
+}
+
+// 彻底删除before之前最后触发的已读通知和用户删掉的通知，连同触发者记录；
+// 分批删除避免长时间锁表，返回删除的通知条数
+func (r *NotificationRepository) PruneNotifications(ctx context.Context, tx *gorm.DB, before time.Time, batchSize int) (int64, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var total int64
+	for {
+		var ids []uint
+		err := db.WithContext(ctx).Unscoped().Model(&model.Notification{}).
+			Where("(is_read = ? AND latest_at < ?) OR deleted_at IS NOT NULL", true, before).
+			Order("id ASC").Limit(batchSize).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+		err = db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+			if err := txFn.Where("notification_id IN ?", ids).Delete(&model.NotificationActor{}).Error; err != nil {
+				return err
+			}
+			return txFn.Unscoped().Where("id IN ?", ids).Delete(&model.Notification{}).Error
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(len(ids))
+		if len(ids) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// 用户设置过的通知偏好
+func (r *NotificationRepository) GetPreferences(ctx context.Context, tx *gorm.DB, userID uint) ([]model.NotificationPreference, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var prefs []model.NotificationPreference
+	err := db.WithContext(ctx).Where("user_id = ?", userID).Find(&prefs).Error
+	return prefs, err
+}
+
+// 某种通知的偏好，没有设置过时返回gorm.ErrRecordNotFound
+func (r *NotificationRepository) FindPreference(ctx context.Context, tx *gorm.DB, userID uint, nType int) (*model.NotificationPreference, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var pref model.NotificationPreference
+	err := db.WithContext(ctx).Where("user_id = ? AND type = ?", userID, nType).First(&pref).Error
+	return &pref, err
+}
+
+// 按(用户,类型)写入或覆盖
+func (r *NotificationRepository) SavePreferences(ctx context.Context, tx *gorm.DB, prefs []model.NotificationPreference) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	if len(prefs) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"in_app", "following_only", "digest", "updated_at"}),
+	}).Create(&prefs).Error
+}
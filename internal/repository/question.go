@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 关注问题
+type QuestionFollowRepository struct {
+	DB *gorm.DB
+}
+
+func NewQuestionFollowRepository(db *gorm.DB) *QuestionFollowRepository {
+	return &QuestionFollowRepository{DB: db}
+}
+
+// 关注问题，返回是否新增
+func (r *QuestionFollowRepository) Follow(ctx context.Context, tx *gorm.DB, userID, postID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&model.QuestionFollow{UserID: userID, PostID: postID})
+	return result.RowsAffected > 0, result.Error
+}
+
+// 取消关注，返回是否删除了记录
+func (r *QuestionFollowRepository) Unfollow(ctx context.Context, tx *gorm.DB, userID, postID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Where("user_id = ? AND post_id = ?", userID, postID).Delete(&model.QuestionFollow{})
+	return result.RowsAffected > 0, result.Error
+}
+func (r *QuestionFollowRepository) IsFollowing(ctx context.Context, tx *gorm.DB, userID, postID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var count int64
+	err := db.WithContext(ctx).Model(&model.QuestionFollow{}).Where("user_id = ? AND post_id = ?", userID, postID).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RecommendRepository struct {
+	DB *gorm.DB
+}
+
+func NewRecommendRepository(db *gorm.DB) *RecommendRepository {
+	return &RecommendRepository{DB: db}
+}
+
+// 候选文章及其在某一路召回中的得分
+type PostScore struct {
+	PostID uint
+	Score  float64
+}
+
+// 用户对某个话题的兴趣，按互动次数计
+type TopicWeight struct {
+	TopicID uint
+	Weight  int64
+}
+
+// 最近有点赞或阅读行为的用户，离线只为这些用户生成候选
+func (r *RecommendRepository) ActiveUserIDs(ctx context.Context, tx *gorm.DB, since time.Time, limit int) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Raw(`SELECT user_id FROM (
+			SELECT user_id, MAX(created_at) AS active_at FROM likes WHERE created_at >= ? AND deleted_at IS NULL GROUP BY user_id
+			UNION ALL
+			SELECT user_id, MAX(read_at) AS active_at FROM read_histories WHERE read_at >= ? GROUP BY user_id
+		) AS t GROUP BY user_id ORDER BY MAX(active_at) DESC LIMIT ?`, since, since, limit).Scan(&ids).Error
+	return ids, err
+}
+
+// 用户感兴趣的话题：点过赞和读过的文章所属话题，按次数排序
+func (r *RecommendRepository) UserTopicWeights(ctx context.Context, tx *gorm.DB, userID uint, since time.Time, limit int) ([]TopicWeight, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []TopicWeight
+	err := db.WithContext(ctx).Raw(`SELECT pt.topic_id AS topic_id, COUNT(*) AS weight FROM (
+			SELECT target_id AS post_id FROM likes WHERE user_id = ? AND type = ? AND created_at >= ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT post_id FROM read_histories WHERE user_id = ? AND read_at >= ?
+		) AS e JOIN post_topics pt ON pt.post_id = e.post_id
+		GROUP BY pt.topic_id ORDER BY weight DESC LIMIT ?`,
+		userID, model.TargetTypePost, since, userID, since, limit).Scan(&rows).Error
+	return rows, err
+}
+
+// 话题下近期热度最高的文章
+func (r *RecommendRepository) HotPostsByTopics(ctx context.Context, tx *gorm.DB, topicIDs []uint, since time.Time, limit int) ([]PostScore, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	if len(topicIDs) == 0 {
+		return nil, nil
+	}
+	var rows []PostScore
+	err := db.WithContext(ctx).Model(&model.Post{}).
+		Select("DISTINCT posts.id AS post_id, posts.hot_score AS score").
+		Joins("JOIN post_topics ON post_topics.post_id = posts.id").
+		Where("post_topics.topic_id IN ? AND posts.status = ? AND posts.created_at >= ?", topicIDs, model.PostStatusPublished, since).
+		Order("posts.hot_score DESC").Limit(limit).Scan(&rows).Error
+	return rows, err
+}
+
+// 协同过滤：和用户最近赞过的recentLikes篇文章重合最多的maxNeighbors个人，近期还赞过哪些文章，
+// 按重合度加权计数排序；两处都先截断，避免热门文章把自连接放大到全表
+func (r *RecommendRepository) CoLikedPosts(ctx context.Context, tx *gorm.DB, userID uint, since time.Time, recentLikes, maxNeighbors, limit int) ([]PostScore, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []PostScore
+	err := db.WithContext(ctx).Raw(`SELECT l3.target_id AS post_id, SUM(nb.overlap) AS score
+		FROM (
+			SELECT l2.user_id, COUNT(*) AS overlap
+			FROM (
+				SELECT target_id FROM likes
+				WHERE user_id = ? AND type = ? AND created_at >= ? AND deleted_at IS NULL
+				ORDER BY created_at DESC LIMIT ?
+			) l1
+			JOIN likes l2 ON l2.target_id = l1.target_id AND l2.type = ? AND l2.user_id <> ? AND l2.deleted_at IS NULL
+			GROUP BY l2.user_id ORDER BY overlap DESC LIMIT ?
+		) nb
+		JOIN likes l3 ON l3.user_id = nb.user_id AND l3.type = ? AND l3.created_at >= ? AND l3.deleted_at IS NULL
+		WHERE NOT EXISTS (SELECT 1 FROM likes me WHERE me.user_id = ? AND me.target_id = l3.target_id AND me.type = ? AND me.deleted_at IS NULL)
+		GROUP BY l3.target_id ORDER BY score DESC LIMIT ?`,
+		userID, model.TargetTypePost, since, recentLikes,
+		model.TargetTypePost, userID, maxNeighbors,
+		model.TargetTypePost, since,
+		userID, model.TargetTypePost, limit).Scan(&rows).Error
+	return rows, err
+}
+
+// 候选中用户已经读过、赞过或自己写的文章
+func (r *RecommendRepository) SeenPostIDs(ctx context.Context, tx *gorm.DB, userID uint, postIDs []uint) (map[uint]bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	seen := make(map[uint]bool)
+	if len(postIDs) == 0 {
+		return seen, nil
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Raw(`SELECT post_id FROM read_histories WHERE user_id = ? AND post_id IN ?
+		UNION SELECT target_id FROM likes WHERE user_id = ? AND type = ? AND target_id IN ? AND deleted_at IS NULL
+		UNION SELECT id FROM posts WHERE author_id = ? AND id IN ?`,
+		userID, postIDs, userID, model.TargetTypePost, postIDs, userID, postIDs).Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		seen[id] = true
+	}
+	return seen, nil
+}
@@ -2,11 +2,16 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"go-zhihu/internal/model"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository struct {
@@ -122,24 +127,41 @@ func (r *PostRepository) UpdatePost(ctx context.Context, tx *gorm.DB, post *mode
 	return db.WithContext(ctx).Save(post).Error
 }
 
-func (r *PostRepository) ListPosts(ctx context.Context, tx *gorm.DB, offset, limit int, orderBy string) ([]model.Post, error) {
+func (r *PostRepository) ListPosts(ctx context.Context, tx *gorm.DB, viewerID uint, beforeScore int64, beforeID uint, limit int) ([]model.Post, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
 	var posts []model.Post
-	err := db.WithContext(ctx).Where("status=?", model.PostStatusPublished).Preload("Author").Order(orderBy + " desc").Offset(offset).Limit(limit).Find(&posts).Error
+	query := visibleToViewer(applyCursor(db.WithContext(ctx), "posts", beforeScore, beforeID), "posts", viewerID)
+	err := query.Where("status=?", model.PostStatusPublished).Preload("Author").Order("created_at DESC, id DESC").Limit(limit).Find(&posts).Error
 	return posts, err
 }
 
+// 游标分页：只取排在(beforeScore, beforeID)之后的记录，按created_at、id倒序
+// beforeScore是created_at的秒级时间戳，与feed的zset分数一致；beforeID为0表示第一页
+func applyCursor(db *gorm.DB, table string, beforeScore int64, beforeID uint) *gorm.DB {
+	if beforeID == 0 {
+		return db
+	}
+	sec := time.Unix(beforeScore, 0)
+	return db.Where(fmt.Sprintf("(%[1]s.created_at < ? OR (%[1]s.created_at < ? AND %[1]s.id < ?))", table), sec, sec.Add(time.Second), beforeID)
+}
+
+// 私密账号的文章只有本人和已关注的人能看到，table为文章表名或别名，viewerID为0表示游客
+func visibleToViewer(db *gorm.DB, table string, viewerID uint) *gorm.DB {
+	return db.Where(fmt.Sprintf("%s.author_id NOT IN (SELECT u.id FROM users u WHERE u.is_private = ? AND u.id <> ? AND u.id NOT IN (SELECT followee_id FROM relations WHERE follower_id = ? AND deleted_at IS NULL))", table), true, viewerID, viewerID)
+}
+
 // 获取指定用户主页
-func (r *PostRepository) ListPublicByAuthorID(ctx context.Context, tx *gorm.DB, authorID uint, offset, limit int) ([]model.Post, error) {
+func (r *PostRepository) ListPublicByAuthorID(ctx context.Context, tx *gorm.DB, authorID uint, beforeScore int64, beforeID uint, limit int) ([]model.Post, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
 	var posts []model.Post
-	err := db.WithContext(ctx).Where("author_id = ? AND status = ?", authorID, model.PostStatusPublished).Preload("Author").Order("created_at DESC").Offset(offset).Limit(limit).Find(&posts).Error
+	query := applyCursor(db.WithContext(ctx), "posts", beforeScore, beforeID)
+	err := query.Where("author_id = ? AND status = ?", authorID, model.PostStatusPublished).Preload("Author").Order("created_at DESC, id DESC").Limit(limit).Find(&posts).Error
 	return posts, err
 }
 
@@ -160,20 +182,52 @@ func cleanFullTextKeyword(keyword string) string {
 	reg := regexp.MustCompile(`[^\p{Han}a-zA-Z0-9\s]`)
 	return reg.ReplaceAllString(keyword, " ")
 }
-func (r *PostRepository) SearchPosts(ctx context.Context, tx *gorm.DB, keyword string, offset, limit int) ([]model.Post, error) {
+
+// 全文检索的一条命中
+type PostMatch struct {
+	ID    uint
+	Score float64
+}
+
+// posts表上的ngram全文索引，mysql搜索引擎依赖它
+const postFullTextIndex = "idx_post_fulltext"
+
+func (r *PostRepository) EnsureFullTextIndex(ctx context.Context) error {
+	if r.DB.Migrator().HasIndex(&model.Post{}, postFullTextIndex) {
+		return nil
+	}
+	return r.DB.WithContext(ctx).Exec(fmt.Sprintf("CREATE FULLTEXT INDEX %s ON posts(title,content) WITH PARSER ngram", postFullTextIndex)).Error
+}
+
+// 清洗后的关键词按空白切开，每段都必须命中(BOOLEAN MODE下的+)，按相关度排序
+func (r *PostRepository) MatchPosts(ctx context.Context, tx *gorm.DB, keyword string, limit int) ([]PostMatch, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
-	var posts []model.Post
-	if strings.TrimSpace(keyword) == "" {
-		return []model.Post{}, nil
+	words := strings.Fields(cleanFullTextKeyword(keyword))
+	if len(words) == 0 {
+		return []PostMatch{}, nil
 	}
-	processedKeyword := cleanFullTextKeyword(keyword)
-	if strings.TrimSpace(processedKeyword) == "" {
-		return []model.Post{}, nil
+	for i, w := range words {
+		words[i] = "+" + w
+	}
+	against := strings.Join(words, " ")
+	var matches []PostMatch
+	err := db.WithContext(ctx).Model(&model.Post{}).Select("id, MATCH(title,content) AGAINST(? IN BOOLEAN MODE) AS score", against).
+		Where("status = ?", model.PostStatusPublished).Where("MATCH(title,content) AGAINST(? IN BOOLEAN MODE)", against).
+		Order("score DESC, id DESC").Limit(limit).Scan(&matches).Error
+	return matches, err
+}
+
+// 按id顺序分批取已发布的文章，用于全量建搜索索引
+func (r *PostRepository) ListPublishedAfter(ctx context.Context, tx *gorm.DB, afterID uint, limit int) ([]model.Post, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
 	}
-	err := db.WithContext(ctx).Model(&model.Post{}).Preload("Author").Where("status=?", model.PostStatusPublished).Where("MATCH(title,content) AGAINST(? IN NATURAL LANGUAGE MODE)", keyword).Order("created_at DESC").Offset(offset).Limit(limit).Find(&posts).Error
+	var posts []model.Post
+	err := db.WithContext(ctx).Select("id,title,content").Where("id > ? AND status = ?", afterID, model.PostStatusPublished).Order("id ASC").Limit(limit).Find(&posts).Error
 	return posts, err
 }
 
@@ -222,25 +276,86 @@ func NewRelationRepository(db *gorm.DB) *RelationRepository {
 	return &RelationRepository{DB: db}
 }
 
-// 处理关注关系
+// 处理关注关系，关系和双方的关注数、粉丝数在同一个事务里更新；
+// 已经关注时唯一索引冲突，返回gorm.ErrDuplicatedKey
 func (r *RelationRepository) Follow(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) error {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
-	relation := model.Relation{
-		FollowerID: followerID,
-		FolloweeID: followeeID,
+	return db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		// 早期取关是软删除，残留的行会占住唯一索引
+		if err := txFn.Unscoped().Where("follower_id=? AND followee_id=? AND deleted_at IS NOT NULL", followerID, followeeID).Delete(&model.Relation{}).Error; err != nil {
+			return err
+		}
+		relation := model.Relation{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+		}
+		if err := txFn.Create(&relation).Error; err != nil {
+			return err
+		}
+		return updateFollowCounts(txFn, followerID, followeeID, 1)
+	})
+}
+
+// 物理删除关注关系，返回是否真的删除了，没有关注时计数不变
+func (r *RelationRepository) Unfollow(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var removed bool
+	err := db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		result := txFn.Unscoped().Where("follower_id=? AND followee_id=? AND deleted_at IS NULL", followerID, followeeID).Delete(&model.Relation{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		removed = true
+		return updateFollowCounts(txFn, followerID, followeeID, -1)
+	})
+	return removed, err
+}
+func updateFollowCounts(db *gorm.DB, followerID, followeeID uint, delta int) error {
+	if err := db.Model(&model.User{}).Where("id=?", followerID).Update("followee_count", gorm.Expr("GREATEST(followee_count + ?, 0)", delta)).Error; err != nil {
+		return err
+	}
+	return db.Model(&model.User{}).Where("id=?", followeeID).Update("follower_count", gorm.Expr("GREATEST(follower_count + ?, 0)", delta)).Error
+}
+
+// 按relations表重算所有人的关注数和粉丝数，用于修复历史数据
+func (r *RelationRepository) RecountFollows(ctx context.Context, tx *gorm.DB) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	db = db.WithContext(ctx)
+	if err := db.Exec("UPDATE users u LEFT JOIN (SELECT follower_id, COUNT(*) AS n FROM relations WHERE deleted_at IS NULL GROUP BY follower_id) c ON c.follower_id = u.id SET u.followee_count = COALESCE(c.n, 0)").Error; err != nil {
+		return err
+	}
+	return db.Exec("UPDATE users u LEFT JOIN (SELECT followee_id, COUNT(*) AS n FROM relations WHERE deleted_at IS NULL GROUP BY followee_id) c ON c.followee_id = u.id SET u.follower_count = COALESCE(c.n, 0)").Error
+}
+
+// ids中userID关注了的人
+func (r *RelationRepository) FollowingAmong(ctx context.Context, tx *gorm.DB, userID uint, ids []uint) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
 	}
-	return db.WithContext(ctx).Create(&relation).Error
+	var result []uint
+	err := db.WithContext(ctx).Model(&model.Relation{}).Where("follower_id=? AND followee_id IN ?", userID, ids).Pluck("followee_id", &result).Error
+	return result, err
 }
 
-func (r *RelationRepository) Unfollow(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) error {
+// ids中关注了userID的人
+func (r *RelationRepository) FollowersAmong(ctx context.Context, tx *gorm.DB, userID uint, ids []uint) ([]uint, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
-	return db.WithContext(ctx).Where("follower_id=? AND followee_id=?", followerID, followeeID).Delete(&model.Relation{}).Error
+	var result []uint
+	err := db.WithContext(ctx).Model(&model.Relation{}).Where("followee_id=? AND follower_id IN ?", userID, ids).Pluck("follower_id", &result).Error
+	return result, err
 }
 func (r *RelationRepository) GetFolloweeIDs(ctx context.Context, tx *gorm.DB, userID uint) ([]uint, error) {
 	db := r.DB
@@ -270,6 +385,40 @@ func (r *RelationRepository) IsFollowing(ctx context.Context, tx *gorm.DB, follo
 	return count > 0, err
 }
 
+// 按id顺序分批取有关注关系的用户，用于全量重建时间线
+func (r *RelationRepository) ListFollowerIDsAfter(ctx context.Context, tx *gorm.DB, afterID uint, limit int) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Model(&model.Relation{}).Distinct("follower_id").Where("follower_id > ?", afterID).Order("follower_id").Limit(limit).Pluck("follower_id", &ids).Error
+	return ids, err
+}
+
+// 随机抽取有关注关系的用户，用于时间线一致性校验
+func (r *RelationRepository) SampleFollowerIDs(ctx context.Context, tx *gorm.DB, n int) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	sub := db.Model(&model.Relation{}).Distinct("follower_id")
+	err := db.WithContext(ctx).Table("(?) AS f", sub).Order("RAND()").Limit(n).Pluck("follower_id", &ids).Error
+	return ids, err
+}
+
+// 粉丝数不少于min的用户
+func (r *RelationRepository) FolloweeIDsWithMinFollowers(ctx context.Context, tx *gorm.DB, min int) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Model(&model.Relation{}).Group("followee_id").Having("COUNT(*) >= ?", min).Pluck("followee_id", &ids).Error
+	return ids, err
+}
+
 // 获取粉丝列表（谁关注了我）
 func (r *RelationRepository) GetFollowers(ctx context.Context, tx *gorm.DB, userID uint, offset, limit int) ([]model.User, error) {
 	db := r.DB
@@ -277,7 +426,7 @@ func (r *RelationRepository) GetFollowers(ctx context.Context, tx *gorm.DB, user
 		db = tx
 	}
 	var users []model.User
-	err := db.WithContext(ctx).Table("users").Select("users.id,users.username,users.avatar,user.bio,users.created_at").Joins("JOIN relations ON user_id = relations.follower_id").Where("relations.followee_id=?", userID).Order("relations.created_at DESC").Offset(offset).Limit(limit).Find(&users).Error
+	err := db.WithContext(ctx).Table("users").Select("users.id,users.username,users.avatar,users.bio,users.follower_count,users.followee_count,users.is_private,users.created_at").Joins("JOIN relations ON users.id = relations.follower_id AND relations.deleted_at IS NULL").Where("relations.followee_id=?", userID).Order("relations.created_at DESC").Offset(offset).Limit(limit).Find(&users).Error
 	return users, err
 }
 
@@ -288,7 +437,7 @@ func (r *RelationRepository) GetFollowees(ctx context.Context, tx *gorm.DB, user
 		db = tx
 	}
 	var users []model.User
-	err := db.WithContext(ctx).Table("users").Select("users.id,users.username,users.avatar,users.created_at").Joins("JOIN relations ON users.id = relations.followee_id").Where("relations.follower_id =?", userID).Order("relations.created_at DESC").Offset(offset).Limit(limit).Find(&users).Error
+	err := db.WithContext(ctx).Table("users").Select("users.id,users.username,users.avatar,users.bio,users.follower_count,users.followee_count,users.is_private,users.created_at").Joins("JOIN relations ON users.id = relations.followee_id AND relations.deleted_at IS NULL").Where("relations.follower_id =?", userID).Order("relations.created_at DESC").Offset(offset).Limit(limit).Find(&users).Error
 	return users, err
 }
 
@@ -334,13 +483,6 @@ func (r *LikeRepository) IsLike(ctx context.Context, tx *gorm.DB, userID, target
 	return count > 0, err
 }
 
-func (r *PostRepository) UpdateHotScore(ctx context.Context, tx *gorm.DB, postID uint, scoreDelta float64) error {
-	db := r.DB
-	if tx != nil {
-		db = tx
-	}
-	return db.WithContext(ctx).Model(&model.Post{}).Where("id=?", postID).Update("hot_score", gorm.Expr("hot_score + ?", scoreDelta)).Error
-}
 func (r *LikeRepository) FindLike(ctx context.Context, userID, targetID uint, likeType int, tx *gorm.DB) (*model.Like, error) {
 	db := r.DB
 	if tx != nil {
@@ -423,7 +565,7 @@ func NewFeedRepository(db *gorm.DB) *FeedRepository {
 
 // 获取用户动态
 
-func (r *FeedRepository) GetFeedByUserIDs(ctx context.Context, tx *gorm.DB, userIDs []uint, offset, limit int) ([]model.Post, error) {
+func (r *FeedRepository) GetFeedByUserIDs(ctx context.Context, tx *gorm.DB, userIDs []uint, beforeScore int64, beforeID uint, limit int) ([]model.Post, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
@@ -432,10 +574,99 @@ func (r *FeedRepository) GetFeedByUserIDs(ctx context.Context, tx *gorm.DB, user
 		return []model.Post{}, nil
 	}
 	var posts []model.Post
-	err := db.WithContext(ctx).Where("author_id IN ?", userIDs).Where("status=1").Preload("Author").Order("created_at DESC").Offset(offset).Limit(limit).Find(&posts).Error
+	query := applyCursor(db.WithContext(ctx), "posts", beforeScore, beforeID)
+	// 被封禁作者的文章不出现在时间线
+	err := query.Joins("JOIN users ON users.id = posts.author_id AND users.status = ?", 1).
+		Where("posts.author_id IN ?", userIDs).Where("posts.status = ?", model.PostStatusPublished).
+		Preload("Author").Order("posts.created_at DESC, posts.id DESC").Limit(limit).Find(&posts).Error
 	return posts, err
 }
 
+// 阅读历史开关
+func (r *UserRepository) SetHistoryDisabled(ctx context.Context, tx *gorm.DB, id uint, disabled bool) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Model(&model.User{}).Where("id=?", id).Update("history_disabled", disabled).Error
+}
+func (r *UserRepository) IsHistoryDisabled(ctx context.Context, tx *gorm.DB, id uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var user model.User
+	err := db.WithContext(ctx).Select("history_disabled").First(&user, id).Error
+	if err != nil {
+		return false, err
+	}
+	return user.HistoryDisabled, nil
+}
+
+// 不看的动态类型
+func (r *UserRepository) SetHiddenActivity(ctx context.Context, tx *gorm.DB, id uint, mask int) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Model(&model.User{}).Where("id=?", id).Update("hidden_activity", mask).Error
+}
+func (r *UserRepository) GetHiddenActivity(ctx context.Context, tx *gorm.DB, id uint) (int, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var user model.User
+	err := db.WithContext(ctx).Select("hidden_activity").First(&user, id).Error
+	if err != nil {
+		return 0, err
+	}
+	return user.HiddenActivity, nil
+}
+func (r *UserRepository) FindUsersByIDs(ctx context.Context, tx *gorm.DB, ids []uint) ([]model.User, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var users []model.User
+	err := db.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+// 私密账号开关
+func (r *UserRepository) SetPrivate(ctx context.Context, tx *gorm.DB, id uint, private bool) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Model(&model.User{}).Where("id=?", id).Update("is_private", private).Error
+}
+func (r *UserRepository) IsPrivate(ctx context.Context, tx *gorm.DB, id uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var user model.User
+	err := db.WithContext(ctx).Select("is_private").First(&user, id).Error
+	if err != nil {
+		return false, err
+	}
+	return user.IsPrivate, nil
+}
+
+// 免打扰时段和时区
+func (r *UserRepository) SetQuietHours(ctx context.Context, tx *gorm.DB, id uint, start, end int, timezone string) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"quiet_start": start,
+		"quiet_end":   end,
+		"timezone":    timezone,
+	}).Error
+}
+
 // 禁言处理补充
 func (r *UserRepository) BanUser(ctx context.Context, tx *gorm.DB, id uint) error {
 	db := r.DB
@@ -464,17 +695,96 @@ func (r *UserRepository) IsUserBanned(ctx context.Context, tx *gorm.DB, id uint)
 	return user.Status == 0, nil
 }
 
-// 排行榜补充
-func (r *PostRepository) GetLeaderboard(ctx context.Context, tx *gorm.DB, limit int) ([]model.Post, error) {
+// 排行榜补充(redis热榜不可用时的兜底)
+func (r *PostRepository) GetLeaderboard(ctx context.Context, tx *gorm.DB, postType, offset, limit int) ([]model.Post, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
 	var posts []model.Post
-	err := db.WithContext(ctx).Where("status=?", 1).Preload("Author").Order("hot_score DESC").Limit(limit).Find(&posts).Error
+	// 榜单是公开的，私密账号的文章不上榜
+	query := visibleToViewer(db.WithContext(ctx).Where("status=?", 1), "posts", 0)
+	if postType > 0 {
+		query = query.Where("type = ?", postType)
+	}
+	err := query.Preload("Author").Order("hot_score DESC").Offset(offset).Limit(limit).Find(&posts).Error
 	return posts, err
 }
 
+// 热度计算所需的互动统计
+type PostHotStat struct {
+	ID            uint
+	Type          int
+	CreatedAt     time.Time
+	ViewCount     int64
+	LikeCount     int64
+	CommentCount  int64
+	BookmarkCount int64
+}
+
+// 获取指定时间之后发布的文章及其点赞、评论、收藏数
+func (r *PostRepository) ListHotStats(ctx context.Context, tx *gorm.DB, since time.Time) ([]PostHotStat, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var stats []PostHotStat
+	err := db.WithContext(ctx).Model(&model.Post{}).
+		Select(`posts.id, posts.type, posts.created_at, posts.view_count,
+			(SELECT COUNT(*) FROM likes WHERE likes.target_id = posts.id AND likes.type = ? AND likes.deleted_at IS NULL) AS like_count,
+			(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL) AS comment_count,
+			(SELECT COUNT(DISTINCT user_id) FROM connections WHERE connections.post_id = posts.id) AS bookmark_count`, model.TargetTypePost).
+		Where("posts.status = ? AND posts.created_at >= ?", model.PostStatusPublished, since).
+		Scan(&stats).Error
+	return stats, err
+}
+
+// 每条UPDATE回写的文章数
+const hotScoreBatch = 500
+
+// 批量回写热度分数，每批用一条CASE WHEN更新，按id排序避免和其他事务交叉加锁
+func (r *PostRepository) UpdateHotScores(ctx context.Context, tx *gorm.DB, scores map[uint]float64) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	ids := make([]uint, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for start := 0; start < len(ids); start += hotScoreBatch {
+		batch := ids[start:min(start+hotScoreBatch, len(ids))]
+		var b strings.Builder
+		args := make([]interface{}, 0, 2*len(batch))
+		b.WriteString("CASE id")
+		for _, id := range batch {
+			b.WriteString(" WHEN ? THEN ?")
+			args = append(args, id, scores[id])
+		}
+		b.WriteString(" END")
+		err := db.WithContext(ctx).Model(&model.Post{}).Where("id IN ?", batch).
+			UpdateColumn("hot_score", gorm.Expr(b.String(), args...)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 候选窗口之外或已不再发布的文章热度归零，只有重力热度会写hot_score，
+// 窗口外的旧分数不能再影响数据库兜底的榜单、推荐召回和按热度排序的搜索
+func (r *PostRepository) ResetStaleHotScores(ctx context.Context, tx *gorm.DB, since time.Time) (int64, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Model(&model.Post{}).
+		Where("hot_score <> 0 AND (created_at < ? OR status <> ?)", since, model.PostStatusPublished).
+		UpdateColumn("hot_score", 0)
+	return result.RowsAffected, result.Error
+}
+
 type ConnectRepository struct {
 	DB *gorm.DB
 }
@@ -483,18 +793,22 @@ func NewConnectionRepository(db *gorm.DB) *ConnectRepository {
 	return &ConnectRepository{DB: db}
 }
 
-// 关注文章或问题
-func (r ConnectRepository) AddConnection(ctx context.Context, tx *gorm.DB, userID, postID uint) error {
+// 关注文章或问题，收藏到指定收藏夹，已存在时不重复插入，返回是否新增
+func (r ConnectRepository) AddConnection(ctx context.Context, tx *gorm.DB, userID, folderID, postID uint) (bool, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
 	conn := &model.Connection{
-		UserID: userID,
-		PostID: postID,
+		UserID:   userID,
+		FolderID: folderID,
+		PostID:   postID,
 	}
-	return db.WithContext(ctx).Create(conn).Error
+	result := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(conn)
+	return result.RowsAffected > 0, result.Error
 }
+
+// 从所有收藏夹中移除
 func (r *ConnectRepository) RemoveConn(ctx context.Context, tx *gorm.DB, userID, postID uint) error {
 	db := r.DB
 	if tx != nil {
@@ -503,6 +817,47 @@ func (r *ConnectRepository) RemoveConn(ctx context.Context, tx *gorm.DB, userID,
 	return db.WithContext(ctx).Where("user_id=? AND post_id =?", userID, postID).Delete(&model.Connection{}).Error
 }
 
+// 从指定收藏夹中移除，返回是否真的删除了
+func (r *ConnectRepository) RemoveFromFolder(ctx context.Context, tx *gorm.DB, folderID, postID uint) (bool, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Where("folder_id=? AND post_id=?", folderID, postID).Delete(&model.Connection{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// 用户收藏了该文章的收藏夹ID
+func (r *ConnectRepository) GetFolderIDsByPost(ctx context.Context, tx *gorm.DB, userID, postID uint) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	err := db.WithContext(ctx).Model(&model.Connection{}).Where("user_id=? AND post_id=?", userID, postID).Pluck("folder_id", &ids).Error
+	return ids, err
+}
+
+// 收藏夹内的文章
+func (r *ConnectRepository) GetPostsByFolder(ctx context.Context, tx *gorm.DB, folderID uint, offset, limit int) ([]model.Post, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var posts []model.Post
+	err := db.WithContext(ctx).Table("posts").Preload("Author").Joins("JOIN connections ON posts.id = connections.post_id").Where("connections.folder_id=? AND posts.status=? AND posts.deleted_at IS NULL", folderID, model.PostStatusPublished).Order("connections.created_at DESC").Offset(offset).Limit(limit).Find(&posts).Error
+	return posts, err
+}
+
+// 删除收藏夹内的所有收藏
+func (r *ConnectRepository) RemoveByFolder(ctx context.Context, tx *gorm.DB, folderID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Where("folder_id=?", folderID).Delete(&model.Connection{}).Error
+}
+
 func (r *ConnectRepository) IsConn(ctx context.Context, tx *gorm.DB, userID, postID uint) (bool, error) {
 	db := r.DB
 	if tx != nil {
@@ -520,7 +875,9 @@ func (r *ConnectRepository) GetConnByUser(ctx context.Context, tx *gorm.DB, user
 		db = tx
 	}
 	var posts []model.Post
-	err := db.WithContext(ctx).Table("posts").Joins("JOIN connections ON posts.id =connections.post_id").Where("connections.user_id=?", userID).Order("connections.created_at DESC").Offset(offset).Limit(limit).Find(&posts).Error
+	// 同一篇文章可能在多个收藏夹里，按最近一次收藏时间去重
+	latest := db.Model(&model.Connection{}).Select("post_id, MAX(created_at) AS created_at").Where("user_id=?", userID).Group("post_id")
+	err := db.WithContext(ctx).Table("posts").Preload("Author").Joins("JOIN (?) AS c ON posts.id = c.post_id", latest).Where("posts.deleted_at IS NULL").Order("c.created_at DESC").Offset(offset).Limit(limit).Find(&posts).Error
 	return posts, err
 }
 func (r *CommentRepository) FindCommentByID(ctx context.Context, tx *gorm.DB, id uint) (*model.Comment, error) {
@@ -535,6 +892,15 @@ func (r *CommentRepository) FindCommentByID(ctx context.Context, tx *gorm.DB, id
 	}
 	return &comment, nil
 }
+func (r *CommentRepository) FindCommentsByIDs(ctx context.Context, tx *gorm.DB, ids []uint) ([]model.Comment, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var comments []model.Comment
+	err := db.WithContext(ctx).Where("id IN ?", ids).Preload("Author").Find(&comments).Error
+	return comments, err
+}
 
 // 信息通知
 type NotificationRepository struct {
@@ -551,13 +917,24 @@ func (r *NotificationRepository) CreateNotification(ctx context.Context, tx *gor
 	}
 	return db.WithContext(ctx).Create(n).Error
 }
-func (r *NotificationRepository) GetNotifications(ctx context.Context, tx *gorm.DB, userID uint, offset, limit int) ([]model.Notification, error) {
+
+// types为空时返回全部类型
+func (r *NotificationRepository) GetNotifications(ctx context.Context, tx *gorm.DB, userID uint, types []int, excludeActorIDs []uint, beforeScore int64, beforeID uint, limit int) ([]model.Notification, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
 	var notifications []model.Notification
-	err := db.WithContext(ctx).Where("recipient_id =?", userID).Preload("Actor").Order("created_at DESC").Offset(offset).Limit(limit).Find(&notifications).Error
+	// 按创建时间翻页，合并时latest_at会变，按它翻页会重复或漏掉。已读的分组再有人触发时另起一条，见Aggregate
+	query := applyCursor(db.WithContext(ctx), "notifications", beforeScore, beforeID)
+	if len(excludeActorIDs) > 0 {
+		// 合并的通知只要还有其他人就照常展示，被屏蔽的人在展示触发者时再去掉
+		query = query.Where("(actor_id NOT IN ? OR actor_count > 1)", excludeActorIDs)
+	}
+	if len(types) > 0 {
+		query = query.Where("type IN ?", types)
+	}
+	err := query.Where("recipient_id =?", userID).Order("created_at DESC, id DESC").Limit(limit).Find(&notifications).Error
 	return notifications, err
 }
 
@@ -578,19 +955,51 @@ func (r *NotificationRepository) MarkAsRead(ctx context.Context, tx *gorm.DB, no
 	}
 	return db.WithContext(ctx).Model(&model.Notification{}).Where("id =? AND recipient_id =?", notificationID, userID).Update("is_read", true).Error
 }
-func (r *NotificationRepository) MarkAllAsRead(ctx context.Context, tx *gorm.DB, userID uint) error {
+
+// 按类型分组的未读数，和列表一样不算屏蔽的人单独触发的通知
+func (r *NotificationRepository) UnreadCountsByType(ctx context.Context, tx *gorm.DB, userID uint, excludeActorIDs []uint) (map[int]int64, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []struct {
+		Type  int
+		Count int64
+	}
+	query := db.WithContext(ctx).Model(&model.Notification{}).Select("type, COUNT(*) AS count").
+		Where("recipient_id = ? AND is_read = ?", userID, false)
+	if len(excludeActorIDs) > 0 {
+		query = query.Where("(actor_id NOT IN ? OR actor_count > 1)", excludeActorIDs)
+	}
+	err := query.Group("type").Scan(&rows).Error
+	counts := make(map[int]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Type] = row.Count
+	}
+	return counts, err
+}
+
+// types为空时全部标记已读
+func (r *NotificationRepository) MarkAllAsRead(ctx context.Context, tx *gorm.DB, userID uint, types []int) error {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
-	return db.WithContext(ctx).Model(&model.Notification{}).Where("recipient_id =? AND is_read= ?", userID, false).Update("is_read", true).Error
+	query := db.WithContext(ctx).Model(&model.Notification{}).Where("recipient_id =? AND is_read= ?", userID, false)
+	if len(types) > 0 {
+		query = query.Where("type IN ?", types)
+	}
+	return query.Update("is_read", true).Error
 }
-func (r *NotificationRepository) DeleteNotification(ctx context.Context, tx *gorm.DB, id uint) error {
+
+// 只删除属于该用户的通知，返回实际删除的条数。软删除，合并的分组再有人触发时按新通知重建
+func (r *NotificationRepository) DeleteNotifications(ctx context.Context, tx *gorm.DB, userID uint, ids []uint) (int64, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
-	return db.WithContext(ctx).Delete(&model.Notification{}, id).Error
+	result := db.WithContext(ctx).Where("recipient_id = ? AND id IN ?", userID, ids).Delete(&model.Notification{})
+	return result.RowsAffected, result.Error
 }
 
 // 关注私信
@@ -601,6 +1010,22 @@ type MessageRepository struct {
 func NewMessageRepository(db *gorm.DB) *MessageRepository {
 	return &MessageRepository{DB: db}
 }
+
+// 旧版本的会话ID列叫session(NOT NULL且没有默认值)，要在AutoMigrate之前改名为session_id，
+// 否则会多出一个session_id列，旧列留着导致插入失败；两列都已存在时把旧列的数据补过去再删掉旧列
+func MigrateMessageSessionColumn(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&model.Message{}) || !m.HasColumn(&model.Message{}, "session") {
+		return nil
+	}
+	if !m.HasColumn(&model.Message{}, "session_id") {
+		return m.RenameColumn(&model.Message{}, "session", "session_id")
+	}
+	if err := db.Exec("UPDATE messages SET session_id = session WHERE session_id = ''").Error; err != nil {
+		return err
+	}
+	return m.DropColumn(&model.Message{}, "session")
+}
 func (r *MessageRepository) CreateMessage(ctx context.Context, tx *gorm.DB, msg *model.Message) error {
 	db := r.DB
 	if tx != nil {
@@ -608,13 +1033,16 @@ func (r *MessageRepository) CreateMessage(ctx context.Context, tx *gorm.DB, msg
 	}
 	return db.WithContext(ctx).Create(msg).Error
 }
-func (r *MessageRepository) GetMessageBySession(ctx context.Context, tx *gorm.DB, sessionID string, offset, limit int) ([]model.Message, error) {
+
+// 从新到旧取聊天记录，游标指向已加载的最早一条
+func (r *MessageRepository) GetMessageBySession(ctx context.Context, tx *gorm.DB, sessionID string, beforeScore int64, beforeID uint, limit int) ([]model.Message, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
 	var messages []model.Message
-	err := db.WithContext(ctx).Where("session_id = ?", sessionID).Order("created_at ASC").Offset(offset).Limit(limit).Find(&messages).Error
+	query := applyCursor(db.WithContext(ctx), "messages", beforeScore, beforeID)
+	err := query.Where("session_id = ?", sessionID).Order("created_at DESC, id DESC").Limit(limit).Find(&messages).Error
 	return messages, err
 }
 
@@ -647,10 +1075,58 @@ func (r *MessageRepository) GetUnreadCountByUser(ctx context.Context, tx *gorm.D
 	err := db.WithContext(ctx).Model(&model.Message{}).Where("receiver_id=? AND is_read = ?", userID, false).Count(&count).Error
 	return count, err
 }
-func (r *MessageRepository) MarkMessagesAsRead(ctx context.Context, tx *gorm.DB, sessionID string, receiverID uint) error {
+
+// 返回本次标为已读的条数
+func (r *MessageRepository) MarkMessagesAsRead(ctx context.Context, tx *gorm.DB, sessionID string, receiverID uint) (int64, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := db.WithContext(ctx).Model(&model.Message{}).Where("session_id=? AND receiver_id = ? AND is_read =?", sessionID, receiverID, false).Update("is_read", true)
+	return result.RowsAffected, result.Error
+}
+
+// 把重复的问题src合并到dst：回答、收藏和问题关注移到dst(同一收藏夹里两个都收藏过的只留一份)，src标记为已合并；
+// 热度不在这里累加，下次重算时按迁移后的互动计入dst
+func (r *PostRepository) MergeInto(ctx context.Context, tx *gorm.DB, srcID, dstID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		if err := txFn.Model(&model.Comment{}).Where("post_id = ?", srcID).Update("post_id", dstID).Error; err != nil {
+			return err
+		}
+		dup := "SELECT c1.folder_id FROM connections c1 JOIN connections c2 ON c2.user_id = c1.user_id AND c2.folder_id = c1.folder_id AND c2.post_id = ? WHERE c1.post_id = ?"
+		if err := txFn.Exec("UPDATE folders SET post_count = GREATEST(post_count - 1, 0) WHERE id IN ("+dup+")", dstID, srcID).Error; err != nil {
+			return err
+		}
+		if err := txFn.Exec("DELETE c1 FROM connections c1 JOIN connections c2 ON c2.user_id = c1.user_id AND c2.folder_id = c1.folder_id AND c2.post_id = ? WHERE c1.post_id = ?", dstID, srcID).Error; err != nil {
+			return err
+		}
+		if err := txFn.Model(&model.Connection{}).Where("post_id = ?", srcID).Update("post_id", dstID).Error; err != nil {
+			return err
+		}
+		if err := txFn.Exec("DELETE q1 FROM question_follows q1 JOIN question_follows q2 ON q2.user_id = q1.user_id AND q2.post_id = ? WHERE q1.post_id = ?", dstID, srcID).Error; err != nil {
+			return err
+		}
+		if err := txFn.Model(&model.QuestionFollow{}).Where("post_id = ?", srcID).Update("post_id", dstID).Error; err != nil {
+			return err
+		}
+		return txFn.Model(&model.Post{}).Where("id = ?", srcID).Updates(map[string]interface{}{"status": model.PostStatusMerged, "merged_into_id": dstID}).Error
+	})
+}
+
+// 已合并的问题合并到了哪里，没有合并时返回0
+func (r *PostRepository) MergedTarget(ctx context.Context, tx *gorm.DB, postID uint) (uint, error) {
 	db := r.DB
 	if tx != nil {
 		db = tx
 	}
-	return db.WithContext(ctx).Model(&model.Message{}).Where("session_id=? AND receiver_id = ? AND is_read =?", sessionID, receiverID, false).Update("is_read", true).Error
+	var post model.Post
+	err := db.WithContext(ctx).Select("merged_into_id").Where("id = ? AND status = ?", postID, model.PostStatusMerged).Take(&post).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return post.MergedIntoID, err
 }
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 搜索结果上的过滤条件，零值表示不限
+type PostSearchFilter struct {
+	AuthorID uint
+	PostType int
+	Start    time.Time
+	End      time.Time
+}
+
+// 模糊匹配时转义关键词里的通配符
+func likePattern(keyword string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return "%" + r.Replace(keyword) + "%"
+}
+
+// 从搜索命中的id中筛出viewerID能看到且满足过滤条件的文章：已发布、不在excludeAuthorIDs中、不是没关注的私密账号；
+// 只取排序需要的列
+func (r *PostRepository) FilterSearchable(ctx context.Context, tx *gorm.DB, ids []uint, viewerID uint, excludeAuthorIDs []uint, filter PostSearchFilter) ([]model.Post, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var posts []model.Post
+	if len(ids) == 0 {
+		return posts, nil
+	}
+	query := db.WithContext(ctx).Model(&model.Post{}).Select("id,author_id,type,hot_score,created_at").
+		Where("id IN ? AND status = ?", ids, model.PostStatusPublished)
+	query = visibleToViewer(query, "posts", viewerID)
+	if len(excludeAuthorIDs) > 0 {
+		query = query.Where("author_id NOT IN ?", excludeAuthorIDs)
+	}
+	if filter.AuthorID > 0 {
+		query = query.Where("author_id = ?", filter.AuthorID)
+	}
+	if filter.PostType > 0 {
+		query = query.Where("type = ?", filter.PostType)
+	}
+	if !filter.Start.IsZero() {
+		query = query.Where("created_at >= ?", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		query = query.Where("created_at < ?", filter.End)
+	}
+	err := query.Find(&posts).Error
+	return posts, err
+}
+
+// 按用户名和简介搜人，用户名完全相同的排最前，其余按粉丝数；不含被封禁的和excludeIDs
+func (r *UserRepository) SearchUsers(ctx context.Context, tx *gorm.DB, keyword string, excludeIDs []uint, offset, limit int) ([]model.User, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var users []model.User
+	pattern := likePattern(keyword)
+	query := db.WithContext(ctx).Select("id,username,avatar,bio,follower_count,followee_count,is_private,created_at").
+		Where("status <> 0").Where("username LIKE ? OR bio LIKE ?", pattern, pattern)
+	if len(excludeIDs) > 0 {
+		query = query.Where("id NOT IN ?", excludeIDs)
+	}
+	err := query.Order(gorm.Expr("username = ? DESC, follower_count DESC, id ASC", keyword)).Offset(offset).Limit(limit).Find(&users).Error
+	return users, err
+}
+
+// 带文章数的话题
+type TopicWithCount struct {
+	model.Topic
+	PostCount int64
+}
+
+// 按名称和简介搜话题，名称完全相同的排最前，其余按文章数
+func (r *TopicRepository) SearchTopics(ctx context.Context, tx *gorm.DB, keyword string, offset, limit int) ([]TopicWithCount, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var topics []TopicWithCount
+	pattern := likePattern(keyword)
+	err := db.WithContext(ctx).Model(&model.Topic{}).
+		Select("topics.*, (SELECT COUNT(*) FROM post_topics WHERE post_topics.topic_id = topics.id) AS post_count").
+		Where("name LIKE ? OR description LIKE ?", pattern, pattern).
+		Order(gorm.Expr("name = ? DESC, post_count DESC, id ASC", keyword)).Offset(offset).Limit(limit).Scan(&topics).Error
+	return topics, err
+}
+
+// 联想词的来源：热度最高的已发布文章标题，私密账号的不收录
+func (r *PostRepository) TopTitles(ctx context.Context, tx *gorm.DB, limit int) ([]model.Post, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var posts []model.Post
+	query := visibleToViewer(db.WithContext(ctx).Model(&model.Post{}).Where("status = ?", model.PostStatusPublished), "posts", 0)
+	err := query.Select("id,title,hot_score").Order("hot_score DESC").Limit(limit).Find(&posts).Error
+	return posts, err
+}
+
+// 联想词的来源：文章数最多的话题
+func (r *TopicRepository) TopTopics(ctx context.Context, tx *gorm.DB, limit int) ([]TopicWithCount, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var topics []TopicWithCount
+	err := db.WithContext(ctx).Model(&model.Topic{}).
+		Select("topics.*, (SELECT COUNT(*) FROM post_topics WHERE post_topics.topic_id = topics.id) AS post_count").
+		Order("post_count DESC").Limit(limit).Scan(&topics).Error
+	return topics, err
+}
+
+// 联想词的来源：粉丝最多的正常用户
+func (r *UserRepository) TopUsernames(ctx context.Context, tx *gorm.DB, limit int) ([]model.User, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var users []model.User
+	err := db.WithContext(ctx).Select("id,username,follower_count").Where("status <> 0").Order("follower_count DESC").Limit(limit).Find(&users).Error
+	return users, err
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type SearchLogRepository struct {
+	DB *gorm.DB
+}
+
+func NewSearchLogRepository(db *gorm.DB) *SearchLogRepository {
+	return &SearchLogRepository{DB: db}
+}
+func (r *SearchLogRepository) Create(ctx context.Context, tx *gorm.DB, log *model.SearchLog) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Create(log).Error
+}
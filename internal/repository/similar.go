@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 文章的MinHash签名和LSH桶
+type SimilarRepository struct {
+	DB *gorm.DB
+}
+
+func NewSimilarRepository(db *gorm.DB) *SimilarRepository {
+	return &SimilarRepository{DB: db}
+}
+
+// 写入签名并替换原来的桶，buckets[i]是第i段的桶号
+func (r *SimilarRepository) SaveSignature(ctx context.Context, tx *gorm.DB, postID uint, signature []byte, buckets []uint64) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		sig := &model.PostSignature{PostID: postID, Signature: signature}
+		if err := txFn.Clauses(clause.OnConflict{UpdateAll: true}).Create(sig).Error; err != nil {
+			return err
+		}
+		if err := txFn.Where("post_id = ?", postID).Delete(&model.PostLSHBucket{}).Error; err != nil {
+			return err
+		}
+		rows := make([]model.PostLSHBucket, 0, len(buckets))
+		for band, bucket := range buckets {
+			rows = append(rows, model.PostLSHBucket{PostID: postID, Band: band, Bucket: bucket})
+		}
+		return txFn.Create(&rows).Error
+	})
+}
+func (r *SimilarRepository) RemoveSignature(ctx context.Context, tx *gorm.DB, postID uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	return db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		if err := txFn.Where("post_id = ?", postID).Delete(&model.PostLSHBucket{}).Error; err != nil {
+			return err
+		}
+		return txFn.Where("post_id = ?", postID).Delete(&model.PostSignature{}).Error
+	})
+}
+func (r *SimilarRepository) FindSignature(ctx context.Context, tx *gorm.DB, postID uint) (*model.PostSignature, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var sig model.PostSignature
+	if err := db.WithContext(ctx).First(&sig, "post_id = ?", postID).Error; err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+func (r *SimilarRepository) FindSignatures(ctx context.Context, tx *gorm.DB, postIDs []uint) ([]model.PostSignature, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var sigs []model.PostSignature
+	if len(postIDs) == 0 {
+		return sigs, nil
+	}
+	err := db.WithContext(ctx).Where("post_id IN ?", postIDs).Find(&sigs).Error
+	return sigs, err
+}
+
+// 与给定的桶有交集的文章，按共同桶数从多到少取前limit篇
+func (r *SimilarRepository) CandidateIDs(ctx context.Context, tx *gorm.DB, buckets []uint64, excludeID uint, limit int) ([]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var ids []uint
+	if len(buckets) == 0 {
+		return ids, nil
+	}
+	pairs := make([][]interface{}, 0, len(buckets))
+	for band, bucket := range buckets {
+		pairs = append(pairs, []interface{}{band, bucket})
+	}
+	err := db.WithContext(ctx).Model(&model.PostLSHBucket{}).Where("(band, bucket) IN ? AND post_id <> ?", pairs, excludeID).
+		Group("post_id").Order("COUNT(*) DESC").Limit(limit).Pluck("post_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StatsRepository struct {
+	DB *gorm.DB
+}
+
+func NewStatsRepository(db *gorm.DB) *StatsRepository {
+	return &StatsRepository{DB: db}
+}
+
+// 按天聚合的计数，Day格式为2006-01-02
+type DailyCount struct {
+	Day   string
+	Count int64
+}
+
+// 把redis中累计的阅读增量写回文章表，同时累加到当天的阅读统计
+func (r *StatsRepository) FlushViews(ctx context.Context, tx *gorm.DB, views map[uint]int64, day time.Time) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	if len(views) == 0 {
+		return nil
+	}
+	postIDs := make([]uint, 0, len(views))
+	for id := range views {
+		postIDs = append(postIDs, id)
+	}
+	return db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		var posts []model.Post
+		if err := txFn.Model(&model.Post{}).Select("id,author_id").Where("id IN ?", postIDs).Find(&posts).Error; err != nil {
+			return err
+		}
+		for _, post := range posts {
+			delta := views[post.ID]
+			if err := txFn.Model(&model.Post{}).Where("id = ?", post.ID).UpdateColumn("view_count", gorm.Expr("view_count + ?", delta)).Error; err != nil {
+				return err
+			}
+			stat := model.PostDailyStat{PostID: post.ID, AuthorID: post.AuthorID, Day: day, Views: delta}
+			err := txFn.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "post_id"}, {Name: "day"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"views": gorm.Expr("views + ?", delta)}),
+			}).Create(&stat).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 作者所有文章每天的阅读数
+func (r *StatsRepository) DailyViewsByAuthor(ctx context.Context, tx *gorm.DB, authorID uint, since time.Time) ([]DailyCount, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []DailyCount
+	err := db.WithContext(ctx).Model(&model.PostDailyStat{}).
+		Select("DATE_FORMAT(day, '%Y-%m-%d') AS day, SUM(views) AS count").
+		Where("author_id = ? AND day >= ?", authorID, since).
+		Group("day").Scan(&rows).Error
+	return rows, err
+}
+
+// 作者文章每天收到的点赞数
+func (r *StatsRepository) DailyLikesByAuthor(ctx context.Context, tx *gorm.DB, authorID uint, since time.Time) ([]DailyCount, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []DailyCount
+	err := db.WithContext(ctx).Model(&model.Like{}).
+		Select("DATE_FORMAT(likes.created_at, '%Y-%m-%d') AS day, COUNT(*) AS count").
+		Joins("JOIN posts ON posts.id = likes.target_id AND likes.type = ?", model.TargetTypePost).
+		Where("posts.author_id = ? AND likes.created_at >= ?", authorID, since).
+		Group("day").Scan(&rows).Error
+	return rows, err
+}
+
+// 作者文章每天收到的评论数
+func (r *StatsRepository) DailyCommentsByAuthor(ctx context.Context, tx *gorm.DB, authorID uint, since time.Time) ([]DailyCount, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []DailyCount
+	err := db.WithContext(ctx).Model(&model.Comment{}).
+		Select("DATE_FORMAT(comments.created_at, '%Y-%m-%d') AS day, COUNT(*) AS count").
+		Joins("JOIN posts ON posts.id = comments.post_id").
+		Where("posts.author_id = ? AND comments.created_at >= ?", authorID, since).
+		Group("day").Scan(&rows).Error
+	return rows, err
+}
+
+// 作者文章每天被收藏的次数
+func (r *StatsRepository) DailyBookmarksByAuthor(ctx context.Context, tx *gorm.DB, authorID uint, since time.Time) ([]DailyCount, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []DailyCount
+	err := db.WithContext(ctx).Table("connections").
+		Select("DATE_FORMAT(connections.created_at, '%Y-%m-%d') AS day, COUNT(DISTINCT connections.user_id, connections.post_id) AS count").
+		Joins("JOIN posts ON posts.id = connections.post_id").
+		Where("posts.author_id = ? AND connections.created_at >= ?", authorID, since).
+		Group("day").Scan(&rows).Error
+	return rows, err
+}
+
+// 作者每天新增的粉丝数
+func (r *StatsRepository) DailyNewFollowers(ctx context.Context, tx *gorm.DB, userID uint, since time.Time) ([]DailyCount, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []DailyCount
+	err := db.WithContext(ctx).Model(&model.Relation{}).
+		Select("DATE_FORMAT(created_at, '%Y-%m-%d') AS day, COUNT(*) AS count").
+		Where("followee_id = ? AND created_at >= ?", userID, since).
+		Group("day").Scan(&rows).Error
+	return rows, err
+}
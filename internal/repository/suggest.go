@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"go-zhihu/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 可能认识的人
+type SuggestRepository struct {
+	DB *gorm.DB
+}
+
+func NewSuggestRepository(db *gorm.DB) *SuggestRepository {
+	return &SuggestRepository{DB: db}
+}
+
+// 一路召回中的候选用户，ViaID是用来生成推荐理由的代表(共同关注的人或共同的话题)
+type UserScore struct {
+	UserID uint
+	Score  float64
+	ViaID  uint
+}
+
+// 排除已经关注的人和任一方向拉黑的人，放在LIMIT之前过滤，避免名额被占满；需要依次传入3个userID
+func suggestExclusion(column string) string {
+	return fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM relations f WHERE f.follower_id = ? AND f.followee_id = %[1]s AND f.deleted_at IS NULL)
+		AND NOT EXISTS (SELECT 1 FROM blocks b WHERE (b.blocker_id = ? AND b.blocked_id = %[1]s) OR (b.blocker_id = %[1]s AND b.blocked_id = ?))`, column)
+}
+
+// 关注的人还关注了谁：按共同关注人数排序
+func (r *SuggestRepository) FriendsOfFriends(ctx context.Context, tx *gorm.DB, userID uint, limit int) ([]UserScore, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []UserScore
+	err := db.WithContext(ctx).Raw(`SELECT r2.followee_id AS user_id, COUNT(*) AS score, MIN(r1.followee_id) AS via_id
+		FROM relations r1 JOIN relations r2 ON r2.follower_id = r1.followee_id
+		WHERE r1.follower_id = ? AND r2.followee_id <> ? AND r1.deleted_at IS NULL AND r2.deleted_at IS NULL
+		AND `+suggestExclusion("r2.followee_id")+`
+		GROUP BY r2.followee_id ORDER BY score DESC LIMIT ?`, userID, userID, userID, userID, userID, limit).Scan(&rows).Error
+	return rows, err
+}
+
+// 在给定话题下发文的作者：按覆盖的话题数和文章数排序
+func (r *SuggestRepository) TopicAuthors(ctx context.Context, tx *gorm.DB, userID uint, topicIDs []uint, since time.Time, limit int) ([]UserScore, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []UserScore
+	err := db.WithContext(ctx).Raw(`SELECT p.author_id AS user_id, COUNT(DISTINCT pt.topic_id) * 10 + COUNT(*) AS score, MIN(pt.topic_id) AS via_id
+		FROM posts p JOIN post_topics pt ON pt.post_id = p.id
+		WHERE pt.topic_id IN ? AND p.status = ? AND p.created_at >= ? AND p.author_id <> ? AND p.deleted_at IS NULL
+		AND `+suggestExclusion("p.author_id")+`
+		GROUP BY p.author_id ORDER BY score DESC LIMIT ?`, topicIDs, model.PostStatusPublished, since, userID, userID, userID, userID, limit).Scan(&rows).Error
+	return rows, err
+}
+
+// 和我赞过相同文章的人：按相同文章数排序
+func (r *SuggestRepository) CoLikers(ctx context.Context, tx *gorm.DB, userID uint, since time.Time, limit int) ([]UserScore, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var rows []UserScore
+	err := db.WithContext(ctx).Raw(`SELECT l2.user_id AS user_id, COUNT(*) AS score
+		FROM likes l1 JOIN likes l2 ON l2.target_id = l1.target_id AND l2.type = l1.type AND l2.user_id <> l1.user_id
+		WHERE l1.user_id = ? AND l1.type = ? AND l1.created_at >= ? AND l1.deleted_at IS NULL AND l2.deleted_at IS NULL
+		AND `+suggestExclusion("l2.user_id")+`
+		GROUP BY l2.user_id ORDER BY score DESC LIMIT ?`, userID, model.TargetTypePost, since, userID, userID, userID, limit).Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"go-zhihu/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TopicRepository struct {
+	DB *gorm.DB
+}
+
+func NewTopicRepository(db *gorm.DB) *TopicRepository {
+	return &TopicRepository{DB: db}
+}
+
+// 按名称查找话题，不存在的自动创建
+func (r *TopicRepository) FindOrCreateByNames(ctx context.Context, tx *gorm.DB, names []string) ([]model.Topic, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	if len(names) == 0 {
+		return []model.Topic{}, nil
+	}
+	topics := make([]model.Topic, 0, len(names))
+	for _, name := range names {
+		topics = append(topics, model.Topic{Name: name})
+	}
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&topics).Error; err != nil {
+		return nil, err
+	}
+	var result []model.Topic
+	err := db.WithContext(ctx).Where("name IN ?", names).Find(&result).Error
+	return result, err
+}
+
+func (r *TopicRepository) FindTopicByID(ctx context.Context, tx *gorm.DB, id uint) (*model.Topic, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var topic model.Topic
+	if err := db.WithContext(ctx).First(&topic, id).Error; err != nil {
+		return nil, err
+	}
+	return &topic, nil
+}
+func (r *TopicRepository) FindTopicsByIDs(ctx context.Context, tx *gorm.DB, ids []uint) ([]model.Topic, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	var topics []model.Topic
+	err := db.WithContext(ctx).Where("id IN ?", ids).Find(&topics).Error
+	return topics, err
+}
+
+// 给文章绑定话题
+func (r *TopicRepository) BindPostTopics(ctx context.Context, tx *gorm.DB, postID uint, topicIDs []uint) error {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	if len(topicIDs) == 0 {
+		return nil
+	}
+	rows := make([]model.PostTopic, 0, len(topicIDs))
+	for _, tid := range topicIDs {
+		rows = append(rows, model.PostTopic{PostID: postID, TopicID: tid})
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// 批量获取文章的话题ID，key为文章ID
+func (r *TopicRepository) GetTopicIDsByPosts(ctx context.Context, tx *gorm.DB, postIDs []uint) (map[uint][]uint, error) {
+	db := r.DB
+	if tx != nil {
+		db = tx
+	}
+	result := make(map[uint][]uint)
+	if len(postIDs) == 0 {
+		return result, nil
+	}
+	var rows []model.PostTopic
+	if err := db.WithContext(ctx).Where("post_id IN ?", postIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.PostID] = append(result[row.PostID], row.TopicID)
+	}
+	return result, nil
+}
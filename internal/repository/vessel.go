@@ -12,6 +12,18 @@ type Repositories struct {
 	Connection   *ConnectRepository
 	Notification *NotificationRepository
 	Message      *MessageRepository
+	Topic        *TopicRepository
+	Stats        *StatsRepository
+	Folder       *FolderRepository
+	History      *HistoryRepository
+	Recommend    *RecommendRepository
+	Activity     *ActivityRepository
+	Block        *BlockRepository
+	Suggest      *SuggestRepository
+	FollowReq    *FollowRequestRepository
+	SearchLog    *SearchLogRepository
+	Similar      *SimilarRepository
+	Question     *QuestionFollowRepository
 }
 
 func NewRepositories(db *gorm.DB) *Repositories {
@@ -25,5 +37,17 @@ func NewRepositories(db *gorm.DB) *Repositories {
 		Connection:   NewConnectionRepository(db),
 		Notification: NewNotificationRepository(db),
 		Message:      NewMessageRepository(db),
+		Topic:        NewTopicRepository(db),
+		Stats:        NewStatsRepository(db),
+		Folder:       NewFolderRepository(db),
+		History:      NewHistoryRepository(db),
+		Recommend:    NewRecommendRepository(db),
+		Activity:     NewActivityRepository(db),
+		Block:        NewBlockRepository(db),
+		Suggest:      NewSuggestRepository(db),
+		FollowReq:    NewFollowRequestRepository(db),
+		SearchLog:    NewSearchLogRepository(db),
+		Similar:      NewSimilarRepository(db),
+		Question:     NewQuestionFollowRepository(db),
 	}
 }
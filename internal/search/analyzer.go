@@ -0,0 +1,77 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// 中日韩文字没有空格分词，按相邻两字切分(bigram)；其他文字按字母数字连续串切分并转小写
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// 建索引时单字和双字都收录，这样单字查询也能命中
+func analyzeDocument(text string) []string {
+	return analyze(text, true)
+}
+
+// 查询时连续两个字以上只用双字，单独一个字才用单字，避免单字把结果放得太宽
+func analyzeQuery(text string) []string {
+	terms := analyze(text, false)
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+func analyze(text string, withUnigram bool) []string {
+	text = htmlTag.ReplaceAllString(text, " ")
+	var terms []string
+	var word strings.Builder
+	var run []rune
+	flushWord := func() {
+		if word.Len() > 0 {
+			terms = append(terms, word.String())
+			word.Reset()
+		}
+	}
+	flushRun := func() {
+		if len(run) == 1 {
+			terms = append(terms, string(run))
+		}
+		for i := 0; i+1 < len(run); i++ {
+			if withUnigram {
+				terms = append(terms, string(run[i]))
+			}
+			terms = append(terms, string(run[i:i+2]))
+		}
+		if withUnigram && len(run) > 1 {
+			terms = append(terms, string(run[len(run)-1]))
+		}
+		run = run[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushRun()
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flushWord()
+			flushRun()
+		}
+	}
+	flushWord()
+	flushRun()
+	return terms
+}
@@ -0,0 +1,35 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAnalyzeQuery(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"Hello, World", []string{"hello", "world"}},
+		{"v2 版本", []string{"v2", "版本"}},
+		{"中国人", []string{"中国", "国人"}},
+		{"猫", []string{"猫"}},
+		{"学习Go语言", []string{"学习", "go", "语言"}},
+		{"Go语言<b>教程</b>", []string{"go", "语言", "教程"}},
+		{"测试 测试 Go GO", []string{"测试", "go"}},
+		{"，。!?", nil},
+	}
+	for _, c := range cases {
+		if got := analyzeQuery(c.in); !slices.Equal(got, c.want) {
+			t.Errorf("analyzeQuery(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestAnalyzeDocumentKeepsUnigrams(t *testing.T) {
+	got := analyzeDocument("中国人")
+	want := []string{"中", "中国", "国", "国人", "人"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("analyzeDocument = %q, want %q", got, want)
+	}
+}
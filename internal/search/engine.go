@@ -0,0 +1,40 @@
+package search
+
+import "context"
+
+// 搜索引擎的可替换实现：mysql直接查posts表的全文索引，memory在进程内维护倒排索引
+const (
+	EngineMySQL  = "mysql"
+	EngineMemory = "memory"
+)
+
+// 建索引用的文章内容
+type Document struct {
+	ID      uint
+	Title   string
+	Content string
+}
+
+type Query struct {
+	Keyword string
+	// 最多返回多少条，状态、可见性等过滤由调用方在结果上完成
+	Limit int
+}
+
+// 一条命中，按Score从高到低返回
+type Hit struct {
+	ID    uint
+	Score float64
+}
+
+// 全量建索引时分批读取文章，afterID为上一批最后一篇的id，返回空表示读完
+type Loader func(ctx context.Context, afterID uint, limit int) ([]Document, error)
+
+type Engine interface {
+	Name() string
+	Index(ctx context.Context, doc Document) error
+	Delete(ctx context.Context, id uint) error
+	Search(ctx context.Context, q Query) ([]Hit, error)
+	// 从数据库全量建索引，不自己存索引的实现直接返回
+	Rebuild(ctx context.Context, load Loader, batchSize int) error
+}
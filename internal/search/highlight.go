@@ -0,0 +1,84 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	highlightOpen  = "<em>"
+	highlightClose = "</em>"
+	ellipsis       = "..."
+)
+
+// 去掉html标签，把命中关键词的部分用<em>包起来，其余内容做html转义；
+// width>0时只取第一处命中附近的width个字作为摘要，width为0时返回全文
+func Highlight(text, keyword string, width int) string {
+	plain := strings.Join(strings.Fields(htmlTag.ReplaceAllString(text, " ")), " ")
+	runes := []rune(plain)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range analyzeQuery(keyword) {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if !hasPrefix(lower[i:], t) {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+	start, end := 0, len(runes)
+	if width > 0 && len(runes) > width {
+		// 命中的位置放在摘要靠前的地方，前面留一点上下文
+		if first > width/4 {
+			start = first - width/4
+		}
+		end = min(start+width, len(runes))
+		start = max(0, end-width)
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString(ellipsis)
+	}
+	open := false
+	for i := start; i < end; i++ {
+		if marked[i] != open {
+			if open {
+				b.WriteString(highlightClose)
+			} else {
+				b.WriteString(highlightOpen)
+			}
+			open = marked[i]
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+	}
+	if open {
+		b.WriteString(highlightClose)
+	}
+	if end < len(runes) {
+		b.WriteString(ellipsis)
+	}
+	return b.String()
+}
+
+func hasPrefix(s, prefix []rune) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if s[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
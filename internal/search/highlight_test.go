@@ -0,0 +1,45 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightMarksTerms(t *testing.T) {
+	if got := Highlight("Go语言入门", "go", 0); got != "<em>Go</em>语言入门" {
+		t.Errorf("latin keyword: %q", got)
+	}
+	// 相邻的双字命中合并成一个<em>
+	if got := Highlight("红烧肉做法", "红烧肉", 0); got != "<em>红烧肉</em>做法" {
+		t.Errorf("cjk keyword: %q", got)
+	}
+	if got := Highlight("Go和Python对比", "python go", 0); got != "<em>Go</em>和<em>Python</em>对比" {
+		t.Errorf("two keywords: %q", got)
+	}
+	if got := Highlight("Go语言入门", "java", 0); got != "Go语言入门" {
+		t.Errorf("no match: %q", got)
+	}
+}
+
+func TestHighlightEscapesContent(t *testing.T) {
+	got := Highlight(`<p>a <script>x</script> & "粗体"</p>`, "粗体", 0)
+	if strings.Contains(got, "<script>") || strings.Contains(got, "<p>") {
+		t.Fatalf("tags should be stripped: %q", got)
+	}
+	if want := "a x &amp; &#34;<em>粗体</em>&#34;"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	text := strings.Repeat("甲", 20) + "目标" + strings.Repeat("乙", 20)
+	if got := Highlight(text, "目标", 8); got != "...甲甲<em>目标</em>乙乙乙乙..." {
+		t.Errorf("snippet in the middle: %q", got)
+	}
+	if got := Highlight(strings.Repeat("甲", 20)+"目标", "目标", 8); got != "...甲甲甲甲甲甲<em>目标</em>" {
+		t.Errorf("snippet at the end: %q", got)
+	}
+	if got := Highlight(text, "java", 8); got != strings.Repeat("甲", 8)+"..." {
+		t.Errorf("snippet without match: %q", got)
+	}
+}
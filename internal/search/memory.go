@@ -0,0 +1,172 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+)
+
+// BM25参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// 进程内的倒排索引，启动时从数据库全量建立，之后由索引任务增量更新；
+// 每个进程各有一份，多实例部署时由调用方把更新广播给所有实例
+type MemoryEngine struct {
+	titleBoost float64
+	mu         sync.RWMutex
+	postings   map[string]map[uint]float64
+	docs       map[uint]*memDoc
+	totalLen   float64
+}
+
+type memDoc struct {
+	terms  map[string]float64
+	length float64
+}
+
+func NewMemoryEngine(titleBoost float64) *MemoryEngine {
+	if titleBoost <= 0 {
+		titleBoost = 1
+	}
+	return &MemoryEngine{
+		titleBoost: titleBoost,
+		postings:   make(map[string]map[uint]float64),
+		docs:       make(map[uint]*memDoc),
+	}
+}
+
+func (m *MemoryEngine) Name() string {
+	return EngineMemory
+}
+
+// 标题中的词按titleBoost加权计入词频
+func (m *MemoryEngine) Index(ctx context.Context, doc Document) error {
+	d := &memDoc{terms: make(map[string]float64)}
+	for _, t := range analyzeDocument(doc.Title) {
+		d.terms[t] += m.titleBoost
+		d.length += m.titleBoost
+	}
+	for _, t := range analyzeDocument(doc.Content) {
+		d.terms[t]++
+		d.length++
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(doc.ID)
+	if len(d.terms) == 0 {
+		return nil
+	}
+	for t, tf := range d.terms {
+		list := m.postings[t]
+		if list == nil {
+			list = make(map[uint]float64)
+			m.postings[t] = list
+		}
+		list[doc.ID] = tf
+	}
+	m.docs[doc.ID] = d
+	m.totalLen += d.length
+	return nil
+}
+
+func (m *MemoryEngine) Delete(ctx context.Context, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	return nil
+}
+
+// 调用方持有写锁
+func (m *MemoryEngine) remove(id uint) {
+	d, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	for t := range d.terms {
+		list := m.postings[t]
+		delete(list, id)
+		if len(list) == 0 {
+			delete(m.postings, t)
+		}
+	}
+	m.totalLen -= d.length
+	delete(m.docs, id)
+}
+
+// 所有查询词都要命中，按BM25打分
+func (m *MemoryEngine) Search(ctx context.Context, q Query) ([]Hit, error) {
+	terms := analyzeQuery(q.Keyword)
+	if len(terms) == 0 {
+		return []Hit{}, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	lists := make([]map[uint]float64, 0, len(terms))
+	for _, t := range terms {
+		list := m.postings[t]
+		if len(list) == 0 {
+			return []Hit{}, nil
+		}
+		lists = append(lists, list)
+	}
+	// 从最短的倒排表开始求交集
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	n := float64(len(m.docs))
+	avgLen := m.totalLen / n
+	hits := make([]Hit, 0, len(lists[0]))
+	for id := range lists[0] {
+		doc := m.docs[id]
+		score := 0.0
+		matched := true
+		for _, list := range lists {
+			tf, ok := list[id]
+			if !ok {
+				matched = false
+				break
+			}
+			df := float64(len(list))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*doc.length/avgLen))
+		}
+		if matched {
+			hits = append(hits, Hit{ID: id, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// 分批读取文章逐篇加入索引，期间的增量更新照常生效
+func (m *MemoryEngine) Rebuild(ctx context.Context, load Loader, batchSize int) error {
+	var afterID uint
+	for {
+		docs, err := load(ctx, afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		for _, doc := range docs {
+			if err := m.Index(ctx, doc); err != nil {
+				return err
+			}
+		}
+		afterID = docs[len(docs)-1].ID
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package search
+
+import (
+	"context"
+	"testing"
+)
+
+func searchIDs(t *testing.T, m *MemoryEngine, keyword string, limit int) []uint {
+	t.Helper()
+	hits, err := m.Search(context.Background(), Query{Keyword: keyword, Limit: limit})
+	if err != nil {
+		t.Fatalf("search %q: %v", keyword, err)
+	}
+	ids := make([]uint, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	return ids
+}
+
+func TestMemoryEngine(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryEngine(2)
+	for _, d := range []Document{
+		{ID: 1, Title: "Go语言入门", Content: "学习Go的基础语法"},
+		{ID: 2, Title: "Python教程", Content: "Go和Python对比"},
+		{ID: 3, Title: "烹饪", Content: "红烧肉做法"},
+	} {
+		if err := m.Index(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 标题加权，标题命中的排在前面
+	if ids := searchIDs(t, m, "go", 0); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("go: got %v, want [1 2]", ids)
+	}
+	if ids := searchIDs(t, m, "go", 1); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("go with limit 1: got %v, want [1]", ids)
+	}
+	// 所有词都要命中
+	if ids := searchIDs(t, m, "python go", 0); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("python go: got %v, want [2]", ids)
+	}
+	// 单字查询靠索引里的单字命中
+	if ids := searchIDs(t, m, "肉", 0); len(ids) != 1 || ids[0] != 3 {
+		t.Errorf("肉: got %v, want [3]", ids)
+	}
+	if ids := searchIDs(t, m, "不存在", 0); len(ids) != 0 {
+		t.Errorf("unknown term matched %v", ids)
+	}
+
+	// 重新索引会替换旧内容
+	if err := m.Index(ctx, Document{ID: 2, Title: "Python教程", Content: "只讲Python"}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, m, "go", 0); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("go after reindex: got %v, want [1]", ids)
+	}
+	if err := m.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, m, "go", 0); len(ids) != 0 {
+		t.Errorf("go after delete: got %v", ids)
+	}
+	if _, ok := m.postings["go"]; ok {
+		t.Error("empty posting list should be removed")
+	}
+}
+
+func TestMemoryEngineTieBreak(t *testing.T) {
+	m := NewMemoryEngine(1)
+	for id := uint(1); id <= 3; id++ {
+		_ = m.Index(context.Background(), Document{ID: id, Title: "相同标题"})
+	}
+	if ids := searchIDs(t, m, "相同", 0); len(ids) != 3 || ids[0] != 3 || ids[2] != 1 {
+		t.Errorf("equal scores should be ordered by id desc, got %v", ids)
+	}
+}
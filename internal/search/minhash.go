@@ -0,0 +1,101 @@
+package search
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// MinHash签名长度和LSH分段：64个哈希分成32段、每段2个，相似度0.3左右的文章就有很大概率落进同一个桶
+const (
+	MinHashSize = 64
+	LSHBands    = 32
+	lshRows     = MinHashSize / LSHBands
+)
+
+// 每个哈希函数是 a*x+b (mod 2^64)，a取奇数；参数用固定种子生成，保证重启后签名不变
+var minHashParams = func() [MinHashSize][2]uint64 {
+	var params [MinHashSize][2]uint64
+	seed := uint64(0x9e3779b97f4a7c15)
+	next := func() uint64 {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		return z ^ (z >> 31)
+	}
+	for i := range params {
+		params[i] = [2]uint64{next() | 1, next()}
+	}
+	return params
+}()
+
+// 文本切成的特征集合，与搜索查询的切分方式相同(中文双字、其他文字按词)
+func Shingles(text string) []string {
+	return analyzeQuery(text)
+}
+
+// 计算MinHash签名，没有特征时返回nil
+func MinHash(shingles []string) []uint64 {
+	if len(shingles) == 0 {
+		return nil
+	}
+	sig := make([]uint64, MinHashSize)
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for _, s := range shingles {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(s))
+		x := h.Sum64()
+		for i, p := range minHashParams {
+			if v := p[0]*x + p[1]; v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// 每段的桶号，第i个元素对应第i段；只取63位，方便存进数据库
+func LSHBuckets(sig []uint64) []uint64 {
+	buckets := make([]uint64, 0, LSHBands)
+	buf := make([]byte, 8)
+	for band := 0; band < LSHBands; band++ {
+		h := fnv.New64a()
+		for _, v := range sig[band*lshRows : (band+1)*lshRows] {
+			binary.BigEndian.PutUint64(buf, v)
+			_, _ = h.Write(buf)
+		}
+		buckets = append(buckets, h.Sum64()&(1<<63-1))
+	}
+	return buckets
+}
+
+// 两个签名相同位置取值相等的比例，即Jaccard相似度的估计
+func Similarity(a, b []uint64) float64 {
+	if len(a) != MinHashSize || len(b) != MinHashSize {
+		return 0
+	}
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / MinHashSize
+}
+func EncodeSignature(sig []uint64) []byte {
+	buf := make([]byte, 8*len(sig))
+	for i, v := range sig {
+		binary.BigEndian.PutUint64(buf[i*8:], v)
+	}
+	return buf
+}
+func DecodeSignature(buf []byte) []uint64 {
+	sig := make([]uint64, len(buf)/8)
+	for i := range sig {
+		sig[i] = binary.BigEndian.Uint64(buf[i*8:])
+	}
+	return sig
+}
@@ -0,0 +1,62 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func shingleRange(from, to int) []string {
+	list := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		list = append(list, fmt.Sprintf("s%d", i))
+	}
+	return list
+}
+
+func TestSimilarityEstimatesJaccard(t *testing.T) {
+	if s := Similarity(MinHash([]string{"a", "b", "c"}), MinHash([]string{"c", "b", "a", "a"})); s != 1 {
+		t.Errorf("same set in a different order: %v, want 1", s)
+	}
+	// 64个哈希，估计误差的标准差约0.06
+	if s := Similarity(MinHash(shingleRange(0, 150)), MinHash(shingleRange(50, 200))); math.Abs(s-0.5) > 0.2 {
+		t.Errorf("jaccard 0.5: got %v", s)
+	}
+	if s := Similarity(MinHash(shingleRange(0, 100)), MinHash(shingleRange(100, 200))); s > 0.2 {
+		t.Errorf("disjoint sets: got %v", s)
+	}
+}
+
+func TestSimilarityInvalidSignature(t *testing.T) {
+	if MinHash(nil) != nil {
+		t.Fatal("MinHash(nil) should be nil")
+	}
+	sig := MinHash([]string{"a"})
+	if s := Similarity(nil, sig); s != 0 {
+		t.Errorf("nil signature: %v", s)
+	}
+	if s := Similarity(sig[:10], sig[:10]); s != 0 {
+		t.Errorf("truncated signature: %v", s)
+	}
+}
+
+func TestSignatureStable(t *testing.T) {
+	sig := MinHash(Shingles("如何学习Go语言的并发编程"))
+	if got := DecodeSignature(EncodeSignature(sig)); !slices.Equal(got, sig) {
+		t.Fatal("signature changed after encode/decode")
+	}
+	buckets := LSHBuckets(sig)
+	if len(buckets) != LSHBands {
+		t.Fatalf("got %d buckets, want %d", len(buckets), LSHBands)
+	}
+	for _, b := range buckets {
+		if b >= 1<<63 {
+			t.Fatalf("bucket %d does not fit in a signed 64-bit column", b)
+		}
+	}
+	// 参数由固定种子生成，重启后同一段文字落在同样的桶里
+	if !slices.Equal(buckets, LSHBuckets(MinHash(Shingles("如何学习Go语言的并发编程")))) {
+		t.Fatal("buckets differ for the same text")
+	}
+}
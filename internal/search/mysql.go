@@ -0,0 +1,39 @@
+package search
+
+import (
+	"context"
+	"go-zhihu/internal/repository"
+)
+
+// 直接使用posts表上的ngram全文索引，索引随表数据自动更新
+type MySQLEngine struct {
+	postRepo *repository.PostRepository
+}
+
+func NewMySQLEngine(post *repository.PostRepository) *MySQLEngine {
+	return &MySQLEngine{postRepo: post}
+}
+
+func (m *MySQLEngine) Name() string {
+	return EngineMySQL
+}
+func (m *MySQLEngine) Index(ctx context.Context, doc Document) error {
+	return nil
+}
+func (m *MySQLEngine) Delete(ctx context.Context, id uint) error {
+	return nil
+}
+func (m *MySQLEngine) Search(ctx context.Context, q Query) ([]Hit, error) {
+	matches, err := m.postRepo.MatchPosts(ctx, nil, q.Keyword, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]Hit, 0, len(matches))
+	for _, match := range matches {
+		hits = append(hits, Hit{ID: match.ID, Score: match.Score})
+	}
+	return hits, nil
+}
+func (m *MySQLEngine) Rebuild(ctx context.Context, load Loader, batchSize int) error {
+	return nil
+}
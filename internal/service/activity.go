@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 动态：记录关注的人发文、提问、回答、赞同、关注问题，读时按关注列表拉取并聚合
+type ActivityService struct {
+	repo         *repository.ActivityRepository
+	relationRepo *repository.RelationRepository
+	userRepo     *repository.UserRepository
+	postRepo     *repository.PostRepository
+	commentRepo  *repository.CommentRepository
+	block        *BlockService
+}
+
+func NewActivityService(repo *repository.ActivityRepository, relation *repository.RelationRepository, user *repository.UserRepository, post *repository.PostRepository, comment *repository.CommentRepository, block *BlockService) *ActivityService {
+	return &ActivityService{repo: repo, relationRepo: relation, userRepo: user, postRepo: post, commentRepo: comment, block: block}
+}
+
+// 动态类型在接口里的名字，顺序即设置页展示顺序
+var activityTypes = []struct {
+	Type int
+	Name string
+}{
+	{model.ActivityPublishArticle, "publish_article"},
+	{model.ActivityAskQuestion, "ask_question"},
+	{model.ActivityAnswer, "answer"},
+	{model.ActivityUpvoteAnswer, "upvote_answer"},
+	{model.ActivityLikeArticle, "like_article"},
+	{model.ActivityFollowQuestion, "follow_question"},
+}
+
+func activityTypeName(activityType int) string {
+	for _, t := range activityTypes {
+		if t.Type == activityType {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+const (
+	// 每轮按页大小的几倍取原始动态，聚合后才能凑满一页
+	activityBatchFactor = 4
+	activityMaxRounds   = 3
+	// 聚合后最多展示几个人，其余只计数
+	activityMaxActors = 3
+)
+
+// 记录动态，失败只打日志不影响主流程
+// 时间截到秒，和游标的秒级精度一致，同一秒内按id排序不会错位
+func (s *ActivityService) Record(ctx context.Context, tx *gorm.DB, actorID uint, activityType int, postID, commentID uint) {
+	activity := &model.Activity{
+		ActorID:   actorID,
+		Type:      activityType,
+		PostID:    postID,
+		CommentID: commentID,
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	if err := s.repo.Upsert(ctx, tx, activity); err != nil {
+		log.Printf("record activity failed:%v", err)
+	}
+}
+func (s *ActivityService) Remove(ctx context.Context, tx *gorm.DB, actorID uint, activityType int, postID, commentID uint) {
+	if err := s.repo.Remove(ctx, tx, actorID, activityType, postID, commentID); err != nil {
+		log.Printf("remove activity failed:%v", err)
+	}
+}
+
+// 用户想看的动态类型
+func (s *ActivityService) enabledTypes(ctx context.Context, tx *gorm.DB, userID uint) ([]int, error) {
+	hidden, err := s.userRepo.GetHiddenActivity(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]int, 0, len(activityTypes))
+	for _, t := range activityTypes {
+		if hidden&(1<<t.Type) == 0 {
+			types = append(types, t.Type)
+		}
+	}
+	return types, nil
+}
+
+// 聚合中的一组：同一类型、同一对象的多条动态
+type activityGroup struct {
+	first    model.Activity
+	actorIDs []uint
+}
+
+// 获取关注的人的动态，同一对象上的同类动态合并成一条("A等4人赞同了该回答")
+// 聚合只在本页读到的动态内进行，跨页的同一对象会在下一页再出现一次
+func (s *ActivityService) GetActivities(ctx context.Context, tx *gorm.DB, userID uint, cursor string, pageSize int) (*CursorPage, error) {
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	page := &CursorPage{List: []ActivityVO{}}
+	followeeIDs, err := s.relationRepo.GetFolloweeIDs(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	types, err := s.enabledTypes(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	if len(followeeIDs) == 0 || len(types) == 0 {
+		return page, nil
+	}
+	// 关注的人赞了被我拉黑的人的文章，这类动态也不展示
+	hidden, err := s.block.hiddenUserIDs(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+
+	var groups []*activityGroup
+	index := make(map[string]*activityGroup)
+	batch := pageSize * activityBatchFactor
+	for round := 0; round < activityMaxRounds; round++ {
+		rows, err := s.repo.ListByActors(ctx, tx, userID, followeeIDs, types, hidden, c.Score, c.ID, batch)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		full := false
+		for _, a := range rows {
+			key := fmt.Sprintf("%d:%d:%d", a.Type, a.PostID, a.CommentID)
+			g, ok := index[key]
+			if !ok {
+				// 已经凑满一页又遇到新对象，停在这里，下一页从它开始
+				if len(groups) == pageSize {
+					full = true
+					break
+				}
+				g = &activityGroup{first: a}
+				index[key] = g
+				groups = append(groups, g)
+			}
+			g.actorIDs = append(g.actorIDs, a.ActorID)
+			c = Cursor{Score: a.CreatedAt.Unix(), ID: a.ID}
+		}
+		if full || (len(rows) == batch && round == activityMaxRounds-1) {
+			page.HasMore = true
+			break
+		}
+		if len(rows) < batch {
+			break
+		}
+	}
+	if page.HasMore {
+		page.NextCursor = EncodeCursor(c.Score, c.ID)
+	}
+	if len(groups) == 0 {
+		return page, nil
+	}
+	list, err := s.buildActivityVOs(ctx, tx, groups)
+	if err != nil {
+		return nil, err
+	}
+	page.List = list
+	return page, nil
+}
+
+// 批量加载聚合结果用到的文章、回答和用户
+func (s *ActivityService) buildActivityVOs(ctx context.Context, tx *gorm.DB, groups []*activityGroup) ([]ActivityVO, error) {
+	var postIDs []string
+	var commentIDs, actorIDs []uint
+	for _, g := range groups {
+		postIDs = append(postIDs, strconv.FormatUint(uint64(g.first.PostID), 10))
+		if g.first.CommentID != 0 {
+			commentIDs = append(commentIDs, g.first.CommentID)
+		}
+		for i, id := range g.actorIDs {
+			if i == activityMaxActors {
+				break
+			}
+			actorIDs = append(actorIDs, id)
+		}
+	}
+	posts, err := s.postRepo.FindPostsByIDs(ctx, tx, postIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	postMap := make(map[uint]*model.Post, len(posts))
+	for i := range posts {
+		postMap[posts[i].ID] = &posts[i]
+	}
+	commentMap := make(map[uint]*model.Comment)
+	if len(commentIDs) > 0 {
+		comments, err := s.commentRepo.FindCommentsByIDs(ctx, tx, commentIDs)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		for i := range comments {
+			commentMap[comments[i].ID] = &comments[i]
+		}
+	}
+	users, err := s.userRepo.FindUsersByIDs(ctx, tx, actorIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	userMap := make(map[uint]model.User, len(users))
+	for _, u := range users {
+		userMap[u.ID] = u
+	}
+
+	list := make([]ActivityVO, 0, len(groups))
+	for _, g := range groups {
+		post, ok := postMap[g.first.PostID]
+		if !ok {
+			continue
+		}
+		vo := ActivityVO{
+			Type:       g.first.Type,
+			TypeName:   activityTypeName(g.first.Type),
+			ActorCount: len(g.actorIDs),
+			Post:       post,
+			CreatedAt:  g.first.CreatedAt,
+		}
+		if g.first.CommentID != 0 {
+			comment, ok := commentMap[g.first.CommentID]
+			if !ok {
+				continue
+			}
+			vo.Comment = comment
+		}
+		for i, id := range g.actorIDs {
+			if i == activityMaxActors {
+				break
+			}
+			if u, ok := userMap[id]; ok {
+				vo.Actors = append(vo.Actors, *newUserProfileVO(&u))
+			}
+		}
+		list = append(list, vo)
+	}
+	return list, nil
+}
+
+// 动态类型开关
+func (s *ActivityService) GetSettings(ctx context.Context, tx *gorm.DB, userID uint) ([]ActivitySettingVO, error) {
+	hidden, err := s.userRepo.GetHiddenActivity(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	settings := make([]ActivitySettingVO, 0, len(activityTypes))
+	for _, t := range activityTypes {
+		settings = append(settings, ActivitySettingVO{Type: t.Type, Name: t.Name, Enabled: hidden&(1<<t.Type) == 0})
+	}
+	return settings, nil
+}
+
+// 按类型名开关，没传的类型保持不变
+func (s *ActivityService) UpdateSettings(ctx context.Context, tx *gorm.DB, userID uint, enabled map[string]bool) error {
+	hidden, err := s.userRepo.GetHiddenActivity(ctx, tx, userID)
+	if err != nil {
+		return e.ErrServer
+	}
+	for name, on := range enabled {
+		activityType := 0
+		for _, t := range activityTypes {
+			if t.Name == name {
+				activityType = t.Type
+			}
+		}
+		if activityType == 0 {
+			return e.ErrInvalidArgs
+		}
+		if on {
+			hidden &^= 1 << activityType
+		} else {
+			hidden |= 1 << activityType
+		}
+	}
+	if err := s.userRepo.SetHiddenActivity(ctx, tx, userID, hidden); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
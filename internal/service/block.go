@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"go-zhihu/internal/job"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 拉黑：双向生效，拉黑后互相看不到对方的内容，关注、评论、点赞、私信都会被拒绝
+type BlockService struct {
+	repo         *repository.BlockRepository
+	relationRepo *repository.RelationRepository
+	userRepo     *repository.UserRepository
+	reqRepo      *repository.FollowRequestRepository
+	jobs         *job.Queue
+	rdb          *redis.Client
+}
+
+func NewBlockService(repo *repository.BlockRepository, relation *repository.RelationRepository, user *repository.UserRepository, req *repository.FollowRequestRepository, jobs *job.Queue, rdb *redis.Client) *BlockService {
+	return &BlockService{repo: repo, relationRepo: relation, userRepo: user, reqRepo: req, jobs: jobs, rdb: rdb}
+}
+
+// 拉黑并解除双方的关注和待审核的关注请求，时间线由后台任务清理
+func (s *BlockService) BlockUser(ctx context.Context, tx *gorm.DB, blockerID, blockedID uint) error {
+	if blockerID == blockedID {
+		return e.ErrSelfAction
+	}
+	if _, err := s.userRepo.FindUserByID(ctx, tx, blockedID); err != nil {
+		return e.ErrUserNotFoundInstance
+	}
+	if err := s.repo.Block(ctx, tx, blockerID, blockedID); err != nil {
+		return e.ErrServer
+	}
+	pairs := [][2]uint{{blockerID, blockedID}, {blockedID, blockerID}}
+	for _, p := range pairs {
+		removed, err := s.relationRepo.Unfollow(ctx, tx, p[0], p[1])
+		if err != nil {
+			return e.ErrServer
+		}
+		if removed {
+			dispatch(ctx, s.jobs, JobFeedRemoveAuthor, relationJob{FollowerID: p[0], FolloweeID: p[1]})
+		}
+		if _, err := s.reqRepo.Delete(ctx, tx, p[0], p[1]); err != nil {
+			return e.ErrServer
+		}
+	}
+	clearProfileCache(ctx, s.rdb, blockerID, blockedID)
+	return nil
+}
+func (s *BlockService) UnblockUser(ctx context.Context, tx *gorm.DB, blockerID, blockedID uint) error {
+	if err := s.repo.Unblock(ctx, tx, blockerID, blockedID); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 黑名单
+func (s *BlockService) ListBlocked(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]model.User, error) {
+	offset := (page - 1) * pageSize
+	return s.repo.ListBlocked(ctx, tx, userID, offset, pageSize)
+}
+
+// 两人之间有拉黑关系时返回ErrBlocked，供关注、评论、点赞、私信前检查
+func (s *BlockService) checkBlocked(ctx context.Context, tx *gorm.DB, a, b uint) error {
+	if a == 0 || b == 0 || a == b {
+		return nil
+	}
+	blocked, err := s.repo.IsBlockedEither(ctx, tx, a, b)
+	if err != nil {
+		return e.ErrServer
+	}
+	if blocked {
+		return e.ErrBlocked
+	}
+	return nil
+}
+
+// 对userID需要隐藏的用户(拉黑和被拉黑)，游客返回空
+func (s *BlockService) hiddenUsers(ctx context.Context, tx *gorm.DB, userID uint) (map[uint]bool, error) {
+	hidden := make(map[uint]bool)
+	if userID == 0 {
+		return hidden, nil
+	}
+	ids, err := s.repo.RelatedUserIDs(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		hidden[id] = true
+	}
+	return hidden, nil
+}
+func (s *BlockService) hiddenUserIDs(ctx context.Context, tx *gorm.DB, userID uint) ([]uint, error) {
+	if userID == 0 {
+		return nil, nil
+	}
+	return s.repo.RelatedUserIDs(ctx, tx, userID)
+}
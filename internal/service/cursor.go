@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"go-zhihu/internal/model"
+	"go-zhihu/pkg/e"
+)
+
+// 游标：上一页最后一条的排序分数(created_at秒级时间戳)和id
+// 对客户端不透明，只原样带回，避免翻页期间有新数据插入导致重复或漏读
+type Cursor struct {
+	Score int64
+	ID    uint
+}
+
+// 游标分页的返回结果
+type CursorPage struct {
+	List       interface{} `json:"list"`
+	NextCursor string      `json:"next_cursor"`
+	HasMore    bool        `json:"has_more"`
+}
+
+func EncodeCursor(score int64, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", score, id)))
+}
+
+// 解析客户端带回的游标，空串表示第一页
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	if s == "" {
+		return c, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, e.ErrInvalidArgs
+	}
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &c.Score, &c.ID); err != nil || c.ID == 0 {
+		return Cursor{}, e.ErrInvalidArgs
+	}
+	return c, nil
+}
+
+// 多取一条判断是否还有下一页，key返回一条记录的排序分数和id
+func newCursorPage[T any](list []T, pageSize int, key func(T) (int64, uint)) *CursorPage {
+	page := &CursorPage{List: list}
+	if len(list) > pageSize {
+		list = list[:pageSize]
+		page.List = list
+		page.HasMore = true
+		page.NextCursor = EncodeCursor(key(list[len(list)-1]))
+	}
+	return page
+}
+func postCursorKey(p model.Post) (int64, uint) {
+	return p.CreatedAt.Unix(), p.ID
+}
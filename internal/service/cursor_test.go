@@ -0,0 +1,53 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"go-zhihu/pkg/e"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, want := range []Cursor{{Score: 1714560000, ID: 42}, {Score: 0, ID: 1}, {Score: -5, ID: 3}} {
+		got, err := DecodeCursor(EncodeCursor(want.Score, want.ID))
+		if err != nil {
+			t.Fatalf("decode %+v: %v", want, err)
+		}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestDecodeCursorFirstPage(t *testing.T) {
+	c, err := DecodeCursor("")
+	if err != nil || c != (Cursor{}) {
+		t.Fatalf("DecodeCursor(\"\") = %+v, %v", c, err)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	for _, s := range []string{"!!!", enc([]byte("100")), enc([]byte("100:0")), enc([]byte("a:b"))} {
+		if _, err := DecodeCursor(s); !errors.Is(err, e.ErrInvalidArgs) {
+			t.Errorf("DecodeCursor(%q) error = %v, want ErrInvalidArgs", s, err)
+		}
+	}
+}
+
+func TestNewCursorPage(t *testing.T) {
+	key := func(n int) (int64, uint) { return int64(n * 10), uint(n) }
+
+	page := newCursorPage([]int{3, 2, 1}, 2, key)
+	if !page.HasMore || len(page.List.([]int)) != 2 {
+		t.Fatalf("extra row should be cut off and mark has_more, got %+v", page)
+	}
+	if page.NextCursor != EncodeCursor(20, 2) {
+		t.Errorf("next cursor should point at the last returned row, got %q", page.NextCursor)
+	}
+
+	page = newCursorPage([]int{3, 2}, 2, key)
+	if page.HasMore || page.NextCursor != "" {
+		t.Errorf("full last page should not have more, got %+v", page)
+	}
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/job"
+	"go-zhihu/internal/mail"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 某个用户某一天(用户本地日期)已经处理过摘要，防止重复发送和多实例重复投递
+const digestSentKey = "digest:sent:%d:%s"
+
+const digestExcerptLen = 80
+
+type digestJob struct {
+	UserID uint `json:"user_id"`
+	Weekly bool `json:"weekly"`
+}
+
+type DigestService struct {
+	notifyRepo  *repository.NotificationRepository
+	postRepo    *repository.PostRepository
+	commentRepo *repository.CommentRepository
+	userRepo    *repository.UserRepository
+	notify      *NotificationService
+	block       *BlockService
+	mailer      mail.Mailer
+	jobs        *job.Queue
+	rdb         *redis.Client
+	secret      []byte
+}
+
+func NewDigestService(notifyRepo *repository.NotificationRepository, postRepo *repository.PostRepository, commentRepo *repository.CommentRepository, userRepo *repository.UserRepository, notify *NotificationService, block *BlockService, mailer mail.Mailer, jobs *job.Queue, rdb *redis.Client, jwtSecret string) *DigestService {
+	secret := config.Setting.Mail.UnsubscribeSecret
+	if secret == "" {
+		secret = jwtSecret
+	}
+	return &DigestService{
+		notifyRepo:  notifyRepo,
+		postRepo:    postRepo,
+		commentRepo: commentRepo,
+		userRepo:    userRepo,
+		notify:      notify,
+		block:       block,
+		mailer:      mailer,
+		jobs:        jobs,
+		rdb:         rdb,
+		secret:      []byte(secret),
+	}
+}
+
+// 配置的发信方式不可用时退回写文件，避免邮件配置错误导致服务起不来
+func newMailer(cfg config.MailConfig) mail.Mailer {
+	mailer, err := mail.NewMailer(cfg)
+	if err != nil {
+		log.Printf("%v, using file mailer", err)
+		return mail.NewFileMailer(cfg.From, cfg.FileDir)
+	}
+	return mailer
+}
+
+// 定时扫描用户，到了用户本地时间的发送点就投递发送任务，真正的组装和发信在任务里做
+func (s *DigestService) StartDigestWorker(ctx context.Context) {
+	interval := time.Duration(config.Setting.Notify.DigestScanMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.scan(ctx, time.Now()); err != nil {
+			log.Printf("scan digest users failed:%v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+func (s *DigestService) scan(ctx context.Context, now time.Time) error {
+	batch := config.Setting.Notify.DigestBatchSize
+	if batch <= 0 {
+		batch = 500
+	}
+	var afterID uint
+	for {
+		users, err := s.userRepo.ListDigestCandidates(ctx, nil, afterID, batch)
+		if err != nil {
+			return err
+		}
+		for i := range users {
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.schedule(ctx, &users[i], now)
+		}
+		if len(users) < batch {
+			return nil
+		}
+		afterID = users[len(users)-1].ID
+	}
+}
+
+// 每个用户每个本地日期只处理一次：过了发送点且不在免打扰时段时先占住当天的key，
+// 再按偏好决定是否投递；每周的摘要在配置的星期几随当天的一起发
+func (s *DigestService) schedule(ctx context.Context, u *model.User, now time.Time) {
+	local := now.In(userLocation(u))
+	if local.Hour() < config.Setting.Notify.DigestHour || quietAt(u, now) {
+		return
+	}
+	key := fmt.Sprintf(digestSentKey, u.ID, local.Format("2006-01-02"))
+	ok, err := s.rdb.SetNX(ctx, key, 1, 48*time.Hour).Result()
+	if err != nil || !ok {
+		return
+	}
+	weekly := int(local.Weekday()) == config.Setting.Notify.DigestWeekday
+	types, err := s.digestTypes(ctx, nil, u.ID, weekly)
+	if err != nil {
+		s.rdb.Del(ctx, key)
+		return
+	}
+	if len(types) == 0 {
+		return
+	}
+	if err := enqueue(ctx, s.jobs, JobDigest, digestJob{UserID: u.ID, Weekly: weekly}); err != nil {
+		// 下一轮扫描再试
+		s.rdb.Del(ctx, key)
+	}
+}
+
+// 本次摘要包含的通知类型：每天的总是包含，每周的只在每周那次包含
+func (s *DigestService) digestTypes(ctx context.Context, tx *gorm.DB, userID uint, weekly bool) ([]int, error) {
+	var types []int
+	for _, t := range notifyTypes {
+		pref, err := s.notify.preference(ctx, tx, userID, t.Type)
+		if err != nil {
+			return nil, err
+		}
+		if pref.Digest == model.DigestDaily || (weekly && pref.Digest == model.DigestWeekly) {
+			types = append(types, t.Type)
+		}
+	}
+	return types, nil
+}
+
+// 邮件模板用到的数据
+type digestNotification struct {
+	Summary string
+	Snippet string
+	URL     string
+}
+type digestAnswer struct {
+	Title   string
+	Author  string
+	Excerpt string
+	URL     string
+}
+type digestPost struct {
+	Title  string
+	Author string
+	URL    string
+}
+type digestData struct {
+	Subject           string
+	Username          string
+	Period            string
+	Notifications     []digestNotification
+	UnreadTotal       int64
+	MoreNotifications int64
+	NotificationsURL  string
+	Answers           []digestAnswer
+	Posts             []digestPost
+	UnsubscribeURL    string
+}
+
+// 组装并发送一个用户的摘要：未读通知、关注的问题的新回答、关注的人的热门内容，
+// 每周的摘要覆盖最近7天，否则覆盖最近1天；什么都没有时不发
+func (s *DigestService) SendDigest(ctx context.Context, tx *gorm.DB, userID uint, weekly bool) error {
+	user, err := s.userRepo.FindUserByID(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Email == "" || user.Status != 1 {
+		return nil
+	}
+	types, err := s.digestTypes(ctx, tx, userID, weekly)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	hidden, err := s.block.hiddenUserIDs(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	period, window := "今天", 24*time.Hour
+	if weekly {
+		period, window = "这一周", 7*24*time.Hour
+	}
+	since := time.Now().Add(-window)
+	limit := config.Setting.Notify.DigestMaxItems
+	if limit <= 0 {
+		limit = 10
+	}
+	site := strings.TrimRight(config.Setting.Mail.SiteURL, "/")
+	data := digestData{
+		Username:         user.Username,
+		Period:           period,
+		NotificationsURL: site + "/user/notifications",
+		UnsubscribeURL:   site + "/notifications/unsubscribe?token=" + url.QueryEscape(s.unsubscribeToken(userID)),
+	}
+
+	list, total, err := s.notifyRepo.UnreadSince(ctx, tx, userID, types, hidden, since, limit)
+	if err != nil {
+		return err
+	}
+	vos, err := s.notify.toVOs(ctx, tx, list, hidden)
+	if err != nil {
+		return err
+	}
+	for _, vo := range vos {
+		item := digestNotification{Summary: vo.Summary, Snippet: vo.Snippet, URL: data.NotificationsURL}
+		switch {
+		case vo.PostID != 0:
+			item.URL = fmt.Sprintf("%s/posts/%d", site, vo.PostID)
+		case vo.TargetType == model.TargetTypeUser:
+			item.URL = fmt.Sprintf("%s/users/%d/profile", site, vo.TargetID)
+		}
+		data.Notifications = append(data.Notifications, item)
+	}
+	data.UnreadTotal = total
+	data.MoreNotifications = total - int64(len(vos))
+
+	answers, err := s.commentRepo.AnswersToFollowedQuestions(ctx, tx, userID, hidden, since, limit)
+	if err != nil {
+		return err
+	}
+	for _, a := range answers {
+		data.Answers = append(data.Answers, digestAnswer{
+			Title:   a.Post.Title,
+			Author:  a.Author.Username,
+			Excerpt: truncateRunes(a.Content, digestExcerptLen),
+			URL:     fmt.Sprintf("%s/posts/%d", site, a.PostID),
+		})
+	}
+	posts, err := s.postRepo.TopFromFollowees(ctx, tx, userID, hidden, since, limit)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		data.Posts = append(data.Posts, digestPost{
+			Title:  p.Title,
+			Author: p.Author.Username,
+			URL:    fmt.Sprintf("%s/posts/%d", site, p.ID),
+		})
+	}
+	if len(data.Notifications) == 0 && len(data.Answers) == 0 && len(data.Posts) == 0 {
+		return nil
+	}
+
+	data.Subject = fmt.Sprintf("你%s错过的内容", period)
+	if total > 0 {
+		data.Subject = fmt.Sprintf("你%s有%d条未读通知", period, total)
+	}
+	html, text, err := mail.Render("digest", data)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: data.Subject,
+		HTML:    html,
+		Text:    text,
+		Headers: map[string]string{
+			// 邮件客户端的一键退订(RFC 8058)
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+// 退订令牌"用户ID.签名"，不过期；只能用来关闭该用户的摘要邮件
+func (s *DigestService) unsubscribeToken(userID uint) string {
+	return fmt.Sprintf("%d.%s", userID, s.sign(userID))
+}
+func (s *DigestService) sign(userID uint) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "digest-unsubscribe:%d", userID)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 校验退订令牌，返回对应的用户ID；只校验不改设置，供退订确认页使用
+func (s *DigestService) VerifyUnsubscribe(token string) (uint, error) {
+	idText, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, e.ErrInvalidUnsubscribe
+	}
+	id, err := strconv.ParseUint(idText, 10, 64)
+	if err != nil || id == 0 || !hmac.Equal([]byte(sig), []byte(s.sign(uint(id)))) {
+		return 0, e.ErrInvalidUnsubscribe
+	}
+	return uint(id), nil
+}
+
+// 凭邮件里的退订链接把所有类型的摘要都改成不发送，不需要登录
+func (s *DigestService) Unsubscribe(ctx context.Context, tx *gorm.DB, token string) error {
+	userID, err := s.VerifyUnsubscribe(token)
+	if err != nil {
+		return err
+	}
+	never := model.DigestNever
+	types := make(map[string]NotificationPrefPatch, len(notifyTypes))
+	for _, t := range notifyTypes {
+		types[t.Name] = NotificationPrefPatch{Digest: &never}
+	}
+	return s.notify.UpdatePreferences(ctx, tx, userID, types, nil, nil)
+}
@@ -1,11 +1,15 @@
 package service
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
+	"go-zhihu/config"
 	"go-zhihu/internal/model"
 	"go-zhihu/internal/repository"
 	"go-zhihu/pkg/e"
+	"log"
+	"sort"
 	"strconv"
 	"time"
 
@@ -24,16 +28,32 @@ func NewFeedService(feed *repository.FeedRepository, post *repository.PostReposi
 	return &FeedService{feedRepo: feed, postRepo: post, relationRepo: relation, rdb: rdb}
 }
 
-// 异步将被关注者的文章推送到关注者的时间线
-func (s *FeedService) PushPostsToFeed(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) {
-	posts, err := s.postRepo.FindRecentPostIDsByAuthor(ctx, tx, followeeID, FeedPushLimit)
-	if err != nil {
-		return
+const (
+	FeedOutboxPrefix = "feed:outbox:"
+	feedCelebrityKey = "feed:celebrities"
+	feedKeyTTL       = time.Hour * 24 * 7
+	// 页不满时最多补取的轮数
+	feedMaxFetchRounds = 3
+)
+
+func inboxKey(userID uint) string {
+	return fmt.Sprintf("%s%d", FeedKeyPrefix, userID)
+}
+func outboxKey(authorID uint) string {
+	return fmt.Sprintf("%s%d", FeedOutboxPrefix, authorID)
+}
+
+// 把被关注者最近的文章补到关注者的时间线，大V的文章读取时再拉取，这里不推
+func (s *FeedService) PushPostsToFeed(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) error {
+	celebrity, err := s.isCelebrity(ctx, followeeID)
+	if err != nil || celebrity {
+		return err
 	}
-	if len(posts) == 0 {
-		return
+	posts, err := s.postRepo.FindRecentPostIDsByAuthor(ctx, tx, followeeID, FeedPushLimit)
+	if err != nil || len(posts) == 0 {
+		return err
 	}
-	key := fmt.Sprintf("%s%d", FeedKeyPrefix, followerID)
+	key := inboxKey(followerID)
 
 	pipe := s.rdb.Pipeline()
 	for _, post := range posts {
@@ -42,58 +62,479 @@ func (s *FeedService) PushPostsToFeed(ctx context.Context, tx *gorm.DB, follower
 			Member: post.ID,
 		})
 	}
-	pipe.Expire(ctx, key, time.Hour*24*7)
-	_, _ = pipe.Exec(ctx)
+	trimInbox(ctx, pipe, key)
+	pipe.Expire(ctx, key, feedKeyTTL)
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
-// 推送feed
-func (s *PostService) DistributePostToFollowers(ct context.Context, tx *gorm.DB, post *model.Post) {
-	ctx := context.Background()
-	followerIDs, err := s.relation.GetFollowerIDs(ct, tx, post.AuthorID)
-	if err != nil {
-		return
+func (s *FeedService) isCelebrity(ctx context.Context, authorID uint) (bool, error) {
+	return s.rdb.SIsMember(ctx, feedCelebrityKey, authorID).Result()
+}
+
+// 写入作者发件箱，只保留最近的OutboxSize篇
+func (s *FeedService) addToOutbox(ctx context.Context, post *model.Post) error {
+	key := outboxKey(post.AuthorID)
+	pipe := s.rdb.Pipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(post.CreatedAt.Unix()), Member: post.ID})
+	if size := config.Setting.Feed.OutboxSize; size > 0 {
+		pipe.ZRemRangeByRank(ctx, key, 0, int64(-size-1))
+	}
+	pipe.Expire(ctx, key, feedKeyTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 发件箱过期或从未写过时从数据库重建
+func (s *FeedService) rebuildOutbox(ctx context.Context, tx *gorm.DB, authorID uint) error {
+	posts, err := s.postRepo.FindRecentPostIDsByAuthor(ctx, tx, authorID, config.Setting.Feed.OutboxSize)
+	if err != nil || len(posts) == 0 {
+		return err
+	}
+	key := outboxKey(authorID)
+	members := make([]*redis.Z, 0, len(posts))
+	for _, post := range posts {
+		members = append(members, &redis.Z{Score: float64(post.CreatedAt.Unix()), Member: post.ID})
 	}
 	pipe := s.rdb.Pipeline()
-	for _, fid := range followerIDs {
-		key := fmt.Sprintf("%s%d", FeedKeyPrefix, fid)
-		pipe.ZAdd(ctx, key, &redis.Z{
-			Score:  float64(post.CreatedAt.Unix()),
-			Member: post.ID,
-		}) //可以限制用户关注人数
-	}
-	_, _ = pipe.Exec(ctx)
-}
-func (s *FeedService) GetFeed(ct context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]model.Post, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("%s%d", FeedKeyPrefix, userID)
-	start := int64((page - 1) * pageSize)
-	end := start + int64(pageSize) - 1
-	postIDs, err := s.rdb.ZRevRange(ctx, key, start, end).Result()
-	if err == nil && len(postIDs) > 0 {
-		posts, err := s.postRepo.FindPostsByIDs(ct, tx, postIDs)
+	pipe.ZAdd(ctx, key, members...)
+	pipe.Expire(ctx, key, feedKeyTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 分发新文章：粉丝数达到阈值的作者只写发件箱，其余按批推到粉丝收件箱
+// 作为后台任务执行，返回错误时整体重试，zadd是幂等的
+func (s *FeedService) DistributePost(ctx context.Context, tx *gorm.DB, post *model.Post) error {
+	cfg := config.Setting.Feed
+	if err := s.addToOutbox(ctx, post); err != nil {
+		return err
+	}
+	followerIDs, err := s.relationRepo.GetFollowerIDs(ctx, tx, post.AuthorID)
+	if err != nil {
+		return err
+	}
+	wasCelebrity, err := s.isCelebrity(ctx, post.AuthorID)
+	if err != nil {
+		return err
+	}
+	if cfg.CelebrityThreshold > 0 && len(followerIDs) >= cfg.CelebrityThreshold {
+		if !wasCelebrity {
+			return s.rdb.SAdd(ctx, feedCelebrityKey, post.AuthorID).Err()
+		}
+		return nil
+	}
+	members := []*redis.Z{{Score: float64(post.CreatedAt.Unix()), Member: post.ID}}
+	if wasCelebrity {
+		// 掉回普通作者后粉丝不再拉取发件箱，把发件箱里最近的文章一起推过去，否则会从时间线消失
+		recent, err := s.rdb.ZRevRangeWithScores(ctx, outboxKey(post.AuthorID), 0, FeedPushLimit-1).Result()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		postMap := make(map[uint]model.Post)
-		for _, p := range posts {
-			postMap[p.ID] = p
+		for i := range recent {
+			members = append(members, &recent[i])
 		}
-		sortedPosts := make([]model.Post, 0, len(postIDs))
-		for _, idStr := range postIDs {
-			id, _ := strconv.ParseUint(idStr, 10, 64)
-			if p, ok := postMap[uint(id)]; ok {
-				sortedPosts = append(sortedPosts, p)
-			}
+	}
+	if err := s.pushToInboxes(ctx, followerIDs, members); err != nil {
+		return err
+	}
+	if wasCelebrity {
+		return s.rdb.SRem(ctx, feedCelebrityKey, post.AuthorID).Err()
+	}
+	return nil
+}
+
+// 按批写粉丝收件箱，避免一次性构造过大的pipeline
+func (s *FeedService) pushToInboxes(ctx context.Context, followerIDs []uint, members []*redis.Z) error {
+	batch := config.Setting.Feed.PushBatchSize
+	if batch <= 0 {
+		batch = 500
+	}
+	for i := 0; i < len(followerIDs); i += batch {
+		end := i + batch
+		if end > len(followerIDs) {
+			end = len(followerIDs)
+		}
+		pipe := s.rdb.Pipeline()
+		for _, fid := range followerIDs[i:end] {
+			key := inboxKey(fid)
+			pipe.ZAdd(ctx, key, members...)
+			trimInbox(ctx, pipe, key)
+			pipe.Expire(ctx, key, feedKeyTTL)
 		}
-		return sortedPosts, nil
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 收件箱只保留最新的InboxMaxLen条，更早的内容翻页时由数据库兜底
+func trimInbox(ctx context.Context, pipe redis.Pipeliner, key string) {
+	if max := config.Setting.Feed.InboxMaxLen; max > 0 {
+		pipe.ZRemRangeByRank(ctx, key, 0, int64(-max-1))
 	}
-	followeeIDs, err := s.relationRepo.GetFolloweeIDs(ct, tx, userID)
+}
+
+// 按批从粉丝收件箱删除文章
+func (s *FeedService) removeFromInboxes(ctx context.Context, followerIDs []uint, members []interface{}) error {
+	if len(members) == 0 {
+		return nil
+	}
+	batch := config.Setting.Feed.PushBatchSize
+	if batch <= 0 {
+		batch = 500
+	}
+	for i := 0; i < len(followerIDs); i += batch {
+		end := i + batch
+		if end > len(followerIDs) {
+			end = len(followerIDs)
+		}
+		pipe := s.rdb.Pipeline()
+		for _, fid := range followerIDs[i:end] {
+			pipe.ZRem(ctx, inboxKey(fid), members...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 作者可能还留在收件箱里的文章id
+func (s *FeedService) authorPostMembers(ctx context.Context, tx *gorm.DB, authorID uint) ([]interface{}, error) {
+	limit := config.Setting.Feed.InboxMaxLen
+	if limit <= 0 {
+		limit = FeedPushLimit
+	}
+	posts, err := s.postRepo.FindRecentPostIDsByAuthor(ctx, tx, authorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]interface{}, 0, len(posts))
+	for _, p := range posts {
+		members = append(members, p.ID)
+	}
+	return members, nil
+}
+
+// 取消关注：把对方的文章从自己的收件箱移除
+func (s *FeedService) RemoveAuthorFromFeed(ctx context.Context, tx *gorm.DB, followerID, authorID uint) error {
+	members, err := s.authorPostMembers(ctx, tx, authorID)
+	if err != nil || len(members) == 0 {
+		return err
+	}
+	return s.rdb.ZRem(ctx, inboxKey(followerID), members...).Err()
+}
+
+// 文章删除或下架：从作者发件箱和粉丝收件箱移除
+func (s *FeedService) RemovePostFromFeeds(ctx context.Context, tx *gorm.DB, authorID, postID uint) error {
+	if err := s.rdb.ZRem(ctx, outboxKey(authorID), postID).Err(); err != nil {
+		return err
+	}
+	followerIDs, err := s.relationRepo.GetFollowerIDs(ctx, tx, authorID)
+	if err != nil {
+		return err
+	}
+	return s.removeFromInboxes(ctx, followerIDs, []interface{}{postID})
+}
+
+// 作者被封禁：清空发件箱并从所有粉丝的收件箱移除其文章
+func (s *FeedService) RemoveAuthorPostsFromFeeds(ctx context.Context, tx *gorm.DB, authorID uint) error {
+	members, err := s.authorPostMembers(ctx, tx, authorID)
+	if err != nil {
+		return err
+	}
+	pipe := s.rdb.Pipeline()
+	pipe.Del(ctx, outboxKey(authorID))
+	pipe.SRem(ctx, feedCelebrityKey, authorID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	followerIDs, err := s.relationRepo.GetFollowerIDs(ctx, tx, authorID)
+	if err != nil {
+		return err
+	}
+	return s.removeFromInboxes(ctx, followerIDs, members)
+}
+
+// 作者解封：按当前粉丝数重新走推或拉
+func (s *FeedService) RestoreAuthorPosts(ctx context.Context, tx *gorm.DB, authorID uint) error {
+	if err := s.rebuildOutbox(ctx, tx, authorID); err != nil {
+		return err
+	}
+	followerIDs, err := s.relationRepo.GetFollowerIDs(ctx, tx, authorID)
+	if err != nil {
+		return err
+	}
+	if threshold := config.Setting.Feed.CelebrityThreshold; threshold > 0 && len(followerIDs) >= threshold {
+		return s.rdb.SAdd(ctx, feedCelebrityKey, authorID).Err()
+	}
+	posts, err := s.postRepo.FindRecentPostIDsByAuthor(ctx, tx, authorID, FeedPushLimit)
+	if err != nil || len(posts) == 0 {
+		return err
+	}
+	members := make([]*redis.Z, 0, len(posts))
+	for _, p := range posts {
+		members = append(members, &redis.Z{Score: float64(p.CreatedAt.Unix()), Member: p.ID})
+	}
+	return s.pushToInboxes(ctx, followerIDs, members)
+}
+
+// 关注的人中哪些是大V
+func (s *FeedService) celebritiesAmong(ctx context.Context, followeeIDs []uint) ([]uint, error) {
+	members, err := s.rdb.SMembers(ctx, feedCelebrityKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	celebrities := make(map[uint]bool, len(members))
+	for _, m := range members {
+		id, err := strconv.ParseUint(m, 10, 64)
+		if err == nil {
+			celebrities[uint(id)] = true
+		}
+	}
+	var result []uint
+	for _, id := range followeeIDs {
+		if celebrities[id] {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+// 时间线 = 推送的收件箱 + 关注的大V发件箱，多路归并后按游标分页
+func (s *FeedService) GetFeed(ctx context.Context, tx *gorm.DB, userID uint, cursor string, pageSize int) (*CursorPage, error) {
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	followeeIDs, err := s.relationRepo.GetFolloweeIDs(ctx, tx, userID)
 	if err != nil {
 		return nil, e.ErrServer
 	}
 	if len(followeeIDs) == 0 {
-		return []model.Post{}, nil
+		return &CursorPage{List: []model.Post{}}, nil
+	}
+	key := inboxKey(userID)
+	exists, err := s.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		log.Printf("redis error:%v", err)
+	}
+	celebrities, cerr := s.celebritiesAmong(ctx, followeeIDs)
+	// 收件箱不存在(过期或redis异常)时直接从数据库拉取全部关注者
+	if err != nil || cerr != nil || exists == 0 {
+		posts, err := s.feedRepo.GetFeedByUserIDs(ctx, tx, followeeIDs, c.Score, c.ID, pageSize+1)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		return newCursorPage(posts, pageSize, postCursorKey), nil
+	}
+	keys := []string{key}
+	for _, cid := range celebrities {
+		keys = append(keys, outboxKey(cid))
+	}
+	page := &CursorPage{}
+	result := make([]model.Post, 0, pageSize)
+	cur := c
+	// 已删除、已下架的文章会被过滤掉，页不满时从上次的位置接着取，最多补取几轮
+	for round := 0; round < feedMaxFetchRounds && len(result) < pageSize; round++ {
+		want := pageSize - len(result)
+		merged, err := s.mergeAfterCursor(ctx, tx, keys, celebrities, cur, want+1)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		page.HasMore = len(merged) > want
+		if page.HasMore {
+			merged = merged[:want]
+		}
+		if len(merged) == 0 {
+			break
+		}
+		postIDs := make([]string, 0, len(merged))
+		for _, z := range merged {
+			postIDs = append(postIDs, fmt.Sprint(z.Member))
+		}
+		posts, err := s.postRepo.FindPostsByIDs(ctx, tx, postIDs)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		result = append(result, sortPostsByIDs(posts, postIDs)...)
+		s.removeStale(ctx, key, postIDs, posts)
+		last := merged[len(merged)-1]
+		cur = Cursor{Score: int64(last.Score), ID: uint(memberID(last))}
+		if !page.HasMore {
+			break
+		}
+	}
+	// redis里的数据翻完后从数据库接着游标往下取：收件箱被裁到InboxMaxLen、重建时只取了最近的、
+	// 或清理失效条目后变短，更早的内容都只在数据库里；只在翻到底时多查这一次
+	if !page.HasMore && len(result) < pageSize {
+		want := pageSize - len(result)
+		posts, err := s.feedRepo.GetFeedByUserIDs(ctx, tx, followeeIDs, cur.Score, cur.ID, want+1)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		page.HasMore = len(posts) > want
+		if page.HasMore {
+			posts = posts[:want]
+		}
+		if len(posts) > 0 {
+			result = append(result, posts...)
+			cur.Score, cur.ID = postCursorKey(posts[len(posts)-1])
+		}
+	}
+	page.List = result
+	if page.HasMore {
+		page.NextCursor = EncodeCursor(cur.Score, cur.ID)
+	}
+	return page, nil
+}
+
+// 从收件箱和大V发件箱各取游标之后的数据并归并
+func (s *FeedService) mergeAfterCursor(ctx context.Context, tx *gorm.DB, keys []string, celebrities []uint, c Cursor, limit int) ([]redis.Z, error) {
+	sources := make([][]redis.Z, 0, len(keys))
+	for i, k := range keys {
+		items, err := s.fetchAfterCursor(ctx, k, c, int64(limit))
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 && i > 0 && c.ID == 0 {
+			if err := s.rebuildOutbox(ctx, tx, celebrities[i-1]); err != nil {
+				log.Printf("failed to rebuild outbox:%v", err)
+			}
+			items, _ = s.fetchAfterCursor(ctx, k, c, int64(limit))
+		}
+		sources = append(sources, items)
+	}
+	return mergeFeedSources(sources, limit), nil
+}
+
+// 数据库里已经查不到的文章顺手从收件箱删掉，下次不用再过滤
+func (s *FeedService) removeStale(ctx context.Context, key string, postIDs []string, posts []model.Post) {
+	if len(posts) == len(postIDs) {
+		return
+	}
+	found := make(map[string]bool, len(posts))
+	for _, p := range posts {
+		found[strconv.FormatUint(uint64(p.ID), 10)] = true
+	}
+	var stale []interface{}
+	for _, id := range postIDs {
+		if !found[id] {
+			stale = append(stale, id)
+		}
+	}
+	if err := s.rdb.ZRem(ctx, key, stale...).Err(); err != nil {
+		log.Printf("failed to remove stale feed items:%v", err)
+	}
+}
+
+// 用ZREVRANGEBYSCORE取游标之后的count条，与游标同分的只保留id更小的
+// 同分成员redis按字典序返回，这里再按(分数,id)重新排序，保证和归并的顺序一致
+func (s *FeedService) fetchAfterCursor(ctx context.Context, key string, c Cursor, count int64) ([]redis.Z, error) {
+	max := "+inf"
+	if c.ID > 0 {
+		max = strconv.FormatInt(c.Score, 10)
+		ties, err := s.rdb.ZCount(ctx, key, max, max).Result()
+		if err != nil {
+			return nil, err
+		}
+		count += ties
+	}
+	items, err := s.rdb.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: max, Count: count}).Result()
+	if err != nil {
+		return nil, err
+	}
+	filtered := items[:0]
+	for _, z := range items {
+		if c.ID > 0 && int64(z.Score) == c.Score && memberID(z) >= uint64(c.ID) {
+			continue
+		}
+		filtered = append(filtered, z)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return feedBefore(filtered[i], filtered[j])
+	})
+	return filtered, nil
+}
+
+// 归并用的堆(按时间倒序)，每个元素是一路已按时间倒序的来源
+type feedSource struct {
+	items []redis.Z
+	pos   int
+}
+type feedHeap []*feedSource
+
+func (h feedHeap) Len() int { return len(h) }
+func (h feedHeap) Less(i, j int) bool {
+	return feedBefore(h[i].items[h[i].pos], h[j].items[h[j].pos])
+}
+func (h feedHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *feedHeap) Push(x interface{}) { *h = append(*h, x.(*feedSource)) }
+func (h *feedHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// 时间新的在前，同一秒内按id倒序，保证各来源合并后顺序稳定
+func feedBefore(a, b redis.Z) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return memberID(a) > memberID(b)
+}
+func memberID(z redis.Z) uint64 {
+	id, _ := strconv.ParseUint(fmt.Sprint(z.Member), 10, 64)
+	return id
+}
+
+// k路归并，去重后返回前limit条
+func mergeFeedSources(sources [][]redis.Z, limit int) []redis.Z {
+	h := make(feedHeap, 0, len(sources))
+	for _, items := range sources {
+		if len(items) > 0 {
+			h = append(h, &feedSource{items: items})
+		}
+	}
+	heap.Init(&h)
+	seen := make(map[uint64]bool)
+	result := make([]redis.Z, 0, limit)
+	for h.Len() > 0 && len(result) < limit {
+		src := h[0]
+		z := src.items[src.pos]
+		src.pos++
+		if src.pos < len(src.items) {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+		// 大V刚升级时，旧文章可能同时在收件箱和发件箱里
+		id := memberID(z)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, z)
+	}
+	return result
+}
+
+// 按redis中的id顺序重排数据库查出的文章，已删除的文章直接跳过
+func sortPostsByIDs(posts []model.Post, postIDs []string) []model.Post {
+	postMap := make(map[uint]model.Post)
+	for _, p := range posts {
+		postMap[p.ID] = p
+	}
+	sortedPosts := make([]model.Post, 0, len(postIDs))
+	for _, idStr := range postIDs {
+		id, _ := strconv.ParseUint(idStr, 10, 64)
+		if p, ok := postMap[uint(id)]; ok {
+			sortedPosts = append(sortedPosts, p)
+		}
 	}
-	offset := (page - 1) * pageSize
-	return s.feedRepo.GetFeedByUserIDs(ct, tx, followeeIDs, offset, pageSize)
+	return sortedPosts
 }
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/pkg/e"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// redis被清空或收件箱过期后，时间线会一直走数据库慢路径，这里提供从relations和posts重建、以及抽样校验的工具
+
+const (
+	feedRebuildLockKey   = "feed:rebuild:lock"
+	feedRebuildStatusKey = "feed:rebuild:status"
+	// 每批都会续期，实例挂掉后锁在这个时间内自动释放
+	feedRebuildLockTTL = 10 * time.Minute
+	// 校验报告里最多列出的用户明细
+	feedVerifyMaxDetails = 20
+)
+
+// 全量重建的进度，存在redis里供管理接口查询
+type FeedRebuildStatus struct {
+	Running    bool   `json:"running"`
+	Processed  int    `json:"processed"`
+	Failed     int    `json:"failed"`
+	LastUserID uint   `json:"last_user_id"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 单个用户的偏差
+type FeedDrift struct {
+	UserID     uint `json:"user_id"`
+	MissingKey bool `json:"missing_key"`
+	Missing    int  `json:"missing"` // 数据库里有、收件箱里没有
+	Stale      int  `json:"stale"`   // 收件箱里有、数据库里没有(已删除/下架/取关/封禁)
+}
+
+// 抽样校验报告
+type FeedVerifyReport struct {
+	Sampled      int         `json:"sampled"`
+	MissingKeys  int         `json:"missing_keys"`
+	Drifted      int         `json:"drifted"`
+	DriftRate    float64     `json:"drift_rate"`
+	MissingPosts int         `json:"missing_posts"`
+	StalePosts   int         `json:"stale_posts"`
+	Repaired     int         `json:"repaired"`
+	Details      []FeedDrift `json:"details"`
+}
+
+// 收件箱里应有的内容：非大V关注对象最近的文章(大V的文章读取时从发件箱拉)
+func (s *FeedService) expectedInbox(ctx context.Context, tx *gorm.DB, userID uint, limit int) ([]model.Post, error) {
+	followeeIDs, err := s.relationRepo.GetFolloweeIDs(ctx, tx, userID)
+	if err != nil || len(followeeIDs) == 0 {
+		return nil, err
+	}
+	celebrities, err := s.celebritiesAmong(ctx, followeeIDs)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[uint]bool, len(celebrities))
+	for _, id := range celebrities {
+		skip[id] = true
+	}
+	authors := make([]uint, 0, len(followeeIDs))
+	for _, id := range followeeIDs {
+		if !skip[id] {
+			authors = append(authors, id)
+		}
+	}
+	return s.feedRepo.GetFeedByUserIDs(ctx, tx, authors, 0, 0, limit)
+}
+
+// 从数据库重建单个用户的收件箱，先写临时key再rename，读请求不会看到半成品
+func (s *FeedService) RebuildInbox(ctx context.Context, tx *gorm.DB, userID uint) error {
+	limit := config.Setting.Feed.InboxMaxLen
+	if limit <= 0 {
+		limit = FeedPushLimit
+	}
+	posts, err := s.expectedInbox(ctx, tx, userID, limit)
+	if err != nil {
+		return err
+	}
+	key := inboxKey(userID)
+	if len(posts) == 0 {
+		return s.rdb.Del(ctx, key).Err()
+	}
+	members := make([]*redis.Z, 0, len(posts))
+	for _, p := range posts {
+		members = append(members, &redis.Z{Score: float64(p.CreatedAt.Unix()), Member: p.ID})
+	}
+	tmpKey := key + ":rebuild"
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, tmpKey)
+	pipe.ZAdd(ctx, tmpKey, members...)
+	pipe.Rename(ctx, tmpKey, key)
+	pipe.Expire(ctx, key, feedKeyTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 按粉丝数重算大V集合，redis被清空后集合也会丢
+func (s *FeedService) RebuildCelebrities(ctx context.Context, tx *gorm.DB) error {
+	threshold := config.Setting.Feed.CelebrityThreshold
+	if threshold <= 0 {
+		return s.rdb.Del(ctx, feedCelebrityKey).Err()
+	}
+	ids, err := s.relationRepo.FolloweeIDsWithMinFollowers(ctx, tx, threshold)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return s.rdb.Del(ctx, feedCelebrityKey).Err()
+	}
+	members := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, id)
+	}
+	tmpKey := feedCelebrityKey + ":rebuild"
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, tmpKey)
+	pipe.SAdd(ctx, tmpKey, members...)
+	pipe.Rename(ctx, tmpKey, feedCelebrityKey)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 全量重建：先重算大V集合，再按用户id分批重建收件箱，每批之间暂停，避免压垮数据库
+// 同一时间只允许一个实例执行，进度写入redis
+func (s *FeedService) RebuildAll(ctx context.Context, tx *gorm.DB) (*FeedRebuildStatus, error) {
+	lock, err := acquireLock(ctx, s.rdb, feedRebuildLockKey, feedRebuildLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, e.ErrFeedRebuildRunning
+	}
+	return s.rebuildAll(ctx, tx, lock)
+}
+
+// 持有锁之后的重建过程，每批续期一次，锁丢了就停下，结束时只释放自己的锁
+func (s *FeedService) rebuildAll(ctx context.Context, tx *gorm.DB, lock *redisLock) (*FeedRebuildStatus, error) {
+	defer lock.Release(ctx)
+
+	cfg := config.Setting.Feed
+	batch := cfg.RebuildBatchSize
+	if batch <= 0 {
+		batch = 100
+	}
+	pause := time.Duration(cfg.RebuildPauseMs) * time.Millisecond
+	status := &FeedRebuildStatus{Running: true, StartedAt: time.Now().Format(time.RFC3339)}
+	s.saveRebuildStatus(ctx, status)
+	finish := func(err error) (*FeedRebuildStatus, error) {
+		status.Running = false
+		status.FinishedAt = time.Now().Format(time.RFC3339)
+		if err != nil {
+			status.Error = err.Error()
+		}
+		s.saveRebuildStatus(context.WithoutCancel(ctx), status)
+		return status, err
+	}
+
+	if err := s.RebuildCelebrities(ctx, tx); err != nil {
+		return finish(err)
+	}
+	var afterID uint
+	for {
+		userIDs, err := s.relationRepo.ListFollowerIDsAfter(ctx, tx, afterID, batch)
+		if err != nil {
+			return finish(err)
+		}
+		if len(userIDs) == 0 {
+			break
+		}
+		for _, uid := range userIDs {
+			if err := s.RebuildInbox(ctx, tx, uid); err != nil {
+				status.Failed++
+				log.Printf("rebuild feed for %d failed:%v", uid, err)
+			}
+			status.Processed++
+		}
+		afterID = userIDs[len(userIDs)-1]
+		status.LastUserID = afterID
+		s.saveRebuildStatus(ctx, status)
+		held, err := lock.Refresh(ctx)
+		if err != nil {
+			return finish(err)
+		}
+		if !held {
+			return finish(e.ErrFeedRebuildRunning)
+		}
+		select {
+		case <-ctx.Done():
+			return finish(ctx.Err())
+		case <-time.After(pause):
+		}
+	}
+	return finish(nil)
+}
+
+func (s *FeedService) saveRebuildStatus(ctx context.Context, status *FeedRebuildStatus) {
+	err := s.rdb.HSet(ctx, feedRebuildStatusKey, map[string]interface{}{
+		"running":      status.Running,
+		"processed":    status.Processed,
+		"failed":       status.Failed,
+		"last_user_id": status.LastUserID,
+		"started_at":   status.StartedAt,
+		"finished_at":  status.FinishedAt,
+		"error":        status.Error,
+	}).Err()
+	if err != nil {
+		log.Printf("save feed rebuild status failed:%v", err)
+	}
+}
+
+// 查询最近一次全量重建的进度
+func (s *FeedService) GetRebuildStatus(ctx context.Context) (*FeedRebuildStatus, error) {
+	values, err := s.rdb.HGetAll(ctx, feedRebuildStatusKey).Result()
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	status := &FeedRebuildStatus{
+		Running:    values["running"] == "1",
+		StartedAt:  values["started_at"],
+		FinishedAt: values["finished_at"],
+		Error:      values["error"],
+	}
+	status.Processed, _ = strconv.Atoi(values["processed"])
+	status.Failed, _ = strconv.Atoi(values["failed"])
+	lastID, _ := strconv.ParseUint(values["last_user_id"], 10, 64)
+	status.LastUserID = uint(lastID)
+	return status, nil
+}
+
+// 抽样比对收件箱和数据库，统计偏差；repair为true时顺手重建有偏差的用户
+func (s *FeedService) Verify(ctx context.Context, tx *gorm.DB, sampleSize int, repair bool) (*FeedVerifyReport, error) {
+	cfg := config.Setting.Feed
+	if sampleSize <= 0 {
+		sampleSize = cfg.VerifySampleSize
+	}
+	depth := cfg.VerifyDepth
+	if depth <= 0 {
+		depth = 50
+	}
+	userIDs, err := s.relationRepo.SampleFollowerIDs(ctx, tx, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	report := &FeedVerifyReport{Sampled: len(userIDs), Details: []FeedDrift{}}
+	for _, uid := range userIDs {
+		drift, err := s.verifyUser(ctx, tx, uid, depth)
+		if err != nil {
+			return nil, err
+		}
+		if drift.MissingKey {
+			report.MissingKeys++
+		}
+		if !drift.MissingKey && drift.Missing == 0 && drift.Stale == 0 {
+			continue
+		}
+		report.Drifted++
+		report.MissingPosts += drift.Missing
+		report.StalePosts += drift.Stale
+		if len(report.Details) < feedVerifyMaxDetails {
+			report.Details = append(report.Details, drift)
+		}
+		if repair {
+			if err := s.RebuildInbox(ctx, tx, uid); err != nil {
+				log.Printf("repair feed for %d failed:%v", uid, err)
+				continue
+			}
+			report.Repaired++
+		}
+	}
+	if report.Sampled > 0 {
+		report.DriftRate = float64(report.Drifted) / float64(report.Sampled)
+	}
+	return report, nil
+}
+
+// 比较收件箱最新的depth条和数据库里应有的最新depth条
+func (s *FeedService) verifyUser(ctx context.Context, tx *gorm.DB, userID uint, depth int) (FeedDrift, error) {
+	drift := FeedDrift{UserID: userID}
+	expected, err := s.expectedInbox(ctx, tx, userID, depth)
+	if err != nil {
+		return drift, err
+	}
+	key := inboxKey(userID)
+	actual, err := s.rdb.ZRevRange(ctx, key, 0, int64(depth-1)).Result()
+	if err != nil {
+		return drift, err
+	}
+	if len(actual) == 0 {
+		// 没有可推送内容的用户本来就没有收件箱
+		drift.MissingKey = len(expected) > 0
+		drift.Missing = len(expected)
+		return drift, nil
+	}
+	want := make(map[string]bool, len(expected))
+	for _, p := range expected {
+		want[fmt.Sprint(p.ID)] = true
+	}
+	have := make(map[string]bool, len(actual))
+	for _, id := range actual {
+		have[id] = true
+		if !want[id] {
+			drift.Stale++
+		}
+	}
+	for id := range want {
+		if !have[id] {
+			drift.Missing++
+		}
+	}
+	return drift, nil
+}
+
+// 后台启动全量重建，锁在返回前拿到，已有实例在重建时直接返回错误
+func (s *FeedService) StartRebuildAll(ctx context.Context) error {
+	lock, err := acquireLock(ctx, s.rdb, feedRebuildLockKey, feedRebuildLockTTL)
+	if err != nil {
+		return e.ErrServer
+	}
+	if lock == nil {
+		return e.ErrFeedRebuildRunning
+	}
+	go func() {
+		if _, err := s.rebuildAll(context.WithoutCancel(ctx), nil, lock); err != nil {
+			log.Printf("rebuild all feeds failed:%v", err)
+		}
+	}()
+	return nil
+}
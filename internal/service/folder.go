@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+type FolderService struct {
+	repo     *repository.FolderRepository
+	connRepo *repository.ConnectRepository
+	postRepo *repository.PostRepository
+	rank     *RankService
+	db       *gorm.DB
+}
+
+func NewFolderService(repo *repository.FolderRepository, conn *repository.ConnectRepository, post *repository.PostRepository, rank *RankService, db *gorm.DB) *FolderService {
+	return &FolderService{repo: repo, connRepo: conn, postRepo: post, rank: rank, db: db}
+}
+
+// 查找收藏夹并校验归属
+func (s *FolderService) findOwnedFolder(ctx context.Context, tx *gorm.DB, userID, folderID uint) (*model.Folder, error) {
+	folder, err := s.repo.FindFolderByID(ctx, tx, folderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, e.ErrFolderNotFound
+		}
+		return nil, e.ErrServer
+	}
+	if folder.UserID != userID {
+		return nil, e.ErrPermission
+	}
+	return folder, nil
+}
+
+// 查找收藏夹并校验可见性：私密收藏夹只有本人能看
+func (s *FolderService) findVisibleFolder(ctx context.Context, tx *gorm.DB, viewerID, folderID uint) (*model.Folder, error) {
+	folder, err := s.repo.FindFolderByID(ctx, tx, folderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, e.ErrFolderNotFound
+		}
+		return nil, e.ErrServer
+	}
+	if !folder.IsPublic && folder.UserID != viewerID {
+		return nil, e.ErrFolderNotFound
+	}
+	return folder, nil
+}
+
+func (s *FolderService) CreateFolder(ctx context.Context, tx *gorm.DB, userID uint, name, description string, isPublic bool) (*model.Folder, error) {
+	if utf8.RuneCountInString(name) == 0 || utf8.RuneCountInString(name) > 64 {
+		return nil, e.ErrInvalidArgs
+	}
+	if utf8.RuneCountInString(description) > 255 {
+		return nil, e.ErrInvalidArgs
+	}
+	folder := &model.Folder{
+		UserID:      userID,
+		Name:        name,
+		Description: description,
+		IsPublic:    isPublic,
+	}
+	if err := s.repo.CreateFolder(ctx, tx, folder); err != nil {
+		return nil, e.ErrServer
+	}
+	return folder, nil
+}
+
+// 修改收藏夹名称、描述或公开状态，空字段和nil表示不修改
+func (s *FolderService) UpdateFolder(ctx context.Context, tx *gorm.DB, userID, folderID uint, name, description string, isPublic *bool) error {
+	if _, err := s.findOwnedFolder(ctx, tx, userID, folderID); err != nil {
+		return err
+	}
+	updates := map[string]interface{}{}
+	if name != "" {
+		if utf8.RuneCountInString(name) > 64 {
+			return e.ErrInvalidArgs
+		}
+		updates["name"] = name
+	}
+	if description != "" {
+		if utf8.RuneCountInString(description) > 255 {
+			return e.ErrInvalidArgs
+		}
+		updates["description"] = description
+	}
+	if isPublic != nil {
+		updates["is_public"] = *isPublic
+	}
+	if err := s.repo.UpdateFolder(ctx, tx, folderID, updates); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 删除收藏夹及其中的收藏，默认收藏夹不能删
+func (s *FolderService) DeleteFolder(ctx context.Context, tx *gorm.DB, userID, folderID uint) error {
+	folder, err := s.findOwnedFolder(ctx, tx, userID, folderID)
+	if err != nil {
+		return err
+	}
+	if folder.IsDefault {
+		return e.ErrDefaultFolder
+	}
+	err = s.db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		if err := s.connRepo.RemoveByFolder(ctx, txFn, folderID); err != nil {
+			return err
+		}
+		if err := s.repo.RemoveFollowsByFolder(ctx, txFn, folderID); err != nil {
+			return err
+		}
+		return s.repo.DeleteFolder(ctx, txFn, folderID)
+	})
+	if err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 收藏夹列表：看自己的返回全部，看别人的只返回公开的
+func (s *FolderService) ListFolders(ctx context.Context, tx *gorm.DB, ownerID, viewerID uint) ([]model.Folder, error) {
+	if ownerID == viewerID {
+		if _, err := s.repo.GetOrCreateDefault(ctx, tx, ownerID); err != nil {
+			return nil, e.ErrServer
+		}
+	}
+	folders, err := s.repo.ListByUser(ctx, tx, ownerID, ownerID != viewerID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return folders, nil
+}
+
+func (s *FolderService) GetFolderPosts(ctx context.Context, tx *gorm.DB, viewerID, folderID uint, page, pageSize int) ([]model.Post, error) {
+	if _, err := s.findVisibleFolder(ctx, tx, viewerID, folderID); err != nil {
+		return nil, err
+	}
+	offset := (page - 1) * pageSize
+	posts, err := s.connRepo.GetPostsByFolder(ctx, tx, folderID, offset, pageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return posts, nil
+}
+
+// 收藏到指定收藏夹
+func (s *FolderService) AddToFolder(ctx context.Context, tx *gorm.DB, userID, folderID, postID uint) error {
+	if _, err := s.findOwnedFolder(ctx, tx, userID, folderID); err != nil {
+		return err
+	}
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
+	if err != nil {
+		return e.ErrPostNotFound
+	}
+	var firstSave bool
+	err = s.db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		existing, err := s.connRepo.GetFolderIDsByPost(ctx, txFn, userID, postID)
+		if err != nil {
+			return err
+		}
+		added, err := s.connRepo.AddConnection(ctx, txFn, userID, folderID, postID)
+		if err != nil || !added {
+			return err
+		}
+		firstSave = len(existing) == 0
+		return s.repo.IncrPostCount(ctx, txFn, []uint{folderID}, 1)
+	})
+	if err != nil {
+		return e.ErrServer
+	}
+	if firstSave {
+		s.rank.RecordInteraction(ctx, tx, postID, post.Type, config.Setting.Rank.BookmarkWeight)
+	}
+	return nil
+}
+
+// 从指定收藏夹移除
+func (s *FolderService) RemoveFromFolder(ctx context.Context, tx *gorm.DB, userID, folderID, postID uint) error {
+	if _, err := s.findOwnedFolder(ctx, tx, userID, folderID); err != nil {
+		return err
+	}
+	var lastRemoved bool
+	err := s.db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		removed, err := s.connRepo.RemoveFromFolder(ctx, txFn, folderID, postID)
+		if err != nil || !removed {
+			return err
+		}
+		remaining, err := s.connRepo.GetFolderIDsByPost(ctx, txFn, userID, postID)
+		if err != nil {
+			return err
+		}
+		lastRemoved = len(remaining) == 0
+		return s.repo.IncrPostCount(ctx, txFn, []uint{folderID}, -1)
+	})
+	if err != nil {
+		return e.ErrServer
+	}
+	if lastRemoved {
+		if post, err := s.postRepo.FindPostByID(ctx, tx, postID); err == nil {
+			s.rank.RecordInteraction(ctx, tx, postID, post.Type, -config.Setting.Rank.BookmarkWeight)
+		}
+	}
+	return nil
+}
+
+// 把文章从一个收藏夹复制或移动到另一个收藏夹，move为true时从原收藏夹删除
+func (s *FolderService) TransferPosts(ctx context.Context, tx *gorm.DB, userID, fromID, toID uint, postIDs []uint, move bool) error {
+	if fromID == toID || len(postIDs) == 0 {
+		return e.ErrInvalidArgs
+	}
+	if _, err := s.findOwnedFolder(ctx, tx, userID, fromID); err != nil {
+		return err
+	}
+	if _, err := s.findOwnedFolder(ctx, tx, userID, toID); err != nil {
+		return err
+	}
+	err := s.db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		var added, removed int64
+		for _, postID := range postIDs {
+			// 只处理确实在原收藏夹里的文章
+			folderIDs, err := s.connRepo.GetFolderIDsByPost(ctx, txFn, userID, postID)
+			if err != nil {
+				return err
+			}
+			if !containsUint(folderIDs, fromID) {
+				continue
+			}
+			ok, err := s.connRepo.AddConnection(ctx, txFn, userID, toID, postID)
+			if err != nil {
+				return err
+			}
+			if ok {
+				added++
+			}
+			if move {
+				if _, err := s.connRepo.RemoveFromFolder(ctx, txFn, fromID, postID); err != nil {
+					return err
+				}
+				removed++
+			}
+		}
+		if err := s.repo.IncrPostCount(ctx, txFn, []uint{toID}, added); err != nil {
+			return err
+		}
+		return s.repo.IncrPostCount(ctx, txFn, []uint{fromID}, -removed)
+	})
+	if err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 关注别人的公开收藏夹
+func (s *FolderService) FollowFolder(ctx context.Context, tx *gorm.DB, userID, folderID uint) error {
+	folder, err := s.findVisibleFolder(ctx, tx, userID, folderID)
+	if err != nil {
+		return err
+	}
+	if folder.UserID == userID {
+		return e.ErrSelfAction
+	}
+	err = s.db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		_, err := s.repo.Follow(ctx, txFn, userID, folderID)
+		return err
+	})
+	if err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+func (s *FolderService) UnfollowFolder(ctx context.Context, tx *gorm.DB, userID, folderID uint) error {
+	err := s.db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		return s.repo.Unfollow(ctx, txFn, userID, folderID)
+	})
+	if err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+func (s *FolderService) GetFollowedFolders(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]model.Folder, error) {
+	offset := (page - 1) * pageSize
+	folders, err := s.repo.ListFollowed(ctx, tx, userID, offset, pageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return folders, nil
+}
+
+func containsUint(list []uint, target uint) bool {
+	for _, v := range list {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
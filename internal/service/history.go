@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+type HistoryService struct {
+	repo     *repository.HistoryRepository
+	userRepo *repository.UserRepository
+	postRepo *repository.PostRepository
+}
+
+func NewHistoryService(repo *repository.HistoryRepository, user *repository.UserRepository, post *repository.PostRepository) *HistoryService {
+	return &HistoryService{repo: repo, userRepo: user, postRepo: post}
+}
+
+// 用户是否开启了阅读记录，查询失败时按关闭处理，宁可少记也不违背用户设置
+func (s *HistoryService) trackingEnabled(ctx context.Context, tx *gorm.DB, userID uint) bool {
+	disabled, err := s.userRepo.IsHistoryDisabled(ctx, tx, userID)
+	if err != nil {
+		log.Printf("failed to load history setting:%v", err)
+		return false
+	}
+	return !disabled
+}
+
+// 打开文章详情时记录阅读，失败只打日志不影响详情返回
+func (s *HistoryService) RecordRead(ctx context.Context, tx *gorm.DB, userID, postID uint) {
+	if userID == 0 || !s.trackingEnabled(ctx, tx, userID) {
+		return
+	}
+	if err := s.repo.RecordRead(ctx, tx, userID, postID, time.Now()); err != nil {
+		log.Printf("failed to record read history:%v", err)
+	}
+}
+
+// 客户端上报阅读进度(0-100)
+func (s *HistoryService) ReportProgress(ctx context.Context, tx *gorm.DB, userID, postID uint, progress int) error {
+	if progress < 0 || progress > 100 {
+		return e.ErrInvalidArgs
+	}
+	if !s.trackingEnabled(ctx, tx, userID) {
+		return nil
+	}
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
+	if err != nil || post.Status != model.PostStatusPublished {
+		return e.ErrPostNotFound
+	}
+	if err := s.repo.UpdateProgress(ctx, tx, userID, postID, progress, time.Now()); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 阅读历史，keyword按标题搜索
+func (s *HistoryService) GetHistory(ctx context.Context, tx *gorm.DB, userID uint, keyword string, page, pageSize int) ([]model.ReadHistory, error) {
+	if utf8.RuneCountInString(keyword) > 64 {
+		return nil, e.ErrInvalidArgs
+	}
+	offset := (page - 1) * pageSize
+	histories, err := s.repo.ListHistory(ctx, tx, userID, keyword, false, offset, pageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return histories, nil
+}
+
+// 继续阅读：读了一部分还没读完的文章
+func (s *HistoryService) GetContinueReading(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]model.ReadHistory, error) {
+	offset := (page - 1) * pageSize
+	histories, err := s.repo.ListHistory(ctx, tx, userID, "", true, offset, pageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return histories, nil
+}
+
+func (s *HistoryService) DeleteHistory(ctx context.Context, tx *gorm.DB, userID, postID uint) error {
+	if err := s.repo.DeleteHistory(ctx, tx, userID, postID); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+func (s *HistoryService) ClearHistory(ctx context.Context, tx *gorm.DB, userID uint) error {
+	if err := s.repo.ClearHistory(ctx, tx, userID); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 开关阅读记录，关闭时顺便清空已有记录
+func (s *HistoryService) SetTracking(ctx context.Context, tx *gorm.DB, userID uint, enabled bool) error {
+	if err := s.userRepo.SetHistoryDisabled(ctx, tx, userID, !enabled); err != nil {
+		return e.ErrServer
+	}
+	if !enabled {
+		return s.ClearHistory(ctx, tx, userID)
+	}
+	return nil
+}
+
+// 后台定时裁剪超出上限的阅读记录
+func (s *HistoryService) StartHistoryPruneWorker(ctx context.Context) {
+	interval := time.Duration(config.Setting.History.PruneMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if max := config.Setting.History.MaxPerUser; max > 0 {
+			if n, err := s.repo.PruneHistory(ctx, nil, max); err != nil {
+				log.Printf("prune read history failed:%v", err)
+			} else if n > 0 {
+				log.Printf("pruned %d read history records", n)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
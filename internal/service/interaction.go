@@ -3,34 +3,69 @@ package service
 import (
 	"context"
 	"errors"
+	"go-zhihu/config"
 	"go-zhihu/internal/model"
 	"go-zhihu/internal/repository"
 	"go-zhihu/pkg/e"
-	"log"
 
 	"gorm.io/gorm"
 )
 
 type InteractionService struct {
-	likeRepo    *repository.LikeRepository
-	commentRepo *repository.CommentRepository
-	postRepo    *repository.PostRepository
-	connRepo    *repository.ConnectRepository
-	notify      *NotificationService
-	db          *gorm.DB
+	likeRepo     *repository.LikeRepository
+	commentRepo  *repository.CommentRepository
+	postRepo     *repository.PostRepository
+	connRepo     *repository.ConnectRepository
+	folderRepo   *repository.FolderRepository
+	questionRepo *repository.QuestionFollowRepository
+	notify       *NotificationService
+	rank         *RankService
+	activity     *ActivityService
+	block        *BlockService
+	privacy      *PrivacyService
+	db           *gorm.DB
 }
 
-func NewInteractionService(like *repository.LikeRepository, comment *repository.CommentRepository, post *repository.PostRepository, conn *repository.ConnectRepository, notify *NotificationService, db *gorm.DB) *InteractionService {
-	return &InteractionService{likeRepo: like, commentRepo: comment, postRepo: post, connRepo: conn, notify: notify, db: db}
+func NewInteractionService(like *repository.LikeRepository, comment *repository.CommentRepository, post *repository.PostRepository, conn *repository.ConnectRepository, folder *repository.FolderRepository, question *repository.QuestionFollowRepository, notify *NotificationService, rank *RankService, activity *ActivityService, block *BlockService, privacy *PrivacyService, db *gorm.DB) *InteractionService {
+	return &InteractionService{likeRepo: like, commentRepo: comment, postRepo: post, connRepo: conn, folderRepo: folder, questionRepo: question, notify: notify, rank: rank, activity: activity, block: block, privacy: privacy, db: db}
 }
 
-// 查看评论
-func (s *InteractionService) GetComments(ctx context.Context, tx *gorm.DB, postID uint) ([]model.Comment, error) {
-	_, err := s.postRepo.FindPostByID(ctx, tx, postID)
+// 问题下的顶层评论就是回答
+func (s *InteractionService) isAnswer(ctx context.Context, tx *gorm.DB, comment *model.Comment) bool {
+	if comment.ParentID != 0 {
+		return false
+	}
+	post, err := s.postRepo.FindPostByID(ctx, tx, comment.PostID)
+	return err == nil && post.Type == 2
+}
+
+// 查看评论，有拉黑关系的人的评论不展示，看不到的私密文章也看不到评论
+func (s *InteractionService) GetComments(ctx context.Context, tx *gorm.DB, postID, viewerID uint) ([]model.Comment, error) {
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
 	if err != nil {
 		return nil, e.ErrPostNotFound
 	}
-	return s.commentRepo.GetCommentByPostID(ctx, tx, postID)
+	if err := s.privacy.checkVisible(ctx, tx, viewerID, post.AuthorID); err != nil {
+		return nil, err
+	}
+	comments, err := s.commentRepo.GetCommentByPostID(ctx, tx, postID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	hidden, err := s.block.hiddenUsers(ctx, tx, viewerID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	if len(hidden) == 0 {
+		return comments, nil
+	}
+	visible := make([]model.Comment, 0, len(comments))
+	for _, c := range comments {
+		if !hidden[c.AuthorID] {
+			visible = append(visible, c)
+		}
+	}
+	return visible, nil
 }
 
 func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID uint, targetID uint, targetType int) error {
@@ -39,16 +74,12 @@ func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID
 		return e.ErrInvalidArgs
 	}
 
-	const (
-		likePostScore    = 10.0
-		likeCommentScore = 5.0
-	)
-
 	var (
-		scoreDelta  float64
 		isNewAction bool
 		authorID    uint
-		postID      uint // 用于评论点赞时更新文章热度
+		postID      uint // 评论所在的文章
+		postType    int  // 文章点赞时记录到对应类型的榜单
+		answer      bool // 点赞的评论是不是回答
 	)
 
 	// 使用事务
@@ -68,15 +99,11 @@ func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID
 			}
 
 			if targetType == model.TargetTypePost {
-				// 取消文章点赞
-				scoreDelta = -likePostScore
-				if err := s.postRepo.UpdateHotScore(ctx, txFn, targetID, scoreDelta); err != nil {
-					return err
-				}
-				// 获取作者ID用于通知（可选，取消点赞通常不发通知）
+				// 取消文章点赞，获取作者ID用于通知（可选，取消点赞通常不发通知）
 				post, err := s.postRepo.FindPostByID(ctx, txFn, targetID)
 				if err == nil {
 					authorID = post.AuthorID
+					postType = post.Type
 				}
 			} else if targetType == model.TargetTypeComment {
 				// 取消评论点赞
@@ -84,12 +111,9 @@ func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID
 				if err != nil {
 					return err
 				}
-				scoreDelta = -likeCommentScore
 				postID = comment.PostID
 				authorID = comment.AuthorID
-				if err := s.postRepo.UpdateHotScore(ctx, txFn, postID, scoreDelta); err != nil {
-					return err
-				}
+				answer = s.isAnswer(ctx, txFn, comment)
 			}
 		} else {
 			// ========== 新增点赞逻辑 ==========
@@ -112,8 +136,11 @@ func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID
 					return err
 				}
 				authorID = post.AuthorID
-				scoreDelta = likePostScore
-				if err := s.postRepo.UpdateHotScore(ctx, txFn, targetID, scoreDelta); err != nil {
+				postType = post.Type
+				if err := s.block.checkBlocked(ctx, txFn, userID, authorID); err != nil {
+					return err
+				}
+				if err := s.privacy.checkVisible(ctx, txFn, userID, authorID); err != nil {
 					return err
 				}
 			} else if targetType == model.TargetTypeComment {
@@ -124,10 +151,17 @@ func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID
 				}
 				authorID = comment.AuthorID
 				postID = comment.PostID
-				scoreDelta = likeCommentScore
-				if err := s.postRepo.UpdateHotScore(ctx, txFn, postID, scoreDelta); err != nil {
+				if err := s.block.checkBlocked(ctx, txFn, userID, authorID); err != nil {
 					return err
 				}
+				post, err := s.postRepo.FindPostByID(ctx, txFn, postID)
+				if err != nil {
+					return err
+				}
+				if err := s.privacy.checkVisible(ctx, txFn, userID, post.AuthorID); err != nil {
+					return err
+				}
+				answer = s.isAnswer(ctx, txFn, comment)
 			}
 		}
 
@@ -135,8 +169,33 @@ func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID
 	})
 
 	if err != nil {
+		if errors.Is(err, e.ErrBlocked) || errors.Is(err, e.ErrPrivateContent) {
+			return err
+		}
 		return e.ErrServer
 	}
+	if targetType == model.TargetTypePost && postType != 0 {
+		weight := config.Setting.Rank.LikeWeight
+		if !isNewAction {
+			weight = -weight
+		}
+		s.rank.RecordInteraction(ctx, tx, targetID, postType, weight)
+	}
+
+	// 赞文章、赞同回答记入动态，取消时撤销
+	activityType, activityPost, activityComment := 0, targetID, uint(0)
+	if targetType == model.TargetTypePost && postType == 1 {
+		activityType = model.ActivityLikeArticle
+	} else if answer {
+		activityType, activityPost, activityComment = model.ActivityUpvoteAnswer, postID, targetID
+	}
+	if activityType != 0 {
+		if isNewAction {
+			s.activity.Record(ctx, tx, userID, activityType, activityPost, activityComment)
+		} else {
+			s.activity.Remove(ctx, tx, userID, activityType, activityPost, activityComment)
+		}
+	}
 
 	// 异步发送通知（只在新点赞时发送）
 	if isNewAction && authorID != userID {
@@ -144,11 +203,7 @@ func (s *InteractionService) ToggleLike(ctx context.Context, tx *gorm.DB, userID
 		if targetType == model.TargetTypeComment {
 			content = "赞了你的评论"
 		}
-		// 使用新的 context 避免原 context 超时
-		go func() {
-			bgCtx := context.Background()
-			s.notify.sendNotification(bgCtx, tx, authorID, userID, model.NotifyTypeLike, content, targetID)
-		}()
+		s.notify.sendNotification(ctx, tx, authorID, userID, model.NotifyTypeLike, content, targetType, targetID)
 	}
 
 	return nil
@@ -162,6 +217,12 @@ func (s *InteractionService) AddComment(ctx context.Context, tx *gorm.DB, postID
 	if err != nil {
 		return e.ErrPostNotFound
 	}
+	if err := s.block.checkBlocked(ctx, tx, authorID, post.AuthorID); err != nil {
+		return err
+	}
+	if err := s.privacy.checkVisible(ctx, tx, authorID, post.AuthorID); err != nil {
+		return err
+	}
 	comment := &model.Comment{
 		PostID:   postID,
 		AuthorID: authorID,
@@ -171,36 +232,89 @@ func (s *InteractionService) AddComment(ctx context.Context, tx *gorm.DB, postID
 	if err != nil {
 		return e.ErrServer
 	}
-	const commentScoreDelta = 5.0
-	if err := s.postRepo.UpdateHotScore(ctx, tx, postID, commentScoreDelta); err != nil {
-		// 热度更新失败不影响评论创建，记录日志即可
-		log.Printf("failed to update hot score: %v", err)
+	s.rank.RecordInteraction(ctx, tx, postID, post.Type, config.Setting.Rank.CommentWeight)
+	if post.Type == 2 {
+		s.activity.Record(ctx, tx, authorID, model.ActivityAnswer, postID, comment.ID)
 	}
 
 	// 发送通知（不要通知自己）
 	if post.AuthorID != authorID {
-		s.notify.sendNotification(ctx, tx, post.AuthorID, authorID, model.NotifyTypeComment, "评论了你的文章", postID)
+		s.notify.sendNotification(ctx, tx, post.AuthorID, authorID, model.NotifyTypeComment, "评论了你的文章", model.TargetTypePost, postID)
 	}
 	return nil
 }
 
-// 添加收藏关注列表
+// 添加收藏关注列表：未收藏时放进默认收藏夹，已收藏时从所有收藏夹移除
 func (s *InteractionService) ToggleConn(ctx context.Context, tx *gorm.DB, userID, postID uint) error {
-	_, err := s.postRepo.FindPostByID(ctx, tx, postID)
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
 	if err != nil {
 		return e.ErrPostNotFound
 	}
-	isConn, err := s.connRepo.IsConn(ctx, tx, userID, postID)
+	if err := s.privacy.checkVisible(ctx, tx, userID, post.AuthorID); err != nil {
+		return err
+	}
+	folderIDs, err := s.connRepo.GetFolderIDsByPost(ctx, tx, userID, postID)
 	if err != nil {
 		return e.ErrServer
 	}
-	if isConn {
-		return s.connRepo.RemoveConn(ctx, tx, userID, postID)
+	weight := config.Setting.Rank.BookmarkWeight
+	err = s.db.WithContext(ctx).Transaction(func(txFn *gorm.DB) error {
+		if len(folderIDs) > 0 {
+			weight = -weight
+			if err := s.connRepo.RemoveConn(ctx, txFn, userID, postID); err != nil {
+				return err
+			}
+			return s.folderRepo.IncrPostCount(ctx, txFn, folderIDs, -1)
+		}
+		folder, err := s.folderRepo.GetOrCreateDefault(ctx, txFn, userID)
+		if err != nil {
+			return err
+		}
+		if _, err := s.connRepo.AddConnection(ctx, txFn, userID, folder.ID, postID); err != nil {
+			return err
+		}
+		return s.folderRepo.IncrPostCount(ctx, txFn, []uint{folder.ID}, 1)
+	})
+	if err != nil {
+		return e.ErrServer
 	}
-	return s.connRepo.AddConnection(ctx, tx, userID, postID)
+	s.rank.RecordInteraction(ctx, tx, postID, post.Type, weight)
+	return nil
+}
+
+// 关注问题：有新回答时出现在邮件摘要里，并记一条"关注了问题"的动态(收藏是私人的，不产生动态)
+func (s *InteractionService) FollowQuestion(ctx context.Context, tx *gorm.DB, userID, postID uint) error {
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
+	if err != nil || post.Type != 2 || post.Status != model.PostStatusPublished {
+		return e.ErrPostNotFound
+	}
+	if err := s.block.checkBlocked(ctx, tx, userID, post.AuthorID); err != nil {
+		return err
+	}
+	if err := s.privacy.checkVisible(ctx, tx, userID, post.AuthorID); err != nil {
+		return err
+	}
+	added, err := s.questionRepo.Follow(ctx, tx, userID, postID)
+	if err != nil {
+		return e.ErrServer
+	}
+	if added {
+		s.activity.Record(ctx, tx, userID, model.ActivityFollowQuestion, postID, 0)
+	}
+	return nil
+}
+func (s *InteractionService) UnfollowQuestion(ctx context.Context, tx *gorm.DB, userID, postID uint) error {
+	removed, err := s.questionRepo.Unfollow(ctx, tx, userID, postID)
+	if err != nil {
+		return e.ErrServer
+	}
+	if removed {
+		s.activity.Remove(ctx, tx, userID, model.ActivityFollowQuestion, postID, 0)
+	}
+	return nil
 }
 
-// 获取收藏列表
+// 获取收藏列表(所有收藏夹合并去重)
 func (s *InteractionService) GetConn(ctx context.Context, tx *gorm.DB, userID uint, page, pageSze int) ([]model.Post, error) {
 	offset := (page - 1) * pageSze
 	return s.connRepo.GetConnByUser(ctx, tx, userID, offset, pageSze)
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-zhihu/internal/job"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 后台任务类型
+const (
+	JobFeedDistribute    = "feed.distribute"
+	JobFeedBackfill      = "feed.backfill"
+	JobFeedRemoveAuthor  = "feed.remove_author"
+	JobFeedRemovePost    = "feed.remove_post"
+	JobFeedBanAuthor     = "feed.ban_author"
+	JobFeedRestoreAuthor = "feed.restore_author"
+	JobNotify            = "notify.send"
+	JobSearchIndex       = "search.index"
+	JobSimilarIndex      = "similar.index"
+	JobDigest            = "notify.digest"
+)
+
+type postJob struct {
+	PostID   uint `json:"post_id"`
+	AuthorID uint `json:"author_id"`
+}
+type relationJob struct {
+	FollowerID uint `json:"follower_id"`
+	FolloweeID uint `json:"followee_id"`
+}
+type authorJob struct {
+	AuthorID uint `json:"author_id"`
+}
+type notifyJob struct {
+	RecipientID uint      `json:"recipient_id"`
+	ActorID     uint      `json:"actor_id"`
+	Type        int       `json:"type"`
+	Content     string    `json:"content"`
+	TargetType  int       `json:"target_type"`
+	TargetID    uint      `json:"target_id"`
+	At          time.Time `json:"at"`
+}
+
+// 把带类型参数的处理函数包装成队列的Handler
+func handle[T any](fn func(ctx context.Context, payload T) error) job.Handler {
+	return func(ctx context.Context, raw []byte) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return err
+		}
+		return fn(ctx, payload)
+	}
+}
+
+// 投递后台任务，不跟随请求取消；投递失败只记录日志
+func enqueue(ctx context.Context, q *job.Queue, jobType string, payload interface{}) error {
+	err := q.Enqueue(context.WithoutCancel(ctx), jobType, payload)
+	if err != nil {
+		log.Printf("enqueue %s failed:%v", jobType, err)
+	}
+	return err
+}
+
+// 投递后台任务，投递失败时在当前协程直接执行，避免分发和索引更新悄悄丢失
+func dispatch(ctx context.Context, q *job.Queue, jobType string, payload interface{}) {
+	if err := enqueue(ctx, q, jobType, payload); err == nil {
+		return
+	}
+	if err := q.RunInline(jobType, payload); err != nil {
+		log.Printf("run %s inline failed:%v", jobType, err)
+	}
+}
+
+func (s *Service) registerJobs(postRepo *repository.PostRepository) {
+	s.Jobs.Register(JobFeedDistribute, handle(func(ctx context.Context, p postJob) error {
+		post, err := postRepo.FindPostByID(ctx, nil, p.PostID)
+		if err != nil {
+			// 投递后文章已被删除，无需分发
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if post.Status != model.PostStatusPublished {
+			return nil
+		}
+		return s.Feed.DistributePost(ctx, nil, post)
+	}))
+	s.Jobs.Register(JobFeedBackfill, handle(func(ctx context.Context, p relationJob) error {
+		return s.Feed.PushPostsToFeed(ctx, nil, p.FollowerID, p.FolloweeID)
+	}))
+	s.Jobs.Register(JobFeedRemoveAuthor, handle(func(ctx context.Context, p relationJob) error {
+		return s.Feed.RemoveAuthorFromFeed(ctx, nil, p.FollowerID, p.FolloweeID)
+	}))
+	s.Jobs.Register(JobFeedRemovePost, handle(func(ctx context.Context, p postJob) error {
+		return s.Feed.RemovePostFromFeeds(ctx, nil, p.AuthorID, p.PostID)
+	}))
+	s.Jobs.Register(JobFeedBanAuthor, handle(func(ctx context.Context, p authorJob) error {
+		return s.Feed.RemoveAuthorPostsFromFeeds(ctx, nil, p.AuthorID)
+	}))
+	s.Jobs.Register(JobFeedRestoreAuthor, handle(func(ctx context.Context, p authorJob) error {
+		return s.Feed.RestoreAuthorPosts(ctx, nil, p.AuthorID)
+	}))
+	s.Jobs.Register(JobNotify, handle(func(ctx context.Context, p notifyJob) error {
+		return s.Notification.createNotification(ctx, nil, p)
+	}))
+	s.Jobs.Register(JobSearchIndex, handle(func(ctx context.Context, p postJob) error {
+		return s.Search.IndexPost(ctx, nil, p.PostID)
+	}))
+	s.Jobs.Register(JobSimilarIndex, handle(func(ctx context.Context, p postJob) error {
+		return s.Similar.IndexPost(ctx, nil, p.PostID)
+	}))
+	s.Jobs.Register(JobDigest, handle(func(ctx context.Context, p digestJob) error {
+		return s.Digest.SendDigest(ctx, nil, p.UserID, p.Weekly)
+	}))
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 只有持有者(token相同)才能续期和释放，锁过期后被别的实例拿到时不会误删
+var (
+	lockRefreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	lockReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// 多实例之间的互斥锁，用随机token标识持有者
+type redisLock struct {
+	rdb   *redis.Client
+	key   string
+	token string
+	ttl   time.Duration
+}
+
+// 尝试加锁，已被别人持有时返回nil
+func acquireLock(ctx context.Context, rdb *redis.Client, key string, ttl time.Duration) (*redisLock, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	lock := &redisLock{rdb: rdb, key: key, token: hex.EncodeToString(buf), ttl: ttl}
+	ok, err := rdb.SetNX(ctx, key, lock.token, ttl).Result()
+	if err != nil || !ok {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// 续期，返回false表示锁已经丢了(过期后被别人拿到)
+func (l *redisLock) Refresh(ctx context.Context) (bool, error) {
+	n, err := lockRefreshScript.Run(ctx, l.rdb, []string{l.key}, l.token, l.ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// 释放，不会删掉别人的锁；不跟随请求取消
+func (l *redisLock) Release(ctx context.Context) error {
+	return lockReleaseScript.Run(context.WithoutCancel(ctx), l.rdb, []string{l.key}, l.token).Err()
+}
@@ -11,12 +11,14 @@ import (
 )
 
 type MessageService struct {
-	repo   *repository.MessageRepository
-	notify *NotificationService
+	repo     *repository.MessageRepository
+	notify   *NotificationService
+	block    *BlockService
+	realtime *RealtimeService
 }
 
-func NewMessageService(repo *repository.MessageRepository, notify *NotificationService) *MessageService {
-	return &MessageService{repo: repo, notify: notify}
+func NewMessageService(repo *repository.MessageRepository, notify *NotificationService, block *BlockService, realtime *RealtimeService) *MessageService {
+	return &MessageService{repo: repo, notify: notify, block: block, realtime: realtime}
 }
 
 // 私信通知（但没有系统通知）
@@ -28,15 +30,28 @@ func generateSessionID(uid1, uid2 uint) string {
 }
 
 // 获取聊天记录
-func (s *MessageService) GetChatHistory(ctx context.Context, tx *gorm.DB, userID, peerID uint, page, pageSize int) ([]model.Message, error) {
+// 聊天记录从最新的一页开始向前翻，next_cursor指向更早的消息；每页内按时间正序返回便于展示
+func (s *MessageService) GetChatHistory(ctx context.Context, tx *gorm.DB, userID, peerID uint, cursor string, pageSize int) (*CursorPage, error) {
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
 	sessionID := generateSessionID(userID, peerID)
-	offset := (page - 1) * pageSize
-	messages, err := s.repo.GetMessageBySession(ctx, tx, sessionID, offset, pageSize)
+	messages, err := s.repo.GetMessageBySession(ctx, tx, sessionID, c.Score, c.ID, pageSize+1)
 	if err != nil {
 		return nil, e.ErrServer
 	}
-	_ = s.repo.MarkMessagesAsRead(ctx, tx, sessionID, userID)
-	return messages, nil
+	if n, err := s.repo.MarkMessagesAsRead(ctx, tx, sessionID, userID); err == nil && n > 0 {
+		s.realtime.pushUnread(ctx, tx, userID)
+	}
+	page := newCursorPage(messages, pageSize, func(m model.Message) (int64, uint) {
+		return m.CreatedAt.Unix(), m.ID
+	})
+	list := page.List.([]model.Message)
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return page, nil
 }
 
 // 获取会话列表
@@ -46,25 +61,40 @@ func (s *MessageService) GetConversations(ctx context.Context, tx *gorm.DB, user
 
 // total Unread
 func (s *MessageService) GetTotalUnread(ctx context.Context, tx *gorm.DB, userID uint) (map[string]int64, error) {
-	notifyCount, err := s.notify.repo.GetUnreadCount(ctx, tx, userID)
+	vo, err := totalUnread(ctx, tx, s.notify.repo, s.repo, s.block, userID)
 	if err != nil {
-		notifyCount = 0
+		return nil, e.ErrServer
 	}
-	msgCount, err := s.repo.GetUnreadCountByUser(ctx, tx, userID)
+	return map[string]int64{
+		"notification_unread": vo.UnreadCount,
+		"message_unread":      vo.MessageUnread,
+		"total_unread":        vo.TotalUnread,
+	}, nil
+}
+
+// 通知(按分类)和私信的未读数，接口查询和实时推送共用；查询出错时返回错误，不用0冒充
+func totalUnread(ctx context.Context, tx *gorm.DB, notifyRepo *repository.NotificationRepository, msgRepo *repository.MessageRepository, block *BlockService, userID uint) (*UnreadVO, error) {
+	notify, err := unreadByCategory(ctx, tx, notifyRepo, block, userID)
 	if err != nil {
-		msgCount = 0
+		return nil, err
 	}
-	return map[string]int64{
-		"notification_unread": notifyCount,
-		"message_unread":      msgCount,
-		"total_unread":        notifyCount + msgCount,
+	msgCount, err := msgRepo.GetUnreadCountByUser(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &UnreadVO{
+		NotificationUnreadVO: *notify,
+		MessageUnread:        msgCount,
+		TotalUnread:          notify.UnreadCount + msgCount,
 	}, nil
 }
 func (s *MessageService) SendMessage(ctx context.Context, tx *gorm.DB, senderID, receiverID uint, content string) error {
 	if senderID == receiverID {
 		return e.ErrSelfAction
 	}
-	//可以写好友状态，看是否可以发私信
+	if err := s.block.checkBlocked(ctx, tx, senderID, receiverID); err != nil {
+		return err
+	}
 	sessionID := generateSessionID(senderID, receiverID)
 	msg := &model.Message{
 		SenderID:   senderID,
@@ -76,6 +106,7 @@ func (s *MessageService) SendMessage(ctx context.Context, tx *gorm.DB, senderID,
 	if err := s.repo.CreateMessage(ctx, tx, msg); err != nil {
 		return e.ErrServer
 	}
-	s.notify.sendNotification(ctx, tx, receiverID, senderID, model.NotifyTypeMessage, "给你发来一条私信", 0)
+	s.realtime.messageSent(ctx, tx, msg)
+	s.notify.sendNotification(ctx, tx, receiverID, senderID, model.NotifyTypeMessage, "给你发来一条私信", 0, 0)
 	return nil
 }
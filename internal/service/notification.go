@@ -2,34 +2,183 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/job"
 	"go-zhihu/internal/model"
 	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+const (
+	// 合并通知展示的触发者个数
+	notifyShownActors = 3
+	// 通知里关联对象摘要的长度(字)
+	notifySnippetLen = 60
+)
+
+// 通知中心的分类标签，每类包含的通知类型
+var notifyCategories = []struct {
+	Name  string
+	Types []int
+}{
+	{"likes", []int{model.NotifyTypeLike}},
+	{"comments", []int{model.NotifyTypeComment}},
+	{"follows", []int{model.NotifyTypeFollow, model.NotifyTypeFollowRequest}},
+	{"system", []int{model.NotifyTypeSystem}},
+	{"messages", []int{model.NotifyTypeMessage}},
+}
+
+// 分类包含的通知类型，空分类表示全部(返回nil)，不认识的分类返回false
+func categoryTypes(category string) ([]int, bool) {
+	if category == "" {
+		return nil, true
+	}
+	for _, c := range notifyCategories {
+		if c.Name == category {
+			return c.Types, true
+		}
+	}
+	return nil, false
+}
+
 type NotificationService struct {
-	repo *repository.NotificationRepository
+	repo         *repository.NotificationRepository
+	postRepo     *repository.PostRepository
+	commentRepo  *repository.CommentRepository
+	userRepo     *repository.UserRepository
+	relationRepo *repository.RelationRepository
+	jobs         *job.Queue
+	block        *BlockService
+	realtime     *RealtimeService
 }
 
-func NewNotificationService(repo *repository.NotificationRepository) *NotificationService {
-	return &NotificationService{repo: repo}
+func NewNotificationService(repo *repository.NotificationRepository, post *repository.PostRepository, comment *repository.CommentRepository, user *repository.UserRepository, relation *repository.RelationRepository, jobs *job.Queue, block *BlockService, realtime *RealtimeService) *NotificationService {
+	return &NotificationService{
+		repo:         repo,
+		postRepo:     post,
+		commentRepo:  comment,
+		userRepo:     user,
+		relationRepo: relation,
+		jobs:         jobs,
+		block:        block,
+		realtime:     realtime,
+	}
 }
 
-// 信息通知
-func (s *NotificationService) sendNotification(ctx context.Context, tx *gorm.DB, recipientID, actorID uint, nType int, content string, targetID uint) {
+// 点赞、评论、关注按"类型+对象"合并成一条，私信、系统通知和关注申请逐条保留
+func aggregatable(nType int) bool {
+	switch nType {
+	case model.NotifyTypeLike, model.NotifyTypeComment, model.NotifyTypeFollow:
+		return true
+	}
+	return false
+}
+
+// 信息通知，投递到任务队列异步写入；队列不可用时直接写库，通知不丢
+// content为动作描述("赞了你的文章")，targetType/targetID为关联对象，没有时传0
+func (s *NotificationService) sendNotification(ctx context.Context, tx *gorm.DB, recipientID, actorID uint, nType int, content string, targetType int, targetID uint) {
 	if recipientID == actorID {
 		return
 	}
-	notification := &model.Notification{
+	payload := notifyJob{
 		RecipientID: recipientID,
 		ActorID:     actorID,
 		Type:        nType,
 		Content:     content,
+		TargetType:  targetType,
 		TargetID:    targetID,
+		At:          time.Now(),
+	}
+	if err := enqueue(ctx, s.jobs, JobNotify, payload); err != nil {
+		_ = s.createNotification(ctx, tx, payload)
+	}
+}
+
+// 有拉黑关系的双方之间不再产生通知，接收者关掉的类型也不产生
+func (s *NotificationService) createNotification(ctx context.Context, tx *gorm.DB, p notifyJob) error {
+	if err := s.block.checkBlocked(ctx, tx, p.RecipientID, p.ActorID); err != nil {
+		if errors.Is(err, e.ErrBlocked) {
+			return nil
+		}
+		return err
+	}
+	deliver, quiet, err := s.checkPreference(ctx, tx, p)
+	if err != nil || !deliver {
+		return err
+	}
+	at := p.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	notification := &model.Notification{
+		RecipientID: p.RecipientID,
+		ActorID:     p.ActorID,
+		Type:        p.Type,
+		Content:     p.Content,
+		TargetType:  p.TargetType,
+		TargetID:    p.TargetID,
+		LatestAt:    at,
+		ActorCount:  1,
 		IsRead:      false,
 	}
-	_ = s.repo.CreateNotification(ctx, tx, notification)
+	s.fillTarget(ctx, tx, notification)
+	if aggregatable(p.Type) {
+		key := fmt.Sprintf("%d:%d:%d", p.Type, p.TargetType, p.TargetID)
+		notification.GroupKey = &key
+		changed, err := s.repo.Aggregate(ctx, tx, notification)
+		if err != nil || !changed {
+			return err
+		}
+	} else if err := s.repo.CreateNotification(ctx, tx, notification); err != nil {
+		return err
+	}
+	if !quiet {
+		s.pushNotification(ctx, tx, notification)
+	}
+	return nil
+}
+
+// 补上摘要和跳转用的文章ID，对象已被删除时留空
+func (s *NotificationService) fillTarget(ctx context.Context, tx *gorm.DB, n *model.Notification) {
+	switch n.TargetType {
+	case model.TargetTypePost:
+		if post, err := s.postRepo.FindPostByID(ctx, tx, n.TargetID); err == nil {
+			n.PostID = post.ID
+			n.Snippet = truncateRunes(post.Title, notifySnippetLen)
+		}
+	case model.TargetTypeComment:
+		if comment, err := s.commentRepo.FindCommentByID(ctx, tx, n.TargetID); err == nil {
+			n.PostID = comment.PostID
+			n.Snippet = truncateRunes(comment.Content, notifySnippetLen)
+		}
+	}
+}
+func truncateRunes(text string, n int) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n]) + "…"
+}
+
+// 把新的或有变化的通知推给在线的接收者，免打扰时段内不调用
+func (s *NotificationService) pushNotification(ctx context.Context, tx *gorm.DB, n *model.Notification) {
+	hidden, err := s.block.hiddenUserIDs(ctx, tx, n.RecipientID)
+	if err != nil {
+		return
+	}
+	vos, err := s.toVOs(ctx, tx, []model.Notification{*n}, hidden)
+	if err != nil || len(vos) == 0 {
+		return
+	}
+	s.realtime.notificationCreated(ctx, tx, n.RecipientID, &vos[0])
 }
 
 // 系统通知
@@ -40,20 +189,223 @@ func (s *NotificationService) SendSystemNotice(ctx context.Context, tx *gorm.DB,
 		Type:        model.NotifyTypeSystem,
 		Content:     content,
 		TargetID:    0,
+		LatestAt:    time.Now(),
+		ActorCount:  1,
 		IsRead:      false,
 	}
-	return s.repo.CreateNotification(ctx, tx, notification)
+	if err := s.repo.CreateNotification(ctx, tx, notification); err != nil {
+		return err
+	}
+	if quiet, err := s.inQuietHours(ctx, tx, recipientID, time.Now()); err == nil && !quiet {
+		s.pushNotification(ctx, tx, notification)
+	}
+	return nil
 }
-func (s *NotificationService) GetNotifications(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]model.Notification, error) {
-	offset := (page - 1) * pageSize
-	return s.repo.GetNotifications(ctx, tx, userID, offset, pageSize)
+
+// category为空时返回全部通知，否则只返回该分类的
+func (s *NotificationService) GetNotifications(ctx context.Context, tx *gorm.DB, userID uint, category, cursor string, pageSize int) (*CursorPage, error) {
+	types, ok := categoryTypes(category)
+	if !ok {
+		return nil, e.ErrInvalidArgs
+	}
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	// 拉黑之前产生的通知也不再展示
+	hidden, err := s.block.hiddenUserIDs(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	list, err := s.repo.GetNotifications(ctx, tx, userID, types, hidden, c.Score, c.ID, pageSize+1)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	page := newCursorPage(list, pageSize, func(n model.Notification) (int64, uint) {
+		return n.CreatedAt.Unix(), n.ID
+	})
+	vos, err := s.toVOs(ctx, tx, page.List.([]model.Notification), hidden)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	page.List = vos
+	return page, nil
 }
-func (s *NotificationService) GetUnreadCount(ctx context.Context, tx *gorm.DB, userID uint) (int64, error) {
-	return s.repo.GetUnreadCount(ctx, tx, userID)
+
+// 合并的通知取最近几个触发者(去掉屏蔽的人)，拼出"张三、李四等100人赞了你的文章"
+func (s *NotificationService) toVOs(ctx context.Context, tx *gorm.DB, list []model.Notification, hidden []uint) ([]NotificationVO, error) {
+	// 已读后退出合并的分组没有GroupKey，触发者仍在notification_actors里
+	grouped := func(n model.Notification) bool { return n.GroupKey != nil || n.ActorCount > 1 }
+	var groupIDs []uint
+	for _, n := range list {
+		if grouped(n) {
+			groupIDs = append(groupIDs, n.ID)
+		}
+	}
+	recent, err := s.repo.RecentActors(ctx, tx, groupIDs, hidden, notifyShownActors)
+	if err != nil {
+		return nil, err
+	}
+	actorIDs := make(map[uint][]uint, len(list))
+	var userIDs []uint
+	for _, n := range list {
+		ids := recent[n.ID]
+		if !grouped(n) && n.ActorID != 0 {
+			ids = []uint{n.ActorID}
+		}
+		actorIDs[n.ID] = ids
+		userIDs = append(userIDs, ids...)
+	}
+	users := make(map[uint]*model.User)
+	if len(userIDs) > 0 {
+		found, err := s.userRepo.FindUsersByIDs(ctx, tx, userIDs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			users[found[i].ID] = &found[i]
+		}
+	}
+	vos := make([]NotificationVO, 0, len(list))
+	for _, n := range list {
+		vo := NotificationVO{
+			ID:         n.ID,
+			Type:       n.Type,
+			Content:    n.Content,
+			Actors:     []UserProfileVO{},
+			ActorCount: n.ActorCount,
+			TargetType: n.TargetType,
+			TargetID:   n.TargetID,
+			PostID:     n.PostID,
+			Snippet:    n.Snippet,
+			IsRead:     n.IsRead,
+			CreatedAt:  n.CreatedAt,
+			LatestAt:   n.LatestAt,
+		}
+		var names []string
+		for _, id := range actorIDs[n.ID] {
+			if u, ok := users[id]; ok {
+				vo.Actors = append(vo.Actors, *newUserProfileVO(u))
+				names = append(names, u.Username)
+			}
+		}
+		switch {
+		case len(names) == 0:
+			vo.Summary = n.Content
+		case n.ActorCount > len(names):
+			vo.Summary = fmt.Sprintf("%s等%d人%s", strings.Join(names, "、"), n.ActorCount, n.Content)
+		default:
+			vo.Summary = strings.Join(names, "、") + n.Content
+		}
+		vos = append(vos, vo)
+	}
+	return vos, nil
+}
+
+// 总未读数和每个分类的未读数，分类标签上的红点用
+func (s *NotificationService) GetUnreadCount(ctx context.Context, tx *gorm.DB, userID uint) (*NotificationUnreadVO, error) {
+	vo, err := unreadByCategory(ctx, tx, s.repo, s.block, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return vo, nil
+}
+
+// 按分类汇总的通知未读数，接口查询和实时推送共用；屏蔽的人触发的通知列表里不展示，也不计入未读
+func unreadByCategory(ctx context.Context, tx *gorm.DB, repo *repository.NotificationRepository, block *BlockService, userID uint) (*NotificationUnreadVO, error) {
+	hidden, err := block.hiddenUserIDs(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	byType, err := repo.UnreadCountsByType(ctx, tx, userID, hidden)
+	if err != nil {
+		return nil, err
+	}
+	vo := &NotificationUnreadVO{Categories: make(map[string]int64, len(notifyCategories))}
+	for _, count := range byType {
+		vo.UnreadCount += count
+	}
+	for _, c := range notifyCategories {
+		vo.Categories[c.Name] = 0
+		for _, t := range c.Types {
+			vo.Categories[c.Name] += byType[t]
+		}
+	}
+	return vo, nil
 }
+
+// 标记已读后把新的未读数推给该用户的其他设备
 func (s *NotificationService) MarkNotificationRead(ctx context.Context, tx *gorm.DB, notificationID, userID uint) error {
-	return s.repo.MarkAsRead(ctx, tx, notificationID, userID)
+	if err := s.repo.MarkAsRead(ctx, tx, notificationID, userID); err != nil {
+		return err
+	}
+	s.realtime.pushUnread(ctx, tx, userID)
+	return nil
+}
+
+// category为空时全部标记已读，否则只标记该分类的
+func (s *NotificationService) MarkAllNotificationsRead(ctx context.Context, tx *gorm.DB, userID uint, category string) error {
+	types, ok := categoryTypes(category)
+	if !ok {
+		return e.ErrInvalidArgs
+	}
+	if err := s.repo.MarkAllAsRead(ctx, tx, userID, types); err != nil {
+		return err
+	}
+	s.realtime.pushUnread(ctx, tx, userID)
+	return nil
+}
+
+// 删除自己的一条通知
+func (s *NotificationService) DeleteNotification(ctx context.Context, tx *gorm.DB, userID, notificationID uint) error {
+	n, err := s.repo.DeleteNotifications(ctx, tx, userID, []uint{notificationID})
+	if err != nil {
+		return e.ErrServer
+	}
+	if n == 0 {
+		return e.ErrNotificationNotFound
+	}
+	s.realtime.pushUnread(ctx, tx, userID)
+	return nil
+}
+
+// 批量删除，不属于自己或已经删掉的ID直接忽略，返回实际删除的条数
+func (s *NotificationService) DeleteNotifications(ctx context.Context, tx *gorm.DB, userID uint, ids []uint) (int64, error) {
+	n, err := s.repo.DeleteNotifications(ctx, tx, userID, ids)
+	if err != nil {
+		return 0, e.ErrServer
+	}
+	if n > 0 {
+		s.realtime.pushUnread(ctx, tx, userID)
+	}
+	return n, nil
 }
-func (s *NotificationService) MarkAllNotificationsRead(ctx context.Context, tx *gorm.DB, userID uint) error {
-	return s.repo.MarkAllAsRead(ctx, tx, userID)
+
+// 定期清理超过保留期的已读通知和用户删掉的通知，未读的不清理
+func (s *NotificationService) StartNotificationPruneWorker(ctx context.Context) {
+	interval := time.Duration(config.Setting.Notify.PruneMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	batch := config.Setting.Notify.PruneBatchSize
+	if batch <= 0 {
+		batch = 1000
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if days := config.Setting.Notify.RetentionDays; days > 0 {
+			before := time.Now().AddDate(0, 0, -days)
+			if n, err := s.repo.PruneNotifications(ctx, nil, before, batch); err != nil {
+				log.Printf("prune notifications failed:%v", err)
+			} else if n > 0 {
+				log.Printf("pruned %d notifications", n)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
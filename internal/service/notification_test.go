@@ -0,0 +1,40 @@
+package service
+
+import (
+	"go-zhihu/internal/model"
+	"slices"
+	"testing"
+)
+
+func TestCategoryTypes(t *testing.T) {
+	if types, ok := categoryTypes(""); !ok || types != nil {
+		t.Fatalf("empty category should mean all types, got %v %v", types, ok)
+	}
+	if types, ok := categoryTypes("follows"); !ok || !slices.Equal(types, []int{model.NotifyTypeFollow, model.NotifyTypeFollowRequest}) {
+		t.Errorf("follows = %v %v", types, ok)
+	}
+	if _, ok := categoryTypes("Likes"); ok {
+		t.Error("category names are case sensitive")
+	}
+	if _, ok := categoryTypes("unknown"); ok {
+		t.Error("unknown category accepted")
+	}
+}
+
+// 每种通知类型恰好属于一个分类，各分类的未读数加起来才等于总数
+func TestCategoriesCoverEveryType(t *testing.T) {
+	owner := make(map[int]string)
+	for _, c := range notifyCategories {
+		for _, typ := range c.Types {
+			if prev, ok := owner[typ]; ok {
+				t.Errorf("type %d is in both %s and %s", typ, prev, c.Name)
+			}
+			owner[typ] = c.Name
+		}
+	}
+	for _, nt := range notifyTypes {
+		if _, ok := owner[nt.Type]; !ok {
+			t.Errorf("type %s is not in any category", nt.Name)
+		}
+	}
+}
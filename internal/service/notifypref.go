@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/pkg/e"
+	"time"
+	// 容器里不一定有系统时区库，内嵌一份保证用户设置的时区都能加载
+	_ "time/tzdata"
+
+	"gorm.io/gorm"
+)
+
+// 可以设置偏好的通知类型，系统通知总是发送
+var notifyTypes = []struct {
+	Type int
+	Name string
+}{
+	{model.NotifyTypeLike, "like"},
+	{model.NotifyTypeComment, "comment"},
+	{model.NotifyTypeFollow, "follow"},
+	{model.NotifyTypeFollowRequest, "follow_request"},
+	{model.NotifyTypeMessage, "message"},
+}
+
+func notifyTypeName(nType int) string {
+	for _, t := range notifyTypes {
+		if t.Type == nType {
+			return t.Name
+		}
+	}
+	return ""
+}
+func notifyTypeByName(name string) int {
+	for _, t := range notifyTypes {
+		if t.Name == name {
+			return t.Type
+		}
+	}
+	return 0
+}
+func validDigest(digest string) bool {
+	return digest == model.DigestDaily || digest == model.DigestWeekly || digest == model.DigestNever
+}
+
+// 没有设置过的类型使用的默认偏好
+func defaultPreference(userID uint, nType int) model.NotificationPreference {
+	return model.NotificationPreference{
+		UserID: userID,
+		Type:   nType,
+		InApp:  true,
+		Digest: config.Setting.Notify.DefaultDigest,
+	}
+}
+
+// 某种通知的偏好，没有设置过时返回默认值
+func (s *NotificationService) preference(ctx context.Context, tx *gorm.DB, userID uint, nType int) (model.NotificationPreference, error) {
+	pref, err := s.repo.FindPreference(ctx, tx, userID, nType)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return defaultPreference(userID, nType), nil
+	}
+	if err != nil {
+		return model.NotificationPreference{}, err
+	}
+	return *pref, nil
+}
+
+// 建通知之前按接收者的偏好检查：关闭了站内通知或设置了只接收关注的人时不建；
+// quiet表示处于免打扰时段，通知照常写入但不实时推送
+func (s *NotificationService) checkPreference(ctx context.Context, tx *gorm.DB, p notifyJob) (deliver, quiet bool, err error) {
+	if notifyTypeName(p.Type) != "" {
+		pref, err := s.preference(ctx, tx, p.RecipientID, p.Type)
+		if err != nil {
+			return false, false, err
+		}
+		if !pref.InApp {
+			return false, false, nil
+		}
+		if pref.FollowingOnly && p.ActorID != 0 {
+			following, err := s.relationRepo.IsFollowing(ctx, tx, p.RecipientID, p.ActorID)
+			if err != nil {
+				return false, false, err
+			}
+			if !following {
+				return false, false, nil
+			}
+		}
+	}
+	quiet, err = s.inQuietHours(ctx, tx, p.RecipientID, time.Now())
+	return true, quiet, err
+}
+
+// 用户的时区，没设置或无法识别时用默认时区
+func userLocation(u *model.User) *time.Location {
+	for _, name := range []string{u.Timezone, config.Setting.Notify.DefaultTimezone} {
+		if name == "" {
+			continue
+		}
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// 按用户所在时区判断是否在免打扰时段，时段可以跨过0点(如22:00-07:00)
+func quietAt(u *model.User, now time.Time) bool {
+	if u.QuietStart == u.QuietEnd {
+		return false
+	}
+	local := now.In(userLocation(u))
+	minute := local.Hour()*60 + local.Minute()
+	if u.QuietStart < u.QuietEnd {
+		return minute >= u.QuietStart && minute < u.QuietEnd
+	}
+	return minute >= u.QuietStart || minute < u.QuietEnd
+}
+func (s *NotificationService) inQuietHours(ctx context.Context, tx *gorm.DB, userID uint, now time.Time) (bool, error) {
+	user, err := s.userRepo.FindUserByID(ctx, tx, userID)
+	if err != nil {
+		return false, err
+	}
+	return quietAt(user, now), nil
+}
+
+// 修改某种通知的偏好，为nil的字段保持不变
+type NotificationPrefPatch struct {
+	InApp         *bool   `json:"in_app"`
+	FollowingOnly *bool   `json:"following_only"`
+	Digest        *string `json:"digest"`
+}
+
+// 免打扰时段，"HH:MM"格式，开始和结束相同表示关闭
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func formatMinute(minute int) string {
+	return fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+}
+func parseMinute(text string) (int, error) {
+	t, err := time.Parse("15:04", text)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// 全部可设置的类型及当前偏好，以及免打扰时段和时区
+func (s *NotificationService) GetPreferences(ctx context.Context, tx *gorm.DB, userID uint) (*NotificationSettingsVO, error) {
+	user, err := s.userRepo.FindUserByID(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	saved, err := s.repo.GetPreferences(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	byType := make(map[int]model.NotificationPreference, len(saved))
+	for _, p := range saved {
+		byType[p.Type] = p
+	}
+	vo := &NotificationSettingsVO{
+		Types:    make([]NotificationPrefVO, 0, len(notifyTypes)),
+		Quiet:    QuietHours{Start: formatMinute(user.QuietStart), End: formatMinute(user.QuietEnd)},
+		Timezone: userLocation(user).String(),
+	}
+	vo.QuietEnabled = user.QuietStart != user.QuietEnd
+	for _, t := range notifyTypes {
+		pref, ok := byType[t.Type]
+		if !ok {
+			pref = defaultPreference(userID, t.Type)
+		}
+		vo.Types = append(vo.Types, NotificationPrefVO{
+			Type:          t.Type,
+			Name:          t.Name,
+			InApp:         pref.InApp,
+			FollowingOnly: pref.FollowingOnly,
+			Digest:        pref.Digest,
+		})
+	}
+	return vo, nil
+}
+
+// 按类型名修改偏好，没传的类型保持不变；quiet和timezone为nil时不修改
+func (s *NotificationService) UpdatePreferences(ctx context.Context, tx *gorm.DB, userID uint, types map[string]NotificationPrefPatch, quiet *QuietHours, timezone *string) error {
+	prefs := make([]model.NotificationPreference, 0, len(types))
+	for name, patch := range types {
+		nType := notifyTypeByName(name)
+		if nType == 0 {
+			return e.ErrInvalidArgs
+		}
+		if patch.Digest != nil && !validDigest(*patch.Digest) {
+			return e.ErrInvalidArgs
+		}
+		pref, err := s.preference(ctx, tx, userID, nType)
+		if err != nil {
+			return e.ErrServer
+		}
+		if patch.InApp != nil {
+			pref.InApp = *patch.InApp
+		}
+		if patch.FollowingOnly != nil {
+			pref.FollowingOnly = *patch.FollowingOnly
+		}
+		if patch.Digest != nil {
+			pref.Digest = *patch.Digest
+		}
+		pref.ID = 0
+		pref.UpdatedAt = time.Now()
+		prefs = append(prefs, pref)
+	}
+	if quiet != nil || timezone != nil {
+		user, err := s.userRepo.FindUserByID(ctx, tx, userID)
+		if err != nil {
+			return e.ErrServer
+		}
+		start, end, tz := user.QuietStart, user.QuietEnd, user.Timezone
+		if quiet != nil {
+			if start, err = parseMinute(quiet.Start); err != nil {
+				return e.ErrInvalidArgs
+			}
+			if end, err = parseMinute(quiet.End); err != nil {
+				return e.ErrInvalidArgs
+			}
+		}
+		if timezone != nil {
+			if _, err := time.LoadLocation(*timezone); err != nil || *timezone == "" {
+				return e.ErrInvalidArgs
+			}
+			tz = *timezone
+		}
+		if err := s.userRepo.SetQuietHours(ctx, tx, userID, start, end, tz); err != nil {
+			return e.ErrServer
+		}
+	}
+	if err := s.repo.SavePreferences(ctx, tx, prefs); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
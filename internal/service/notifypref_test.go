@@ -0,0 +1,68 @@
+package service
+
+import (
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"testing"
+	"time"
+)
+
+func withNotifyConfig(t *testing.T, cfg config.NotifyConfig) {
+	t.Helper()
+	old := config.Setting
+	config.Setting = &config.Config{Notify: cfg}
+	t.Cleanup(func() { config.Setting = old })
+}
+
+// hh:mm(UTC)当天的时刻
+func clock(t *testing.T, s string) time.Time {
+	t.Helper()
+	v, err := time.Parse("15:04", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Date(2024, 5, 1, v.Hour(), v.Minute(), 0, 0, time.UTC)
+}
+
+func TestQuietAt(t *testing.T) {
+	withNotifyConfig(t, config.NotifyConfig{})
+	ranges := []struct {
+		start, end    int
+		quiet, active []string
+	}{
+		// 关闭
+		{8 * 60, 8 * 60, nil, []string{"08:00", "20:00"}},
+		{9 * 60, 18 * 60, []string{"09:00", "12:00", "17:59"}, []string{"08:59", "18:00", "23:00"}},
+		// 跨过0点
+		{22 * 60, 7 * 60, []string{"22:00", "23:30", "00:00", "03:00", "06:59"}, []string{"07:00", "14:00", "21:59"}},
+	}
+	for _, r := range ranges {
+		u := &model.User{QuietStart: r.start, QuietEnd: r.end, Timezone: "UTC"}
+		for _, s := range r.quiet {
+			if !quietAt(u, clock(t, s)) {
+				t.Errorf("[%d,%d) should be quiet at %s", r.start, r.end, s)
+			}
+		}
+		for _, s := range r.active {
+			if quietAt(u, clock(t, s)) {
+				t.Errorf("[%d,%d) should not be quiet at %s", r.start, r.end, s)
+			}
+		}
+	}
+}
+
+func TestQuietAtUsesUserTimezone(t *testing.T) {
+	withNotifyConfig(t, config.NotifyConfig{DefaultTimezone: "Asia/Shanghai"})
+	// 15:00 UTC是北京时间23:00
+	now := clock(t, "15:00")
+	for _, tz := range []string{"Asia/Shanghai", "", "Mars/Olympus"} {
+		u := &model.User{QuietStart: 22 * 60, QuietEnd: 7 * 60, Timezone: tz}
+		if !quietAt(u, now) {
+			t.Errorf("timezone %q: should be quiet at 23:00 Shanghai time", tz)
+		}
+	}
+	u := &model.User{QuietStart: 22 * 60, QuietEnd: 7 * 60, Timezone: "UTC"}
+	if quietAt(u, now) {
+		t.Error("15:00 UTC should not be quiet for a UTC user")
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go-zhihu/internal/job"
 	"go-zhihu/internal/model"
 	"go-zhihu/internal/repository"
 	"go-zhihu/pkg/e"
 	"log"
+	"strings"
 	"time"
 	"unicode/utf8"
 
@@ -18,18 +20,26 @@ import (
 )
 
 type PostService struct {
-	repo     *repository.PostRepository
-	likeRepo *repository.LikeRepository
-	feed     *FeedService
-	relation *repository.RelationRepository
-	rdb      *redis.Client
-	sf       singleflight.Group
+	repo      *repository.PostRepository
+	likeRepo  *repository.LikeRepository
+	topicRepo *repository.TopicRepository
+	jobs      *job.Queue
+	stats     *StatsService
+	history   *HistoryService
+	activity  *ActivityService
+	block     *BlockService
+	privacy   *PrivacyService
+	similar   *SimilarService
+	rdb       *redis.Client
+	sf        singleflight.Group
 }
 
-func NewPostService(repo *repository.PostRepository, likeRepo *repository.LikeRepository, feed *FeedService, rdb *redis.Client) *PostService {
-	return &PostService{repo: repo, likeRepo: likeRepo, feed: feed, rdb: rdb}
+func NewPostService(repo *repository.PostRepository, likeRepo *repository.LikeRepository, topicRepo *repository.TopicRepository, jobs *job.Queue, stats *StatsService, history *HistoryService, activity *ActivityService, block *BlockService, privacy *PrivacyService, similar *SimilarService, rdb *redis.Client) *PostService {
+	return &PostService{repo: repo, likeRepo: likeRepo, topicRepo: topicRepo, jobs: jobs, stats: stats, history: history, activity: activity, block: block, privacy: privacy, similar: similar, rdb: rdb}
 }
 
+const maxPostTopics = 5
+
 const (
 	CacheKeyPostDetail   = "post:detail:%d"
 	CacheNullPlaceholder = "NULL"
@@ -37,19 +47,33 @@ const (
 
 // 处理内容的发布、更新、获取和删
 
-func (s *PostService) CreatePost(ctx context.Context, tx *gorm.DB, authorID uint, title, content string, postType int, status int) error {
+// 直接发布问题时先查重，有可能重复的问题且force为false时不创建，把候选返回给用户确认
+func (s *PostService) CreatePost(ctx context.Context, tx *gorm.DB, authorID uint, title, content string, postType int, status int, topics []string, force bool) (*CreatePostVO, error) {
 	if utf8.RuneCountInString(title) == 0 || utf8.RuneCountInString(title) > 255 {
-		return e.ErrInvalidArgs
+		return nil, e.ErrInvalidArgs
+	}
+	topicNames, ok := normalizeTopics(topics)
+	if !ok {
+		return nil, e.ErrInvalidArgs
 	}
 	if content == "" {
-		return e.ErrInvalidArgs
+		return nil, e.ErrInvalidArgs
 	}
 	if postType != 1 && postType != 2 {
-		return e.ErrInvalidArgs
+		return nil, e.ErrInvalidArgs
 	}
 	if status != 0 && status != 1 {
 		status = 1
 	}
+	if postType == 2 && status == model.PostStatusPublished && !force {
+		duplicates, err := s.similar.FindDuplicates(ctx, tx, authorID, title, content)
+		if err != nil {
+			return nil, err
+		}
+		if len(duplicates) > 0 {
+			return &CreatePostVO{Duplicates: duplicates}, nil
+		}
+	}
 	post := &model.Post{
 		Title:    title,
 		Content:  content,
@@ -59,32 +83,96 @@ func (s *PostService) CreatePost(ctx context.Context, tx *gorm.DB, authorID uint
 		Hotscore: 0,
 	}
 	if err := s.repo.CreatePost(ctx, tx, post); err != nil {
-		return e.ErrServer
+		return nil, e.ErrServer
+	}
+	if len(topicNames) > 0 {
+		topicList, err := s.topicRepo.FindOrCreateByNames(ctx, tx, topicNames)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		topicIDs := make([]uint, 0, len(topicList))
+		for _, t := range topicList {
+			topicIDs = append(topicIDs, t.ID)
+		}
+		if err := s.topicRepo.BindPostTopics(ctx, tx, post.ID, topicIDs); err != nil {
+			return nil, e.ErrServer
+		}
 	}
 	// 只在发布状态下分发
-	if status == model.PostStatusPublished {
-		// 使用 post 的副本，避免并发问题
-		postCopy := *post
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("panic in DistributePostToFollowers: %v", r)
-				}
-			}()
-			bgCtx := context.Background()
-			s.DistributePostToFollowers(bgCtx, nil, &postCopy)
-		}()
+	s.syncFeed(ctx, tx, post, model.PostStatusDraft, status)
+	return &CreatePostVO{PostID: post.ID, Created: true}, nil
+}
+
+// 话题去重去空，限制数量和长度
+func normalizeTopics(topics []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(topics))
+	for _, t := range topics {
+		name := strings.TrimSpace(t)
+		if name == "" || seen[name] {
+			continue
+		}
+		if utf8.RuneCountInString(name) > 64 {
+			return nil, false
+		}
+		seen[name] = true
+		names = append(names, name)
 	}
-	return nil
+	if len(names) > maxPostTopics {
+		return nil, false
+	}
+	return names, true
 }
 
 //补充普通的最新文章列表
 
-func (s *PostService) GetLatestPosts(ctx context.Context, tx *gorm.DB, page, pageSize int) ([]model.Post, error) {
-	offset := (page - 1) * pageSize
-	return s.repo.ListPosts(ctx, tx, offset, pageSize, "created_at")
+func (s *PostService) GetLatestPosts(ctx context.Context, tx *gorm.DB, viewerID uint, cursor string, pageSize int) (*CursorPage, error) {
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := s.repo.ListPosts(ctx, tx, viewerID, c.Score, c.ID, pageSize+1)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return newCursorPage(posts, pageSize, postCursorKey), nil
+}
+
+// 获取文章详情并记录一次阅读，viewerID为0表示游客；已合并的问题返回合并后的问题
+func (s *PostService) GetPostDetail(ctx context.Context, tx *gorm.DB, postID, viewerID uint, clientIP string) (*PostDetailVO, error) {
+	postDetail, err := s.loadPostDetail(ctx, tx, postID)
+	var mergedFrom uint
+	if errors.Is(err, e.ErrPostNotFound) {
+		target, terr := s.repo.MergedTarget(ctx, tx, postID)
+		if terr != nil {
+			return nil, e.ErrServer
+		}
+		if target != 0 {
+			mergedFrom = postID
+			postID = target
+			postDetail, err = s.loadPostDetail(ctx, tx, postID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	postDetail.MergedFrom = mergedFrom
+	// 详情有缓存，私密状态随时可能切换，所以每次都重新判断
+	if err := s.privacy.checkVisible(ctx, tx, viewerID, postDetail.AuthorID); err != nil {
+		return nil, err
+	}
+	if postDetail.Status == model.PostStatusPublished {
+		s.stats.RecordView(ctx, tx, postID, postDetail.Type, viewerID, clientIP)
+		s.history.RecordRead(ctx, tx, viewerID, postID)
+		related, err := s.similar.Related(ctx, tx, postID, viewerID)
+		if err != nil {
+			log.Printf("failed to load related posts:%v", err)
+		}
+		postDetail.Related = related
+	}
+	return postDetail, nil
 }
-func (s *PostService) GetPostDetail(ctx context.Context, tx *gorm.DB, postID uint) (*PostDetailVO, error) {
+func (s *PostService) loadPostDetail(ctx context.Context, tx *gorm.DB, postID uint) (*PostDetailVO, error) {
 	cacheKey := fmt.Sprintf(CacheKeyPostDetail, postID)
 	val, err := s.rdb.Get(ctx, cacheKey).Result()
 	if err == nil {
@@ -108,16 +196,16 @@ func (s *PostService) GetPostDetail(ctx context.Context, tx *gorm.DB, postID uin
 		return nil, e.ErrServer
 	}
 	count, err := s.likeRepo.CountLikes(ctx, tx, postID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
 	postDetail := &PostDetailVO{
 		Post:      post,
 		LikeCount: count,
 	}
 	data, _ := json.Marshal(postDetail)
 	s.rdb.Set(ctx, cacheKey, data, getRandomExpire(30*time.Minute))
-	if err != nil {
-		return nil, err
-	}
-	return &PostDetailVO{Post: post}, nil
+	return postDetail, nil
 }
 
 // 获取草稿箱
@@ -138,7 +226,41 @@ func (s *PostService) PublishPost(ctx context.Context, tx *gorm.DB, postID, auth
 	if post.Status != 0 {
 		return e.ErrInvalidArgs
 	}
-	return s.repo.UpdateStatus(ctx, tx, postID, 1)
+	if err := s.repo.UpdateStatus(ctx, tx, postID, 1); err != nil {
+		return e.ErrServer
+	}
+	s.DeletePostCache(ctx, tx, postID)
+	s.syncFeed(ctx, tx, post, model.PostStatusDraft, model.PostStatusPublished)
+	return nil
+}
+
+// 文章状态变化后同步时间线和搜索索引：发布时分发并记一条动态，下架或删除时从时间线移除(动态读取时按文章状态过滤)
+func (s *PostService) syncFeed(ctx context.Context, tx *gorm.DB, post *model.Post, oldStatus, newStatus int) {
+	if oldStatus == newStatus {
+		return
+	}
+	payload := postJob{PostID: post.ID, AuthorID: post.AuthorID}
+	if oldStatus == model.PostStatusPublished || newStatus == model.PostStatusPublished {
+		s.reindex(ctx, payload)
+	}
+	if newStatus == model.PostStatusPublished {
+		dispatch(ctx, s.jobs, JobFeedDistribute, payload)
+		activityType := model.ActivityPublishArticle
+		if post.Type == 2 {
+			activityType = model.ActivityAskQuestion
+		}
+		s.activity.Record(ctx, tx, post.AuthorID, activityType, post.ID, 0)
+		return
+	}
+	if oldStatus == model.PostStatusPublished {
+		dispatch(ctx, s.jobs, JobFeedRemovePost, payload)
+	}
+}
+
+// 更新搜索索引和相似度签名
+func (s *PostService) reindex(ctx context.Context, payload postJob) {
+	dispatch(ctx, s.jobs, JobSearchIndex, payload)
+	dispatch(ctx, s.jobs, JobSimilarIndex, payload)
 }
 func (s *PostService) UpdatePost(ctx context.Context, tx *gorm.DB, postID, authorID uint, title, content string, status *int) error {
 	post, err := s.repo.FindPostByID(ctx, tx, postID)
@@ -148,6 +270,7 @@ func (s *PostService) UpdatePost(ctx context.Context, tx *gorm.DB, postID, autho
 	if post.AuthorID != authorID {
 		return e.ErrPermission
 	}
+	oldStatus := post.Status
 	post.Title = title
 	post.Content = content
 	if status != nil {
@@ -160,6 +283,11 @@ func (s *PostService) UpdatePost(ctx context.Context, tx *gorm.DB, postID, autho
 		return err
 	}
 	s.DeletePostCache(ctx, tx, postID)
+	// 已发布的文章改了内容，状态没变也要重建索引
+	if oldStatus == post.Status && post.Status == model.PostStatusPublished {
+		s.reindex(ctx, postJob{PostID: post.ID, AuthorID: post.AuthorID})
+	}
+	s.syncFeed(ctx, tx, post, oldStatus, post.Status)
 	return nil
 }
 func (s *PostService) DeletePost(ctx context.Context, tx *gorm.DB, postID, authorID uint) error {
@@ -170,29 +298,32 @@ func (s *PostService) DeletePost(ctx context.Context, tx *gorm.DB, postID, autho
 	if post.AuthorID != authorID {
 		return e.ErrPermission
 	}
+	oldStatus := post.Status
 	post.Status = 2
 	if err := s.repo.UpdatePost(ctx, tx, post); err != nil {
 		return e.ErrServer
 	}
 	s.DeletePostCache(ctx, tx, postID)
+	s.syncFeed(ctx, tx, post, oldStatus, post.Status)
 	return nil
 }
 func (s *PostService) DeletePostCache(ctx context.Context, tx *gorm.DB, postID uint) {
 	cacheKey := fmt.Sprintf(CacheKeyPostDetail, postID)
 	s.rdb.Del(ctx, cacheKey)
 }
-func (s *PostService) Search(ctx context.Context, tx *gorm.DB, keyword string, page, pageSize int) ([]model.Post, error) {
-	offset := (page - 1) * pageSize
-	return s.repo.SearchPosts(ctx, tx, keyword, offset, pageSize)
-}
-
-// 获取文章列表
-func (s *PostService) GetUserPosts(ctx context.Context, tx *gorm.DB, targetID uint, page, pageSize int) ([]model.Post, error) {
-	offset := (page - 1) * pageSize
-	return s.repo.ListPublicByAuthorID(ctx, tx, targetID, offset, pageSize)
-}
 
-// 排行榜补充
-func (s *PostService) GetLeaderboard(ctx context.Context, tx *gorm.DB, limit int) ([]model.Post, error) {
-	return s.repo.GetLeaderboard(ctx, tx, limit)
+// 获取文章列表，私密账号只有本人和关注者能看
+func (s *PostService) GetUserPosts(ctx context.Context, tx *gorm.DB, targetID, viewerID uint, cursor string, pageSize int) (*CursorPage, error) {
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.privacy.checkVisible(ctx, tx, viewerID, targetID); err != nil {
+		return nil, err
+	}
+	posts, err := s.repo.ListPublicByAuthorID(ctx, tx, targetID, c.Score, c.ID, pageSize+1)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return newCursorPage(posts, pageSize, postCursorKey), nil
 }
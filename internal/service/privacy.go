@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+
+	"gorm.io/gorm"
+)
+
+// 私密账号：文章只对本人和已通过审核的关注者可见
+type PrivacyService struct {
+	userRepo     *repository.UserRepository
+	relationRepo *repository.RelationRepository
+}
+
+func NewPrivacyService(user *repository.UserRepository, relation *repository.RelationRepository) *PrivacyService {
+	return &PrivacyService{userRepo: user, relationRepo: relation}
+}
+
+// viewerID能否看到authorID的内容，viewerID为0表示游客
+func (s *PrivacyService) canView(ctx context.Context, tx *gorm.DB, viewerID, authorID uint) (bool, error) {
+	if viewerID != 0 && viewerID == authorID {
+		return true, nil
+	}
+	private, err := s.userRepo.IsPrivate(ctx, tx, authorID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !private {
+		return true, nil
+	}
+	if viewerID == 0 {
+		return false, nil
+	}
+	return s.relationRepo.IsFollowing(ctx, tx, viewerID, authorID)
+}
+
+// 看不到时返回ErrPrivateContent
+func (s *PrivacyService) checkVisible(ctx context.Context, tx *gorm.DB, viewerID, authorID uint) error {
+	ok, err := s.canView(ctx, tx, viewerID, authorID)
+	if err != nil {
+		return e.ErrServer
+	}
+	if !ok {
+		return e.ErrPrivateContent
+	}
+	return nil
+}
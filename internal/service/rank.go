@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+type RankService struct {
+	postRepo  *repository.PostRepository
+	topicRepo *repository.TopicRepository
+	rdb       *redis.Client
+	sf        singleflight.Group
+}
+
+func NewRankService(post *repository.PostRepository, topic *repository.TopicRepository, rdb *redis.Client) *RankService {
+	return &RankService{postRepo: post, topicRepo: topic, rdb: rdb}
+}
+
+const (
+	RankHotKey       = "rank:hot"
+	rankHotScopesKey = "rank:hot:scopes"
+	rankHotLockKey   = "rank:hot:lock"
+	rankBucketPrefix = "rank:bucket:"
+	rankBoardPrefix  = "rank:board:"
+	rankZAddBatch    = 500
+)
+
+// 榜单类型
+const (
+	BoardHot     = "hot"
+	BoardDaily   = "daily"
+	BoardWeekly  = "weekly"
+	BoardMonthly = "monthly"
+	BoardRising  = "rising"
+)
+
+// 榜单范围：全站/按类型(文章、问题)/按话题，话题优先于类型
+func rankScope(postType int, topicID uint) string {
+	if topicID > 0 {
+		return fmt.Sprintf("topic:%d", topicID)
+	}
+	if postType > 0 {
+		return fmt.Sprintf("type:%d", postType)
+	}
+	return "all"
+}
+
+// 一篇文章会同时计入的所有榜单范围
+func postScopes(postType int, topicIDs []uint) []string {
+	scopes := []string{rankScope(0, 0), rankScope(postType, 0)}
+	for _, tid := range topicIDs {
+		scopes = append(scopes, rankScope(0, tid))
+	}
+	return scopes
+}
+
+func hotKey(scope string) string {
+	if scope == "all" {
+		return RankHotKey
+	}
+	return RankHotKey + ":" + scope
+}
+func hourBucketKey(scope string, t time.Time) string {
+	return rankBucketPrefix + scope + ":h:" + t.Format("2006010215")
+}
+func dayBucketKey(scope string, t time.Time) string {
+	return rankBucketPrefix + scope + ":d:" + t.Format("20060102")
+}
+
+// 重力热度：互动加权和随发布时间衰减，老文章不会永远霸榜
+func hotScore(stat repository.PostHotStat, cfg config.RankConfig, now time.Time) float64 {
+	points := float64(stat.LikeCount)*cfg.LikeWeight +
+		float64(stat.CommentCount)*cfg.CommentWeight +
+		float64(stat.BookmarkCount)*cfg.BookmarkWeight +
+		float64(stat.ViewCount)*cfg.ViewWeight
+	ageHours := now.Sub(stat.CreatedAt).Hours()
+	if ageHours < 0 {
+		ageHours = 0
+	}
+	return points / math.Pow(ageHours+cfg.BaseHours, cfg.Gravity)
+}
+
+func hotRankInterval() time.Duration {
+	interval := time.Duration(config.Setting.Rank.RefreshSeconds) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return interval
+}
+
+// 多实例部署时每个刷新周期只由一个实例计算：成功后不释放锁，锁在下个周期前过期，
+// 期间其他实例的定时任务直接跳过；失败时释放，让别的实例接着重试
+func (s *RankService) RefreshHotRank(ctx context.Context, tx *gorm.DB) error {
+	interval := hotRankInterval()
+	lock, err := acquireLock(ctx, s.rdb, rankHotLockKey, interval-interval/10)
+	if err != nil || lock == nil {
+		return err
+	}
+	if err := s.refreshHotRank(ctx, tx); err != nil {
+		lock.Release(ctx)
+		return err
+	}
+	return nil
+}
+
+// 重新计算候选窗口内文章的热度，按范围写入redis热榜并回写数据库，窗口外的文章热度归零
+func (s *RankService) refreshHotRank(ctx context.Context, tx *gorm.DB) error {
+	cfg := config.Setting.Rank
+	now := time.Now()
+	since := now.AddDate(0, 0, -cfg.CandidateDays)
+	stats, err := s.postRepo.ListHotStats(ctx, tx, since)
+	if err != nil {
+		return err
+	}
+	postIDs := make([]uint, 0, len(stats))
+	for _, stat := range stats {
+		postIDs = append(postIDs, stat.ID)
+	}
+	topicMap, err := s.topicRepo.GetTopicIDsByPosts(ctx, tx, postIDs)
+	if err != nil {
+		return err
+	}
+	scores := make(map[uint]float64, len(stats))
+	boards := make(map[string][]*redis.Z)
+	for _, stat := range stats {
+		score := hotScore(stat, cfg, now)
+		scores[stat.ID] = score
+		for _, scope := range postScopes(stat.Type, topicMap[stat.ID]) {
+			boards[scope] = append(boards[scope], &redis.Z{Score: score, Member: stat.ID})
+		}
+	}
+	oldScopes, err := s.rdb.SMembers(ctx, rankHotScopesKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	// 先写临时key再rename，读榜单的请求不会看到写了一半的数据
+	pipe := s.rdb.TxPipeline()
+	scopes := make([]interface{}, 0, len(boards))
+	for scope, members := range boards {
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].Score > members[j].Score
+		})
+		if cfg.BoardSize > 0 && len(members) > cfg.BoardSize {
+			members = members[:cfg.BoardSize]
+		}
+		key := hotKey(scope)
+		tmpKey := key + ":tmp"
+		pipe.Del(ctx, tmpKey)
+		for i := 0; i < len(members); i += rankZAddBatch {
+			end := i + rankZAddBatch
+			if end > len(members) {
+				end = len(members)
+			}
+			pipe.ZAdd(ctx, tmpKey, members[i:end]...)
+		}
+		pipe.Rename(ctx, tmpKey, key)
+		scopes = append(scopes, scope)
+	}
+	// 本轮已没有候选文章的范围，清掉旧榜单
+	for _, scope := range oldScopes {
+		if _, ok := boards[scope]; !ok {
+			pipe.Del(ctx, hotKey(scope))
+		}
+	}
+	if _, ok := boards["all"]; !ok {
+		pipe.Del(ctx, RankHotKey)
+	}
+	pipe.Del(ctx, rankHotScopesKey)
+	if len(scopes) > 0 {
+		pipe.SAdd(ctx, rankHotScopesKey, scopes...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if err := s.postRepo.UpdateHotScores(ctx, tx, scores); err != nil {
+		return err
+	}
+	_, err = s.postRepo.ResetStaleHotScores(ctx, tx, since)
+	return err
+}
+
+// 后台定时刷新热榜，ctx取消时退出
+func (s *RankService) StartHotRankWorker(ctx context.Context) {
+	ticker := time.NewTicker(hotRankInterval())
+	defer ticker.Stop()
+	for {
+		if err := s.RefreshHotRank(ctx, nil); err != nil {
+			log.Printf("refresh hot rank failed:%v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 记录一次互动到按小时、按天分桶的zset，weight为负表示撤销(取消点赞等)
+func (s *RankService) RecordInteraction(ctx context.Context, tx *gorm.DB, postID uint, postType int, weight float64) {
+	if weight == 0 {
+		return
+	}
+	topicMap, err := s.topicRepo.GetTopicIDsByPosts(ctx, tx, []uint{postID})
+	if err != nil {
+		log.Printf("failed to load post topics:%v", err)
+	}
+	now := time.Now()
+	member := strconv.FormatUint(uint64(postID), 10)
+	pipe := s.rdb.Pipeline()
+	for _, scope := range postScopes(postType, topicMap[postID]) {
+		hourKey := hourBucketKey(scope, now)
+		dayKey := dayBucketKey(scope, now)
+		pipe.ZIncrBy(ctx, hourKey, weight, member)
+		pipe.Expire(ctx, hourKey, 48*time.Hour)
+		pipe.ZIncrBy(ctx, dayKey, weight, member)
+		pipe.Expire(ctx, dayKey, 31*24*time.Hour)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("failed to record rank interaction:%v", err)
+	}
+}
+
+// 各榜单需要合并的分桶及权重
+// rising 用最近2小时的平均互动减去之前6小时的平均互动，即热度的增长速度
+func boardSources(board, scope string, now time.Time) ([]string, []float64) {
+	var keys []string
+	var weights []float64
+	switch board {
+	case BoardDaily:
+		for i := 0; i < 24; i++ {
+			keys = append(keys, hourBucketKey(scope, now.Add(-time.Duration(i)*time.Hour)))
+			weights = append(weights, 1)
+		}
+	case BoardWeekly, BoardMonthly:
+		days := 7
+		if board == BoardMonthly {
+			days = 30
+		}
+		for i := 0; i < days; i++ {
+			keys = append(keys, dayBucketKey(scope, now.AddDate(0, 0, -i)))
+			weights = append(weights, 1)
+		}
+	case BoardRising:
+		for i := 0; i < 8; i++ {
+			keys = append(keys, hourBucketKey(scope, now.Add(-time.Duration(i)*time.Hour)))
+			if i < 2 {
+				weights = append(weights, 1.0/2)
+			} else {
+				weights = append(weights, -1.0/6)
+			}
+		}
+	}
+	return keys, weights
+}
+
+// 榜单不存在时用ZUNIONSTORE合并分桶生成，并缓存一段时间
+func (s *RankService) ensureBoard(ctx context.Context, board, scope string) (string, error) {
+	key := rankBoardPrefix + board + ":" + scope
+	exists, err := s.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+	if exists > 0 {
+		return key, nil
+	}
+	_, err, _ = s.sf.Do(key, func() (interface{}, error) {
+		keys, weights := boardSources(board, scope, time.Now())
+		pipe := s.rdb.TxPipeline()
+		pipe.ZUnionStore(ctx, key, &redis.ZStore{Keys: keys, Weights: weights, Aggregate: "SUM"})
+		// 取消点赞等撤销操作可能让分数变成非正数，这些文章不上榜
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "0")
+		pipe.Expire(ctx, key, time.Duration(config.Setting.Rank.BoardCacheSecs)*time.Second)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	})
+	return key, err
+}
+
+// 分页读取榜单，postType和topicID为0表示不过滤
+func (s *RankService) GetBoard(ctx context.Context, tx *gorm.DB, board string, postType int, topicID uint, page, pageSize int) ([]model.Post, error) {
+	scope := rankScope(postType, topicID)
+	offset := (page - 1) * pageSize
+	var key string
+	switch board {
+	case BoardHot:
+		key = hotKey(scope)
+		total, err := s.rdb.ZCard(ctx, key).Result()
+		if err != nil {
+			log.Printf("redis error:%v", err)
+		}
+		// redis中没有榜单时退回数据库的hot_score排序
+		if (err != nil || total == 0) && topicID == 0 {
+			posts, err := s.postRepo.GetLeaderboard(ctx, tx, postType, offset, pageSize)
+			if err != nil {
+				return nil, e.ErrServer
+			}
+			return posts, nil
+		}
+	case BoardDaily, BoardWeekly, BoardMonthly, BoardRising:
+		var err error
+		key, err = s.ensureBoard(ctx, board, scope)
+		if err != nil {
+			log.Printf("failed to build board %s:%v", board, err)
+			return nil, e.ErrServer
+		}
+	default:
+		return nil, e.ErrInvalidArgs
+	}
+	postIDs, err := s.rdb.ZRevRange(ctx, key, int64(offset), int64(offset+pageSize-1)).Result()
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	if len(postIDs) == 0 {
+		return []model.Post{}, nil
+	}
+	posts, err := s.postRepo.FindPostsByIDs(ctx, tx, postIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	// 榜单是公开的，作者设为私密后文章不再上榜
+	public := posts[:0]
+	for _, p := range posts {
+		if !p.Author.IsPrivate {
+			public = append(public, p)
+		}
+	}
+	return sortPostsByIDs(public, postIDs), nil
+}
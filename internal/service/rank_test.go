@@ -0,0 +1,45 @@
+package service
+
+import (
+	"go-zhihu/config"
+	"go-zhihu/internal/repository"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHotScoreWeightsInteractions(t *testing.T) {
+	cfg := config.RankConfig{LikeWeight: 1, CommentWeight: 2, BookmarkWeight: 3, ViewWeight: 0.1, Gravity: 1, BaseHours: 2}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	stat := repository.PostHotStat{LikeCount: 4, CommentCount: 3, BookmarkCount: 2, ViewCount: 20, CreatedAt: now.Add(-6 * time.Hour)}
+
+	// (4*1 + 3*2 + 2*3 + 20*0.1) / (6+2)^1
+	want := 18.0 / 8
+	if got := hotScore(stat, cfg, now); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("hotScore = %v, want %v", got, want)
+	}
+	if got := hotScore(repository.PostHotStat{CreatedAt: now}, cfg, now); got != 0 {
+		t.Fatalf("post without interactions scored %v", got)
+	}
+}
+
+func TestHotScoreDecaysWithAge(t *testing.T) {
+	cfg := config.RankConfig{LikeWeight: 1, Gravity: 1.8, BaseHours: 2}
+	now := time.Now()
+	fresh := hotScore(repository.PostHotStat{LikeCount: 10, CreatedAt: now.Add(-time.Hour)}, cfg, now)
+	old := hotScore(repository.PostHotStat{LikeCount: 100, CreatedAt: now.Add(-72 * time.Hour)}, cfg, now)
+	if fresh <= old {
+		t.Fatalf("10 likes an hour ago (%v) should beat 100 likes three days ago (%v)", fresh, old)
+	}
+}
+
+func TestHotScoreFutureCreatedAt(t *testing.T) {
+	cfg := config.RankConfig{LikeWeight: 1, Gravity: 1.8, BaseHours: 2}
+	now := time.Now()
+	// 时钟不一致时发布时间可能比now晚，按刚发布计算，不能得到更高的分数
+	future := hotScore(repository.PostHotStat{LikeCount: 5, CreatedAt: now.Add(time.Hour)}, cfg, now)
+	current := hotScore(repository.PostHotStat{LikeCount: 5, CreatedAt: now}, cfg, now)
+	if future != current {
+		t.Fatalf("future post scored %v, want %v", future, current)
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/realtime"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 推送给客户端的事件类型
+const (
+	EventReady        = "ready"
+	EventNotification = "notification"
+	EventMessage      = "message"
+	EventUnread       = "unread"
+	EventTyping       = "typing"
+	EventPing         = "ping"
+	EventPong         = "pong"
+	EventError        = "error"
+)
+
+// 正在输入的转发节流
+const typingThrottleKey = "realtime:typing:%d:%d"
+
+type RealtimeService struct {
+	hub        *realtime.Hub
+	notifyRepo *repository.NotificationRepository
+	msgRepo    *repository.MessageRepository
+	block      *BlockService
+	rdb        *redis.Client
+}
+
+func NewRealtimeService(notifyRepo *repository.NotificationRepository, msgRepo *repository.MessageRepository, block *BlockService, rdb *redis.Client) *RealtimeService {
+	return &RealtimeService{
+		hub:        realtime.NewHub(rdb, config.Setting.Realtime),
+		notifyRepo: notifyRepo,
+		msgRepo:    msgRepo,
+		block:      block,
+		rdb:        rdb,
+	}
+}
+
+// 客户端发上来的消息，目前只有心跳回应和正在输入
+type ClientFrame struct {
+	Type string `json:"type"`
+	To   uint   `json:"to,omitempty"`
+}
+
+// 建立连接，并先给这个连接下发一次当前的未读数，断线重连后以此为准
+func (s *RealtimeService) Connect(ctx context.Context, tx *gorm.DB, userID uint) (*realtime.Client, error) {
+	client, err := s.hub.Register(userID)
+	if err != nil {
+		if errors.Is(err, realtime.ErrTooManyConns) {
+			return nil, e.ErrTooManyConnections
+		}
+		return nil, e.ErrServer
+	}
+	_ = client.Push(realtime.Event{Type: EventReady})
+	counts, err := totalUnread(ctx, tx, s.notifyRepo, s.msgRepo, s.block, userID)
+	if err != nil {
+		log.Printf("query unread of user %d failed:%v", userID, err)
+	} else {
+		_ = client.Push(realtime.Event{Type: EventUnread, Data: counts})
+	}
+	return client, nil
+}
+func (s *RealtimeService) Disconnect(client *realtime.Client) {
+	s.hub.Unregister(client)
+	if client.Dropped() {
+		log.Printf("realtime client of user %d dropped: send buffer full", client.UserID)
+	}
+}
+
+// 在websocket上推送，客户端发来的消息出错时回一条error事件，不断开连接
+func (s *RealtimeService) ServeWebSocket(ctx context.Context, tx *gorm.DB, w http.ResponseWriter, req *http.Request, client *realtime.Client) {
+	s.hub.ServeWebSocket(w, req, client, func(raw []byte) *realtime.Event {
+		var frame ClientFrame
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			return &realtime.Event{Type: EventError, Data: e.ErrInvalidArgs}
+		}
+		if err := s.HandleFrame(ctx, tx, client.UserID, frame); err != nil {
+			var bizErr *e.Error
+			if !errors.As(err, &bizErr) {
+				bizErr = e.ErrServer
+			}
+			return &realtime.Event{Type: EventError, Data: bizErr}
+		}
+		return nil
+	})
+}
+
+// 以sse推送
+func (s *RealtimeService) ServeSSE(w http.ResponseWriter, req *http.Request, client *realtime.Client) error {
+	return s.hub.ServeSSE(w, req, client)
+}
+
+// 处理websocket上收到的消息；任何消息都算心跳，由传输层刷新读超时
+func (s *RealtimeService) HandleFrame(ctx context.Context, tx *gorm.DB, userID uint, frame ClientFrame) error {
+	switch frame.Type {
+	case EventTyping:
+		return s.Typing(ctx, tx, userID, frame.To)
+	case EventPong, EventPing:
+		return nil
+	default:
+		return e.ErrInvalidArgs
+	}
+}
+
+// 告诉对方"正在输入"，同一对用户之间按配置节流，拉黑的双方之间不转发
+func (s *RealtimeService) Typing(ctx context.Context, tx *gorm.DB, userID, peerID uint) error {
+	if peerID == 0 {
+		return e.ErrInvalidArgs
+	}
+	if userID == peerID {
+		return e.ErrSelfAction
+	}
+	if err := s.block.checkBlocked(ctx, tx, userID, peerID); err != nil {
+		return err
+	}
+	ttl := time.Duration(config.Setting.Realtime.TypingThrottleSecs) * time.Second
+	if ttl > 0 {
+		ok, err := s.rdb.SetNX(ctx, fmt.Sprintf(typingThrottleKey, userID, peerID), 1, ttl).Result()
+		if err == nil && !ok {
+			return nil
+		}
+	}
+	return s.hub.Publish(ctx, []uint{peerID}, realtime.Event{
+		Type: EventTyping,
+		Data: map[string]uint{"from": userID},
+	})
+}
+
+// 新通知(或合并的通知来了新的人)：推送通知本身和最新的未读数
+func (s *RealtimeService) notificationCreated(ctx context.Context, tx *gorm.DB, recipientID uint, vo *NotificationVO) {
+	if err := s.hub.Publish(ctx, []uint{recipientID}, realtime.Event{Type: EventNotification, Data: vo}); err != nil {
+		log.Printf("realtime publish notification failed:%v", err)
+	}
+	s.pushUnread(ctx, tx, recipientID)
+}
+
+// 新私信：收发双方都推送，发送者的其他设备也能同步看到
+func (s *RealtimeService) messageSent(ctx context.Context, tx *gorm.DB, msg *model.Message) {
+	if err := s.hub.Publish(ctx, []uint{msg.ReceiverID, msg.SenderID}, realtime.Event{Type: EventMessage, Data: msg}); err != nil {
+		log.Printf("realtime publish message failed:%v", err)
+	}
+	s.pushUnread(ctx, tx, msg.ReceiverID)
+}
+
+// 未读数变化后推送给该用户的所有连接，红点在多端之间保持一致
+func (s *RealtimeService) pushUnread(ctx context.Context, tx *gorm.DB, userID uint) {
+	counts, err := totalUnread(ctx, tx, s.notifyRepo, s.msgRepo, s.block, userID)
+	if err != nil {
+		// 查不到就不推，避免把红点清成0
+		log.Printf("query unread of user %d failed:%v", userID, err)
+		return
+	}
+	if err := s.hub.Publish(ctx, []uint{userID}, realtime.Event{Type: EventUnread, Data: counts}); err != nil {
+		log.Printf("realtime publish unread failed:%v", err)
+	}
+}
+
+// 订阅其他实例发出的推送
+func (s *RealtimeService) StartRealtimeHub(ctx context.Context) {
+	s.hub.Run(ctx)
+}
+
+// http server停机时断开所有长连接
+func (s *RealtimeService) Shutdown() {
+	s.hub.CloseAll()
+}
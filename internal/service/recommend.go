@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+type RecommendService struct {
+	repo     *repository.RecommendRepository
+	postRepo *repository.PostRepository
+	block    *BlockService
+	rdb      *redis.Client
+	sf       singleflight.Group
+}
+
+func NewRecommendService(repo *repository.RecommendRepository, post *repository.PostRepository, block *BlockService, rdb *redis.Client) *RecommendService {
+	return &RecommendService{repo: repo, postRepo: post, block: block, rdb: rdb}
+}
+
+const (
+	RecommendKeyPrefix = "rec:user:"
+	// 在线每次多取几倍候选，过滤和打散后仍能凑满一页
+	recommendOverFetch = 4
+	recommendMaxTopics = 10
+)
+
+func recommendKey(userID uint) string {
+	return fmt.Sprintf("%s%d", RecommendKeyPrefix, userID)
+}
+
+// 把一路召回的得分按最大值归一化到[0,1]后乘权重累加
+func addRecall(scores map[uint]float64, recall []repository.PostScore, weight float64) {
+	var max float64
+	for _, r := range recall {
+		if r.Score > max {
+			max = r.Score
+		}
+	}
+	if max <= 0 || weight == 0 {
+		return
+	}
+	for _, r := range recall {
+		scores[r.PostID] += r.Score / max * weight
+	}
+}
+
+// 热门召回：优先用redis热榜，没有时退回数据库
+func (s *RecommendService) hotRecall(ctx context.Context, tx *gorm.DB, limit int) ([]repository.PostScore, error) {
+	items, err := s.rdb.ZRevRangeWithScores(ctx, RankHotKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		log.Printf("redis error:%v", err)
+	}
+	recall := make([]repository.PostScore, 0, limit)
+	if len(items) > 0 {
+		for _, z := range items {
+			recall = append(recall, repository.PostScore{PostID: uint(memberID(z)), Score: z.Score})
+		}
+		return recall, nil
+	}
+	posts, err := s.postRepo.GetLeaderboard(ctx, tx, 0, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		recall = append(recall, repository.PostScore{PostID: p.ID, Score: p.Hotscore})
+	}
+	return recall, nil
+}
+
+// 离线为用户生成候选：热门、感兴趣话题、协同过滤三路召回加权合并，去掉已看过的，写入redis
+func (s *RecommendService) BuildCandidates(ctx context.Context, tx *gorm.DB, userID uint) error {
+	cfg := config.Setting.Recommend
+	since := time.Now().AddDate(0, 0, -cfg.WindowDays)
+	scores := make(map[uint]float64)
+
+	hot, err := s.hotRecall(ctx, tx, cfg.CandidateSize)
+	if err != nil {
+		return err
+	}
+	addRecall(scores, hot, cfg.HotWeight)
+
+	topics, err := s.repo.UserTopicWeights(ctx, tx, userID, since, recommendMaxTopics)
+	if err != nil {
+		return err
+	}
+	if len(topics) > 0 {
+		topicIDs := make([]uint, 0, len(topics))
+		for _, t := range topics {
+			topicIDs = append(topicIDs, t.TopicID)
+		}
+		candidateSince := time.Now().AddDate(0, 0, -config.Setting.Rank.CandidateDays)
+		byTopic, err := s.repo.HotPostsByTopics(ctx, tx, topicIDs, candidateSince, cfg.CandidateSize)
+		if err != nil {
+			return err
+		}
+		addRecall(scores, byTopic, cfg.TopicWeight)
+	}
+
+	coLiked, err := s.repo.CoLikedPosts(ctx, tx, userID, since, cfg.CFRecentLikes, cfg.CFMaxNeighbors, cfg.CandidateSize)
+	if err != nil {
+		return err
+	}
+	addRecall(scores, coLiked, cfg.CFWeight)
+
+	postIDs := make([]uint, 0, len(scores))
+	for id := range scores {
+		postIDs = append(postIDs, id)
+	}
+	seen, err := s.repo.SeenPostIDs(ctx, tx, userID, postIDs)
+	if err != nil {
+		return err
+	}
+	members := make([]*redis.Z, 0, len(scores))
+	for id, score := range scores {
+		if !seen[id] {
+			members = append(members, &redis.Z{Score: score, Member: id})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Score > members[j].Score
+	})
+	if len(members) > cfg.CandidateSize {
+		members = members[:cfg.CandidateSize]
+	}
+	key := recommendKey(userID)
+	tmpKey := key + ":tmp"
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, tmpKey)
+	if len(members) > 0 {
+		pipe.ZAdd(ctx, tmpKey, members...)
+		pipe.Rename(ctx, tmpKey, key)
+		pipe.Expire(ctx, key, time.Duration(cfg.CandidateTTLHours)*time.Hour)
+	} else {
+		pipe.Del(ctx, key)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 后台定时为活跃用户重建候选
+func (s *RecommendService) StartRecommendWorker(ctx context.Context) {
+	cfg := config.Setting.Recommend
+	interval := time.Duration(cfg.RefreshMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 30 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		since := time.Now().AddDate(0, 0, -cfg.ActiveDays)
+		userIDs, err := s.repo.ActiveUserIDs(ctx, nil, since, cfg.MaxUsersPerRound)
+		if err != nil {
+			log.Printf("load active users failed:%v", err)
+		}
+		for _, uid := range userIDs {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := s.BuildCandidates(ctx, nil, uid); err != nil {
+				log.Printf("build recommend candidates for %d failed:%v", uid, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 在线取一页推荐：读候选、过滤、按新鲜度重排并打散作者，返回的文章从候选中移除，下次刷新得到新内容
+func (s *RecommendService) GetRecommend(ctx context.Context, tx *gorm.DB, userID uint, pageSize int) ([]model.Post, error) {
+	key := recommendKey(userID)
+	fetch := int64(pageSize * recommendOverFetch)
+	items, err := s.rdb.ZRevRangeWithScores(ctx, key, 0, fetch-1).Result()
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	// 新用户或候选已用完时当场生成一次
+	if len(items) == 0 {
+		_, err, _ := s.sf.Do(key, func() (interface{}, error) {
+			return nil, s.BuildCandidates(ctx, tx, userID)
+		})
+		if err != nil {
+			log.Printf("build recommend candidates failed:%v", err)
+			return nil, e.ErrServer
+		}
+		items, err = s.rdb.ZRevRangeWithScores(ctx, key, 0, fetch-1).Result()
+		if err != nil {
+			return nil, e.ErrServer
+		}
+	}
+	if len(items) == 0 {
+		return []model.Post{}, nil
+	}
+	candidateIDs := make([]string, 0, len(items))
+	ids := make([]uint, 0, len(items))
+	baseScores := make(map[uint]float64, len(items))
+	for _, z := range items {
+		id := uint(memberID(z))
+		candidateIDs = append(candidateIDs, strconv.FormatUint(uint64(id), 10))
+		ids = append(ids, id)
+		baseScores[id] = z.Score
+	}
+	posts, err := s.postRepo.FindPostsByIDs(ctx, tx, candidateIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	// 候选生成之后才读过的也要排除
+	seen, err := s.repo.SeenPostIDs(ctx, tx, userID, ids)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	hidden, err := s.block.hiddenUsers(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	visible := make([]model.Post, 0, len(posts))
+	for _, p := range posts {
+		if seen[p.ID] || hidden[p.AuthorID] || !s.visibleTo(p, userID) {
+			continue
+		}
+		visible = append(visible, p)
+	}
+	result := rerankPosts(visible, baseScores, pageSize)
+	// 本次返回的和已失效的候选都移除；没返回的有效候选留到下一次
+	served := make(map[uint]bool, len(result))
+	for _, p := range result {
+		served[p.ID] = true
+	}
+	valid := make(map[uint]bool, len(visible))
+	for _, p := range visible {
+		valid[p.ID] = true
+	}
+	var remove []interface{}
+	for _, id := range ids {
+		if served[id] || !valid[id] {
+			remove = append(remove, id)
+		}
+	}
+	if len(remove) > 0 {
+		if err := s.rdb.ZRem(ctx, key, remove...).Err(); err != nil {
+			log.Printf("failed to remove served candidates:%v", err)
+		}
+	}
+	return result, nil
+}
+
+// 推荐里不出现被封禁作者的文章、私密账号的文章和自己的文章
+func (s *RecommendService) visibleTo(p model.Post, userID uint) bool {
+	return p.Author.Status != 0 && !p.Author.IsPrivate && p.AuthorID != userID
+}
+
+// 在线重排：离线分数乘以按发布时间衰减的新鲜度，再按作者打散，每个作者一页最多出现MaxPerAuthor次
+func rerankPosts(posts []model.Post, baseScores map[uint]float64, limit int) []model.Post {
+	cfg := config.Setting.Recommend
+	halfLife := cfg.FreshnessHalfLifeHours
+	if halfLife <= 0 {
+		halfLife = 72
+	}
+	now := time.Now()
+	final := make(map[uint]float64, len(posts))
+	for _, p := range posts {
+		age := now.Sub(p.CreatedAt).Hours()
+		final[p.ID] = baseScores[p.ID] * math.Pow(0.5, age/halfLife)
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return final[posts[i].ID] > final[posts[j].ID]
+	})
+	result := make([]model.Post, 0, limit)
+	perAuthor := make(map[uint]int)
+	var skipped []model.Post
+	for _, p := range posts {
+		if len(result) >= limit {
+			break
+		}
+		if cfg.MaxPerAuthor > 0 && perAuthor[p.AuthorID] >= cfg.MaxPerAuthor {
+			skipped = append(skipped, p)
+			continue
+		}
+		perAuthor[p.AuthorID]++
+		result = append(result, p)
+	}
+	// 候选太集中时用被打散掉的补满
+	for _, p := range skipped {
+		if len(result) >= limit {
+			break
+		}
+		result = append(result, p)
+	}
+	return result
+}
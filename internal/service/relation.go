@@ -3,72 +3,275 @@ package service
 import (
 	"context"
 	"errors"
+	"go-zhihu/internal/job"
 	"go-zhihu/internal/model"
 	"go-zhihu/internal/repository"
 	"go-zhihu/pkg/e"
-	"log"
 
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
 type RelationService struct {
 	repo     *repository.RelationRepository
 	userRepo *repository.UserRepository
-	feed     *FeedService
+	reqRepo  *repository.FollowRequestRepository
+	jobs     *job.Queue
 	notify   *NotificationService
+	block    *BlockService
+	rdb      *redis.Client
 }
 
-func NewRelationService(repo *repository.RelationRepository, user *repository.UserRepository, feed *FeedService, notify *NotificationService) *RelationService {
-	return &RelationService{repo: repo, userRepo: user, feed: feed, notify: notify}
+func NewRelationService(repo *repository.RelationRepository, user *repository.UserRepository, req *repository.FollowRequestRepository, jobs *job.Queue, notify *NotificationService, block *BlockService, rdb *redis.Client) *RelationService {
+	return &RelationService{repo: repo, userRepo: user, reqRepo: req, jobs: jobs, notify: notify, block: block, rdb: rdb}
 }
-func (s *RelationService) FollowUser(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) error {
+
+// 批量查询关系状态时一次最多的用户数
+const maxRelationStatusIDs = 100
+
+// 关注操作的结果
+const (
+	FollowStatusFollowing = "following"
+	FollowStatusRequested = "requested"
+)
+
+// 关注公开账号直接生效，关注私密账号生成一条待审核的请求
+func (s *RelationService) FollowUser(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) (string, error) {
 	if followerID == followeeID {
-		return e.ErrSelfAction
+		return "", e.ErrSelfAction
+	}
+	if err := s.block.checkBlocked(ctx, tx, followerID, followeeID); err != nil {
+		return "", err
 	}
 	// 检查是否已关注（可选，防止重复关注）
 	isFollowing, err := s.repo.IsFollowing(ctx, tx, followerID, followeeID)
 	if err != nil {
-		return e.ErrServer
+		return "", e.ErrServer
 	}
 	if isFollowing {
-		return e.ErrAlreadyFollowing
+		return "", e.ErrAlreadyFollowing
+	}
+	private, err := s.userRepo.IsPrivate(ctx, tx, followeeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", e.ErrUserNotFoundInstance
+		}
+		return "", e.ErrServer
+	}
+	if private {
+		created, err := s.reqRepo.Create(ctx, tx, followerID, followeeID)
+		if err != nil {
+			return "", e.ErrServer
+		}
+		// 重复申请不重复通知
+		if created {
+			s.notify.sendNotification(ctx, tx, followeeID, followerID, model.NotifyTypeFollowRequest, "请求关注你", model.TargetTypeUser, followerID)
+		}
+		return FollowStatusRequested, nil
+	}
+	if err := s.follow(ctx, tx, followerID, followeeID); err != nil {
+		return "", err
 	}
-	// 执行关注
-	err = s.repo.Follow(ctx, tx, followerID, followeeID)
+	s.notify.sendNotification(ctx, tx, followeeID, followerID, model.NotifyTypeFollow, "关注了你", model.TargetTypeUser, followeeID)
+	return FollowStatusFollowing, nil
+}
+
+// 建立关注关系并回填时间线
+func (s *RelationService) follow(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) error {
+	err := s.repo.Follow(ctx, tx, followerID, followeeID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			return e.ErrAlreadyFollowing
 		}
-		s.notify.sendNotification(ctx, tx, followeeID, followerID, model.NotifyTypeFollow, "关注了你", 0)
 		return e.ErrServer
 	}
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("panic in pushPostsToFeed: %v", r)
-			}
-		}()
-		ctxfn := context.Background()
-		s.feed.PushPostsToFeed(ctxfn, nil, followerID, followeeID)
-	}()
-	s.notify.sendNotification(ctx, tx, followeeID, followerID, model.NotifyTypeFollow, "关注了你", 0)
+	clearProfileCache(ctx, s.rdb, followerID, followeeID)
+	dispatch(ctx, s.jobs, JobFeedBackfill, relationJob{FollowerID: followerID, FolloweeID: followeeID})
 	return nil
 }
+
+// 取消关注，还在审核中的请求一并撤回
 func (s *RelationService) UnfollowUser(ctx context.Context, tx *gorm.DB, followerID, followeeID uint) error {
-	if err := s.repo.Unfollow(ctx, tx, followerID, followeeID); err != nil {
+	if _, err := s.reqRepo.Delete(ctx, tx, followerID, followeeID); err != nil {
 		return e.ErrServer
 	}
+	removed, err := s.repo.Unfollow(ctx, tx, followerID, followeeID)
+	if err != nil {
+		return e.ErrServer
+	}
+	if removed {
+		clearProfileCache(ctx, s.rdb, followerID, followeeID)
+		dispatch(ctx, s.jobs, JobFeedRemoveAuthor, relationJob{FollowerID: followerID, FolloweeID: followeeID})
+	}
 	return nil
 }
 
-// 获取当前用户的粉丝列表
-func (s *RelationService) GetFollowers(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]model.User, error) {
+// 获取当前用户的粉丝列表，mutual表示我也关注了对方
+func (s *RelationService) GetFollowers(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]FollowUserVO, error) {
 	offset := (page - 1) * pageSize
-	return s.repo.GetFollowers(ctx, tx, userID, offset, pageSize)
+	users, err := s.repo.GetFollowers(ctx, tx, userID, offset, pageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return s.withMutual(ctx, tx, users, func(ids []uint) ([]uint, error) {
+		return s.repo.FollowingAmong(ctx, tx, userID, ids)
+	})
 }
 
-// 获取当前用户的关注列表
-func (s *RelationService) GetFollowees(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]model.User, error) {
+// 获取当前用户的关注列表，mutual表示对方也关注了我
+func (s *RelationService) GetFollowees(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]FollowUserVO, error) {
 	offset := (page - 1) * pageSize
-	return s.repo.GetFollowees(ctx, tx, userID, offset, pageSize)
+	users, err := s.repo.GetFollowees(ctx, tx, userID, offset, pageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return s.withMutual(ctx, tx, users, func(ids []uint) ([]uint, error) {
+		return s.repo.FollowersAmong(ctx, tx, userID, ids)
+	})
+}
+
+// 一次查出列表里哪些人是反向关注的，避免逐个查询
+func (s *RelationService) withMutual(ctx context.Context, tx *gorm.DB, users []model.User, reverse func(ids []uint) ([]uint, error)) ([]FollowUserVO, error) {
+	list := make([]FollowUserVO, 0, len(users))
+	if len(users) == 0 {
+		return list, nil
+	}
+	ids := make([]uint, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	reverseIDs, err := reverse(ids)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	mutual := make(map[uint]bool, len(reverseIDs))
+	for _, id := range reverseIDs {
+		mutual[id] = true
+	}
+	for i := range users {
+		list = append(list, FollowUserVO{UserProfileVO: *newUserProfileVO(&users[i]), Mutual: mutual[users[i].ID]})
+	}
+	return list, nil
+}
+
+// 批量查询当前用户与一组用户的关系
+func (s *RelationService) GetRelationStatus(ctx context.Context, tx *gorm.DB, userID uint, targetIDs []uint) ([]RelationStatusVO, error) {
+	if len(targetIDs) == 0 || len(targetIDs) > maxRelationStatusIDs {
+		return nil, e.ErrInvalidArgs
+	}
+	following, err := s.repo.FollowingAmong(ctx, tx, userID, targetIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	followedBy, err := s.repo.FollowersAmong(ctx, tx, userID, targetIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	followingSet := make(map[uint]bool, len(following))
+	for _, id := range following {
+		followingSet[id] = true
+	}
+	followedBySet := make(map[uint]bool, len(followedBy))
+	for _, id := range followedBy {
+		followedBySet[id] = true
+	}
+	requested, err := s.reqRepo.RequestedAmong(ctx, tx, userID, targetIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	requestedSet := make(map[uint]bool, len(requested))
+	for _, id := range requested {
+		requestedSet[id] = true
+	}
+	list := make([]RelationStatusVO, 0, len(targetIDs))
+	for _, id := range targetIDs {
+		list = append(list, RelationStatusVO{
+			UserID:     id,
+			Following:  followingSet[id],
+			FollowedBy: followedBySet[id],
+			Mutual:     followingSet[id] && followedBySet[id],
+			Requested:  requestedSet[id],
+		})
+	}
+	return list, nil
+}
+
+// 按关注关系重算计数，修复历史数据用
+func (s *RelationService) RecountFollows(ctx context.Context, tx *gorm.DB) error {
+	return s.repo.RecountFollows(ctx, tx)
+}
+
+// 待我审核的关注请求
+func (s *RelationService) ListFollowRequests(ctx context.Context, tx *gorm.DB, userID uint, page, pageSize int) ([]UserProfileVO, error) {
+	offset := (page - 1) * pageSize
+	users, err := s.reqRepo.ListPending(ctx, tx, userID, offset, pageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	list := make([]UserProfileVO, 0, len(users))
+	for i := range users {
+		list = append(list, *newUserProfileVO(&users[i]))
+	}
+	return list, nil
+}
+
+// 通过关注请求，requesterID是申请人
+func (s *RelationService) ApproveFollowRequest(ctx context.Context, tx *gorm.DB, userID, requesterID uint) error {
+	exists, err := s.reqRepo.Exists(ctx, tx, requesterID, userID)
+	if err != nil {
+		return e.ErrServer
+	}
+	if !exists {
+		return e.ErrRequestNotFound
+	}
+	if err := s.block.checkBlocked(ctx, tx, userID, requesterID); err != nil {
+		return err
+	}
+	if err := s.approve(ctx, tx, userID, requesterID); err != nil {
+		return err
+	}
+	s.notify.sendNotification(ctx, tx, requesterID, userID, model.NotifyTypeFollow, "通过了你的关注请求", model.TargetTypeUser, userID)
+	return nil
+}
+func (s *RelationService) approve(ctx context.Context, tx *gorm.DB, userID, requesterID uint) error {
+	if err := s.follow(ctx, tx, requesterID, userID); err != nil && !errors.Is(err, e.ErrAlreadyFollowing) {
+		return err
+	}
+	if _, err := s.reqRepo.Delete(ctx, tx, requesterID, userID); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 拒绝关注请求，不通知申请人
+func (s *RelationService) RejectFollowRequest(ctx context.Context, tx *gorm.DB, userID, requesterID uint) error {
+	deleted, err := s.reqRepo.Delete(ctx, tx, requesterID, userID)
+	if err != nil {
+		return e.ErrServer
+	}
+	if !deleted {
+		return e.ErrRequestNotFound
+	}
+	return nil
+}
+
+// 切换私密账号，改回公开时自动通过所有待审核的请求
+func (s *RelationService) SetPrivate(ctx context.Context, tx *gorm.DB, userID uint, private bool) error {
+	if err := s.userRepo.SetPrivate(ctx, tx, userID, private); err != nil {
+		return e.ErrServer
+	}
+	clearProfileCache(ctx, s.rdb, userID)
+	if private {
+		return nil
+	}
+	requesterIDs, err := s.reqRepo.PendingRequesterIDs(ctx, tx, userID)
+	if err != nil {
+		return e.ErrServer
+	}
+	for _, id := range requesterIDs {
+		if err := s.approve(ctx, tx, userID, id); err != nil {
+			return err
+		}
+	}
+	return nil
 }
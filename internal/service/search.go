@@ -0,0 +1,418 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/internal/search"
+	"go-zhihu/pkg/e"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// 搜索：引擎只负责召回和相关度排序，状态、拉黑、私密账号等过滤在数据库里完成
+type SearchService struct {
+	engine    search.Engine
+	postRepo  *repository.PostRepository
+	userRepo  *repository.UserRepository
+	topicRepo *repository.TopicRepository
+	logRepo   *repository.SearchLogRepository
+	block     *BlockService
+	rdb       *redis.Client
+	sf        singleflight.Group
+	// 索引存在进程内的引擎，更新要广播给所有实例
+	fanout   bool
+	instance string
+}
+
+func NewSearchService(engine search.Engine, post *repository.PostRepository, user *repository.UserRepository, topic *repository.TopicRepository, searchLog *repository.SearchLogRepository, block *BlockService, rdb *redis.Client) *SearchService {
+	host, _ := os.Hostname()
+	return &SearchService{
+		engine:    engine,
+		postRepo:  post,
+		userRepo:  user,
+		topicRepo: topic,
+		logRepo:   searchLog,
+		block:     block,
+		rdb:       rdb,
+		fanout:    engine.Name() == search.EngineMemory,
+		instance:  fmt.Sprintf("%s-%d", host, os.Getpid()),
+	}
+}
+
+// 索引任务只由一个实例消费，memory引擎的更新经这个频道广播给其他实例
+const searchIndexChannel = "search:index"
+
+type searchIndexEvent struct {
+	Origin string `json:"origin"`
+	PostID uint   `json:"post_id"`
+}
+
+// 按配置选择搜索引擎，未知的配置退回mysql
+func newSearchEngine(cfg config.SearchConfig, post *repository.PostRepository) search.Engine {
+	switch cfg.Engine {
+	case search.EngineMemory:
+		return search.NewMemoryEngine(cfg.TitleBoost)
+	case search.EngineMySQL, "":
+	default:
+		log.Printf("unknown search engine %q, using mysql", cfg.Engine)
+	}
+	return search.NewMySQLEngine(post)
+}
+
+// 统一搜索的类型和排序方式
+const (
+	SearchTypeAll      = "all"
+	SearchTypePost     = "post"
+	SearchTypeQuestion = "question"
+	SearchTypeUser     = "user"
+	SearchTypeTopic    = "topic"
+
+	SearchSortRelevance = "relevance"
+	SearchSortTime      = "time"
+	SearchSortHot       = "hot"
+)
+
+const (
+	// 综合搜索时每类结果取的条数
+	searchAllGroupSize = 5
+	// 摘要长度(字)
+	searchSnippetWidth = 120
+)
+
+// 统一搜索的参数，作者、时间和文章类型只对文章和问题生效
+type SearchRequest struct {
+	Keyword  string
+	Type     string
+	Sort     string
+	AuthorID uint
+	PostType int
+	Start    time.Time
+	End      time.Time
+	ViewerID uint
+	Page     int
+	PageSize int
+}
+
+// 检查并补齐默认值
+func (r *SearchRequest) normalize() error {
+	r.Keyword = strings.TrimSpace(r.Keyword)
+	if r.Type == "" {
+		r.Type = SearchTypeAll
+	}
+	if r.Sort == "" {
+		r.Sort = SearchSortRelevance
+	}
+	switch r.Type {
+	case SearchTypeAll, SearchTypePost, SearchTypeUser, SearchTypeTopic:
+	case SearchTypeQuestion:
+		r.PostType = 2
+	default:
+		return e.ErrInvalidArgs
+	}
+	switch r.Sort {
+	case SearchSortRelevance, SearchSortTime, SearchSortHot:
+	default:
+		return e.ErrInvalidArgs
+	}
+	if r.PostType != 0 && r.PostType != 1 && r.PostType != 2 {
+		return e.ErrInvalidArgs
+	}
+	if r.Page < 1 || r.PageSize < 1 {
+		return e.ErrInvalidArgs
+	}
+	if !r.Start.IsZero() && !r.End.IsZero() && !r.Start.Before(r.End) {
+		return e.ErrInvalidArgs
+	}
+	return nil
+}
+
+// 登录用户搜不到有拉黑关系的人的文章，也搜不到没关注的私密账号的文章
+func (s *SearchService) SearchPosts(ctx context.Context, tx *gorm.DB, keyword string, viewerID uint, page, pageSize int) ([]model.Post, error) {
+	req := SearchRequest{Keyword: keyword, Type: SearchTypePost, ViewerID: viewerID, Page: page, PageSize: pageSize}
+	if err := req.normalize(); err != nil {
+		return nil, err
+	}
+	if req.Keyword == "" {
+		return []model.Post{}, nil
+	}
+	if req.Page == 1 {
+		s.recordQuery(ctx, tx, req.Keyword, req.Type, req.ViewerID)
+	}
+	return s.loadPostPage(ctx, tx, &req)
+}
+
+// 统一搜索，type为all时每类各取前几条，分页参数不生效
+func (s *SearchService) Search(ctx context.Context, tx *gorm.DB, req SearchRequest) (*SearchResultVO, error) {
+	if err := req.normalize(); err != nil {
+		return nil, err
+	}
+	result := &SearchResultVO{Type: req.Type, Posts: []SearchPostVO{}, Questions: []SearchPostVO{}, Users: []SearchUserVO{}, Topics: []SearchTopicVO{}}
+	if req.Keyword == "" {
+		return result, nil
+	}
+	if req.Page == 1 {
+		s.recordQuery(ctx, tx, req.Keyword, req.Type, req.ViewerID)
+	}
+	var err error
+	switch req.Type {
+	case SearchTypePost, SearchTypeQuestion:
+		result.Posts, err = s.searchPostVOs(ctx, tx, &req)
+	case SearchTypeUser:
+		result.Users, err = s.searchUsers(ctx, tx, &req)
+	case SearchTypeTopic:
+		result.Topics, err = s.searchTopics(ctx, tx, &req)
+	case SearchTypeAll:
+		group := req
+		group.Page, group.PageSize = 1, searchAllGroupSize
+		articles, questions := group, group
+		if group.PostType == 0 || group.PostType == 1 {
+			articles.PostType = 1
+			if result.Posts, err = s.searchPostVOs(ctx, tx, &articles); err != nil {
+				return nil, err
+			}
+		}
+		if group.PostType == 0 || group.PostType == 2 {
+			questions.PostType = 2
+			if result.Questions, err = s.searchPostVOs(ctx, tx, &questions); err != nil {
+				return nil, err
+			}
+		}
+		if result.Users, err = s.searchUsers(ctx, tx, &group); err != nil {
+			return nil, err
+		}
+		result.Topics, err = s.searchTopics(ctx, tx, &group)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 引擎召回后在数据库里过滤，再按要求的方式排序，返回一页完整的文章
+func (s *SearchService) loadPostPage(ctx context.Context, tx *gorm.DB, req *SearchRequest) ([]model.Post, error) {
+	hits, err := s.engine.Search(ctx, search.Query{Keyword: req.Keyword, Limit: config.Setting.Search.MaxHits})
+	if err != nil {
+		log.Printf("%s search failed:%v", s.engine.Name(), err)
+		return nil, e.ErrServer
+	}
+	if len(hits) == 0 {
+		return []model.Post{}, nil
+	}
+	hidden, err := s.block.hiddenUserIDs(ctx, tx, req.ViewerID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	ids := make([]uint, 0, len(hits))
+	rank := make(map[uint]int, len(hits))
+	for i, h := range hits {
+		ids = append(ids, h.ID)
+		rank[h.ID] = i
+	}
+	filter := repository.PostSearchFilter{AuthorID: req.AuthorID, PostType: req.PostType, Start: req.Start, End: req.End}
+	candidates, err := s.postRepo.FilterSearchable(ctx, tx, ids, req.ViewerID, hidden, filter)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch req.Sort {
+		case SearchSortTime:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.After(b.CreatedAt)
+			}
+		case SearchSortHot:
+			if a.Hotscore != b.Hotscore {
+				return a.Hotscore > b.Hotscore
+			}
+		}
+		return rank[a.ID] < rank[b.ID]
+	})
+	offset := (req.Page - 1) * req.PageSize
+	if offset >= len(candidates) {
+		return []model.Post{}, nil
+	}
+	pageIDs := make([]string, 0, req.PageSize)
+	for _, p := range candidates[offset:min(offset+req.PageSize, len(candidates))] {
+		pageIDs = append(pageIDs, strconv.FormatUint(uint64(p.ID), 10))
+	}
+	posts, err := s.postRepo.FindPostsByIDs(ctx, tx, pageIDs)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return sortPostsByIDs(posts, pageIDs), nil
+}
+func (s *SearchService) searchPostVOs(ctx context.Context, tx *gorm.DB, req *SearchRequest) ([]SearchPostVO, error) {
+	posts, err := s.loadPostPage(ctx, tx, req)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]SearchPostVO, 0, len(posts))
+	for i := range posts {
+		p := &posts[i]
+		list = append(list, SearchPostVO{
+			ID:             p.ID,
+			Type:           p.Type,
+			Title:          p.Title,
+			TitleHighlight: search.Highlight(p.Title, req.Keyword, 0),
+			Snippet:        search.Highlight(p.Content, req.Keyword, searchSnippetWidth),
+			Author:         *newUserProfileVO(&p.Author),
+			HotScore:       p.Hotscore,
+			ViewCount:      p.ViewCount,
+			CreatedAt:      p.CreatedAt,
+		})
+	}
+	return list, nil
+}
+
+// 搜人时同样排除有拉黑关系的人
+func (s *SearchService) searchUsers(ctx context.Context, tx *gorm.DB, req *SearchRequest) ([]SearchUserVO, error) {
+	hidden, err := s.block.hiddenUserIDs(ctx, tx, req.ViewerID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	offset := (req.Page - 1) * req.PageSize
+	users, err := s.userRepo.SearchUsers(ctx, tx, req.Keyword, hidden, offset, req.PageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	list := make([]SearchUserVO, 0, len(users))
+	for i := range users {
+		list = append(list, SearchUserVO{
+			UserProfileVO:     *newUserProfileVO(&users[i]),
+			UsernameHighlight: search.Highlight(users[i].Username, req.Keyword, 0),
+			BioHighlight:      search.Highlight(users[i].Bio, req.Keyword, searchSnippetWidth),
+		})
+	}
+	return list, nil
+}
+func (s *SearchService) searchTopics(ctx context.Context, tx *gorm.DB, req *SearchRequest) ([]SearchTopicVO, error) {
+	offset := (req.Page - 1) * req.PageSize
+	topics, err := s.topicRepo.SearchTopics(ctx, tx, req.Keyword, offset, req.PageSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	list := make([]SearchTopicVO, 0, len(topics))
+	for _, t := range topics {
+		list = append(list, SearchTopicVO{
+			ID:                   t.ID,
+			Name:                 t.Name,
+			Description:          t.Description,
+			PostCount:            t.PostCount,
+			NameHighlight:        search.Highlight(t.Name, req.Keyword, 0),
+			DescriptionHighlight: search.Highlight(t.Description, req.Keyword, searchSnippetWidth),
+		})
+	}
+	return list, nil
+}
+
+// 索引任务：更新本实例的索引，memory引擎再广播给其他实例，广播失败时任务重试
+func (s *SearchService) IndexPost(ctx context.Context, tx *gorm.DB, postID uint) error {
+	post, err := s.applyIndex(ctx, tx, postID)
+	if err != nil {
+		return err
+	}
+	if s.fanout {
+		raw, _ := json.Marshal(searchIndexEvent{Origin: s.instance, PostID: postID})
+		if err := s.rdb.Publish(ctx, searchIndexChannel, raw).Err(); err != nil {
+			return err
+		}
+	}
+	if post != nil {
+		s.addPostCompletion(ctx, post)
+	}
+	return nil
+}
+
+// 文章已发布时写入索引并返回文章，草稿、删除或不存在时从索引移除
+func (s *SearchService) applyIndex(ctx context.Context, tx *gorm.DB, postID uint) (*model.Post, error) {
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, s.engine.Delete(ctx, postID)
+		}
+		return nil, err
+	}
+	if post.Status != model.PostStatusPublished {
+		return nil, s.engine.Delete(ctx, postID)
+	}
+	if err := s.engine.Index(ctx, search.Document{ID: post.ID, Title: post.Title, Content: post.Content}); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// 从数据库全量建索引
+func (s *SearchService) RebuildIndex(ctx context.Context, tx *gorm.DB) error {
+	load := func(ctx context.Context, afterID uint, limit int) ([]search.Document, error) {
+		posts, err := s.postRepo.ListPublishedAfter(ctx, tx, afterID, limit)
+		if err != nil {
+			return nil, err
+		}
+		docs := make([]search.Document, 0, len(posts))
+		for _, p := range posts {
+			docs = append(docs, search.Document{ID: p.ID, Title: p.Title, Content: p.Content})
+		}
+		return docs, nil
+	}
+	return s.engine.Rebuild(ctx, load, config.Setting.Search.RebuildBatchSize)
+}
+
+// 启动时建一次索引，之后靠索引任务增量更新；memory引擎先订阅广播再建索引，
+// 建索引期间其他实例的更新照常应用，不会漏掉
+func (s *SearchService) StartSearchIndexer(ctx context.Context) {
+	if !s.fanout {
+		s.buildIndex(ctx)
+		return
+	}
+	pubsub := s.rdb.Subscribe(ctx, searchIndexChannel)
+	defer func() { _ = pubsub.Close() }()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		log.Printf("subscribe %s failed:%v", searchIndexChannel, err)
+	}
+	ch := pubsub.Channel()
+	built := make(chan struct{})
+	go func() {
+		defer close(built)
+		s.buildIndex(ctx)
+	}()
+	defer func() { <-built }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var event searchIndexEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("decode search index event failed:%v", err)
+				continue
+			}
+			if event.Origin == s.instance {
+				continue
+			}
+			if _, err := s.applyIndex(ctx, nil, event.PostID); err != nil {
+				log.Printf("apply search index of post %d failed:%v", event.PostID, err)
+			}
+		}
+	}
+}
+func (s *SearchService) buildIndex(ctx context.Context) {
+	if err := s.RebuildIndex(ctx, nil); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("build %s search index failed:%v", s.engine.Name(), err)
+	}
+}
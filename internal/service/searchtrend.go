@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-zhihu/config"
+	"go-zhihu/internal/model"
+	"go-zhihu/pkg/e"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+const (
+	SearchTrendingKey         = "search:trending"
+	searchQueryBucketPrefix   = "search:query:h:"
+	searchQuerySeenPrefix     = "search:query:seen:"
+	searchSuppressedKey       = "search:trending:suppressed"
+	searchCompletionGenKey    = "search:ac:gen"
+	searchCompletionSeqKey    = "search:ac:seq"
+	searchCompletionKeyPrefix = "search:ac:"
+	// 搜索词最长保留的字数
+	maxSearchQueryLen = 50
+	maxTrendingSize   = 50
+	maxSuggestSize    = 20
+)
+
+// 联想词的来源，存在zset成员的前缀里
+const (
+	CompletionPost  = "post"
+	CompletionTopic = "topic"
+	CompletionUser  = "user"
+)
+
+var completionTags = map[string]string{CompletionPost: "p:", CompletionTopic: "t:", CompletionUser: "u:"}
+
+// 搜索词归一化：去掉首尾和多余的空白、转小写、截断
+func normalizeQuery(q string) string {
+	q = strings.ToLower(strings.Join(strings.Fields(q), " "))
+	if utf8.RuneCountInString(q) > maxSearchQueryLen {
+		q = string([]rune(q)[:maxSearchQueryLen])
+	}
+	return q
+}
+func searchBucketKey(t time.Time) string {
+	return searchQueryBucketPrefix + t.Format("2006010215")
+}
+
+// 记录一次搜索：写搜索日志，并计入当前小时的热搜分桶；登录用户同一个词一小时内只计一次
+func (s *SearchService) recordQuery(ctx context.Context, tx *gorm.DB, keyword, searchType string, viewerID uint) {
+	query := normalizeQuery(keyword)
+	if query == "" {
+		return
+	}
+	if err := s.logRepo.Create(ctx, tx, &model.SearchLog{UserID: viewerID, Query: query, Type: searchType}); err != nil {
+		log.Printf("failed to log search query:%v", err)
+	}
+	if viewerID != 0 {
+		seenKey := searchQuerySeenPrefix + strconv.FormatUint(uint64(viewerID), 10) + ":" + query
+		first, err := s.rdb.SetNX(ctx, seenKey, 1, time.Hour).Result()
+		if err != nil {
+			log.Printf("redis error:%v", err)
+			return
+		}
+		if !first {
+			return
+		}
+	}
+	bucket := searchBucketKey(time.Now())
+	pipe := s.rdb.Pipeline()
+	pipe.ZIncrBy(ctx, bucket, 1, query)
+	pipe.Expire(ctx, bucket, time.Duration(config.Setting.Search.TrendingWindowHours+1)*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("failed to record search query:%v", err)
+	}
+}
+
+// 合并最近若干小时的分桶，越早的权重越低(按半衰期指数衰减)，结果缓存一小段时间
+func (s *SearchService) ensureTrending(ctx context.Context) error {
+	exists, err := s.rdb.Exists(ctx, SearchTrendingKey).Result()
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+	_, err, _ = s.sf.Do(SearchTrendingKey, func() (interface{}, error) {
+		cfg := config.Setting.Search
+		now := time.Now()
+		keys := make([]string, 0, cfg.TrendingWindowHours)
+		weights := make([]float64, 0, cfg.TrendingWindowHours)
+		for i := 0; i < cfg.TrendingWindowHours; i++ {
+			keys = append(keys, searchBucketKey(now.Add(-time.Duration(i)*time.Hour)))
+			weights = append(weights, math.Pow(0.5, float64(i)/cfg.TrendingHalfLifeHours))
+		}
+		pipe := s.rdb.TxPipeline()
+		pipe.ZUnionStore(ctx, SearchTrendingKey, &redis.ZStore{Keys: keys, Weights: weights, Aggregate: "SUM"})
+		pipe.ZRemRangeByScore(ctx, SearchTrendingKey, "-inf", "("+strconv.FormatFloat(cfg.TrendingMinScore, 'f', -1, 64))
+		pipe.Expire(ctx, SearchTrendingKey, time.Duration(cfg.TrendingCacheSecs)*time.Second)
+		_, err := pipe.Exec(ctx)
+		return nil, err
+	})
+	return err
+}
+
+// 热搜榜，跳过被管理员屏蔽的词
+func (s *SearchService) GetTrending(ctx context.Context, limit int) ([]TrendingQueryVO, error) {
+	if limit < 1 || limit > maxTrendingSize {
+		return nil, e.ErrInvalidArgs
+	}
+	if err := s.ensureTrending(ctx); err != nil {
+		log.Printf("failed to build trending searches:%v", err)
+		return nil, e.ErrServer
+	}
+	suppressed, err := s.rdb.SMembers(ctx, searchSuppressedKey).Result()
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	blocked := make(map[string]bool, len(suppressed))
+	for _, q := range suppressed {
+		blocked[q] = true
+	}
+	entries, err := s.rdb.ZRevRangeWithScores(ctx, SearchTrendingKey, 0, int64(limit+len(blocked)-1)).Result()
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	list := make([]TrendingQueryVO, 0, limit)
+	for _, z := range entries {
+		query, _ := z.Member.(string)
+		if blocked[query] {
+			continue
+		}
+		list = append(list, TrendingQueryVO{Rank: len(list) + 1, Query: query, Score: z.Score})
+		if len(list) == limit {
+			break
+		}
+	}
+	return list, nil
+}
+
+// 屏蔽一个词，不再出现在热搜里(仍然可以搜索)
+func (s *SearchService) SuppressTrending(ctx context.Context, query string) error {
+	query = normalizeQuery(query)
+	if query == "" {
+		return e.ErrInvalidArgs
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(ctx, searchSuppressedKey, query)
+	pipe.Del(ctx, SearchTrendingKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+func (s *SearchService) UnsuppressTrending(ctx context.Context, query string) error {
+	query = normalizeQuery(query)
+	if query == "" {
+		return e.ErrInvalidArgs
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.SRem(ctx, searchSuppressedKey, query)
+	pipe.Del(ctx, SearchTrendingKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+func (s *SearchService) ListSuppressed(ctx context.Context) ([]string, error) {
+	queries, err := s.rdb.SMembers(ctx, searchSuppressedKey).Result()
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	sort.Strings(queries)
+	return queries, nil
+}
+
+// 联想词：每个前缀一个zset，成员是"来源标记:原文"，分数是来源自身的热度；
+// 全量重建写到新一代的key里再切换，旧的一代靠过期时间清理
+func completionKey(gen int64, prefix string) string {
+	return searchCompletionKeyPrefix + strconv.FormatInt(gen, 10) + ":" + prefix
+}
+
+// text的前1~n个字，转小写
+func completionPrefixes(text string, n int) []string {
+	runes := []rune(strings.ToLower(strings.TrimSpace(text)))
+	if len(runes) < n {
+		n = len(runes)
+	}
+	prefixes := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		prefixes = append(prefixes, string(runes[:i]))
+	}
+	return prefixes
+}
+func completionTTL() time.Duration {
+	return 2*time.Duration(config.Setting.Search.CompletionRefreshMins)*time.Minute + 10*time.Minute
+}
+
+// 把一条联想词写进gen这一代所有前缀的zset，touched不为nil时记下写过的key，留给调用方统一裁剪
+func (s *SearchService) addCompletion(ctx context.Context, pipe redis.Pipeliner, gen int64, kind, text string, score float64, touched map[string]bool) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	member := completionTags[kind] + text
+	for _, prefix := range completionPrefixes(text, config.Setting.Search.CompletionPrefixLen) {
+		key := completionKey(gen, prefix)
+		pipe.ZAdd(ctx, key, &redis.Z{Score: score, Member: member})
+		if touched != nil {
+			touched[key] = true
+		} else {
+			s.trimCompletion(ctx, pipe, key)
+		}
+	}
+}
+
+// 每个前缀只保留分数最高的若干条
+func (s *SearchService) trimCompletion(ctx context.Context, pipe redis.Pipeliner, key string) {
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-config.Setting.Search.CompletionPerPrefix-1))
+	pipe.Expire(ctx, key, completionTTL())
+}
+
+// 新发布的文章标题即时加入当前这一代的联想词，删除的等下次重建时清掉；
+// 和全量重建一样不收录私密账号的文章，post需要预加载Author
+func (s *SearchService) addPostCompletion(ctx context.Context, post *model.Post) {
+	if post.Author.IsPrivate {
+		return
+	}
+	gen, err := s.rdb.Get(ctx, searchCompletionGenKey).Int64()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("redis error:%v", err)
+		}
+		return
+	}
+	pipe := s.rdb.Pipeline()
+	s.addCompletion(ctx, pipe, gen, CompletionPost, post.Title, 1+post.Hotscore, nil)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("failed to add completion:%v", err)
+	}
+}
+
+// 从文章标题、话题名和用户名全量重建联想词
+func (s *SearchService) RebuildCompletions(ctx context.Context, tx *gorm.DB) error {
+	limit := config.Setting.Search.CompletionMaxSources
+	posts, err := s.postRepo.TopTitles(ctx, tx, limit)
+	if err != nil {
+		return err
+	}
+	topics, err := s.topicRepo.TopTopics(ctx, tx, limit)
+	if err != nil {
+		return err
+	}
+	users, err := s.userRepo.TopUsernames(ctx, tx, limit)
+	if err != nil {
+		return err
+	}
+	gen, err := s.rdb.Incr(ctx, searchCompletionSeqKey).Result()
+	if err != nil {
+		return err
+	}
+	touched := make(map[string]bool)
+	pipe := s.rdb.Pipeline()
+	flush := func() error {
+		if pipe.Len() < rankZAddBatch {
+			return nil
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	for _, p := range posts {
+		s.addCompletion(ctx, pipe, gen, CompletionPost, p.Title, 1+p.Hotscore, touched)
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	for _, t := range topics {
+		s.addCompletion(ctx, pipe, gen, CompletionTopic, t.Name, float64(1+t.PostCount), touched)
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	for _, u := range users {
+		s.addCompletion(ctx, pipe, gen, CompletionUser, u.Username, float64(1+u.FollowerCount), touched)
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	for key := range touched {
+		s.trimCompletion(ctx, pipe, key)
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	pipe.Set(ctx, searchCompletionGenKey, gen, 0)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 按前缀取联想词
+func (s *SearchService) Suggest(ctx context.Context, prefix string, limit int) ([]SearchSuggestionVO, error) {
+	if limit < 1 || limit > maxSuggestSize {
+		return nil, e.ErrInvalidArgs
+	}
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	list := make([]SearchSuggestionVO, 0, limit)
+	if prefix == "" || utf8.RuneCountInString(prefix) > config.Setting.Search.CompletionPrefixLen {
+		return list, nil
+	}
+	gen, err := s.rdb.Get(ctx, searchCompletionGenKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	members, err := s.rdb.ZRevRange(ctx, completionKey(gen, prefix), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	for _, m := range members {
+		for kind, tag := range completionTags {
+			if strings.HasPrefix(m, tag) {
+				list = append(list, SearchSuggestionVO{Text: strings.TrimPrefix(m, tag), Type: kind})
+				break
+			}
+		}
+	}
+	return list, nil
+}
+
+// 定时重建联想词
+func (s *SearchService) StartCompletionWorker(ctx context.Context) {
+	interval := time.Duration(config.Setting.Search.CompletionRefreshMins) * time.Minute
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.RebuildCompletions(ctx, nil); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("rebuild search completions failed:%v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package service
 
 import (
+	"context"
+	"go-zhihu/config"
+	"go-zhihu/internal/job"
 	"go-zhihu/internal/repository"
+	"log"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -17,20 +22,100 @@ type Service struct {
 	Feed         *FeedService
 	Message      *MessageService
 	Notification *NotificationService
+	Rank         *RankService
+	Stats        *StatsService
+	Folder       *FolderService
+	History      *HistoryService
+	Recommend    *RecommendService
+	Activity     *ActivityService
+	Block        *BlockService
+	Suggest      *SuggestService
+	Search       *SearchService
+	Similar      *SimilarService
+	Realtime     *RealtimeService
+	Digest       *DigestService
+	Jobs         *job.Queue
+
+	workers sync.WaitGroup
 }
 
 func NewService(db *gorm.DB, rdb *redis.Client, repos *repository.Repositories, jwtSecret string) *Service {
 
-	notifySvc := NewNotificationService(repos.Notification)
+	jobs := job.NewQueue(rdb, config.Setting.Job)
+	blockSvc := NewBlockService(repos.Block, repos.Relation, repos.User, repos.FollowReq, jobs, rdb)
+	realtimeSvc := NewRealtimeService(repos.Notification, repos.Message, blockSvc, rdb)
+	notifySvc := NewNotificationService(repos.Notification, repos.Post, repos.Comment, repos.User, repos.Relation, jobs, blockSvc, realtimeSvc)
 	feedSvc := NewFeedService(repos.Feed, repos.Post, repos.Relation, rdb)
-	return &Service{
-		User:        NewUserService(repos.User, notifySvc, rdb, jwtSecret),
-		Post:        NewPostService(repos.Post, repos.Like, feedSvc, rdb),
-		Interaction: NewInteractionService(repos.Like, repos.Comment, repos.Post, repos.Connection, notifySvc, db),
-		Relation:    NewRelationService(repos.Relation, repos.User, feedSvc, notifySvc),
-		Feed:        feedSvc,
-		Message:     NewMessageService(repos.Message, notifySvc),
+	rankSvc := NewRankService(repos.Post, repos.Topic, rdb)
+	statsSvc := NewStatsService(repos.Stats, rankSvc, rdb)
+	historySvc := NewHistoryService(repos.History, repos.User, repos.Post)
+	privacySvc := NewPrivacyService(repos.User, repos.Relation)
+	similarSvc := NewSimilarService(repos.Similar, repos.Post, jobs, notifySvc, blockSvc, rdb)
+	activitySvc := NewActivityService(repos.Activity, repos.Relation, repos.User, repos.Post, repos.Comment, blockSvc)
+	s := &Service{
+		User:         NewUserService(repos.User, notifySvc, jobs, rdb, jwtSecret),
+		Post:         NewPostService(repos.Post, repos.Like, repos.Topic, jobs, statsSvc, historySvc, activitySvc, blockSvc, privacySvc, similarSvc, rdb),
+		Interaction:  NewInteractionService(repos.Like, repos.Comment, repos.Post, repos.Connection, repos.Folder, repos.Question, notifySvc, rankSvc, activitySvc, blockSvc, privacySvc, db),
+		Relation:     NewRelationService(repos.Relation, repos.User, repos.FollowReq, jobs, notifySvc, blockSvc, rdb),
+		Feed:         feedSvc,
+		Message:      NewMessageService(repos.Message, notifySvc, blockSvc, realtimeSvc),
+		Notification: notifySvc,
+		Rank:         rankSvc,
+		Stats:        statsSvc,
+		Folder:       NewFolderService(repos.Folder, repos.Connection, repos.Post, rankSvc, db),
+		History:      historySvc,
+		Recommend:    NewRecommendService(repos.Recommend, repos.Post, blockSvc, rdb),
+		Activity:     activitySvc,
+		Block:        blockSvc,
+		Suggest:      NewSuggestService(repos.Suggest, repos.Recommend, repos.Relation, repos.User, repos.Topic, blockSvc, rdb),
+		Search:       NewSearchService(newSearchEngine(config.Setting.Search, repos.Post), repos.Post, repos.User, repos.Topic, repos.SearchLog, blockSvc, rdb),
+		Similar:      similarSvc,
+		Realtime:     realtimeSvc,
+		Digest:       NewDigestService(repos.Notification, repos.Post, repos.Comment, repos.User, notifySvc, blockSvc, newMailer(config.Setting.Mail), jobs, rdb, jwtSecret),
+		Jobs:         jobs,
+	}
+	s.registerJobs(repos.Post)
+	return s
+}
+
+// 启动后台任务，ctx取消时全部退出
+func (s *Service) StartBackground(ctx context.Context) {
+	s.runWorker(ctx, s.Rank.StartHotRankWorker)
+	s.runWorker(ctx, s.Stats.StartViewFlushWorker)
+	s.runWorker(ctx, s.History.StartHistoryPruneWorker)
+	s.runWorker(ctx, s.Notification.StartNotificationPruneWorker)
+	s.runWorker(ctx, s.Recommend.StartRecommendWorker)
+	s.runWorker(ctx, s.Suggest.StartSuggestWorker)
+	s.runWorker(ctx, s.Search.StartSearchIndexer)
+	s.runWorker(ctx, s.Search.StartCompletionWorker)
+	s.runWorker(ctx, s.Realtime.StartRealtimeHub)
+	s.runWorker(ctx, s.Digest.StartDigestWorker)
+	if err := s.Jobs.Start(ctx); err != nil {
+		log.Printf("start job queue failed:%v", err)
+	}
+}
+func (s *Service) runWorker(ctx context.Context, fn func(context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn(ctx)
+	}()
+}
+
+// 等待后台任务退出(StartBackground的ctx取消之后调用)，超时返回false
+func (s *Service) WaitBackground(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		return false
 	}
+	return s.Jobs.Wait(time.Until(deadline))
 }
 
 const (
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/job"
+	"go-zhihu/internal/model"
+	"go-zhihu/internal/repository"
+	"go-zhihu/internal/search"
+	"go-zhihu/pkg/e"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 相似文章和重复问题：发布时计算标题+正文的MinHash签名，按LSH分桶找候选，再用签名估计相似度
+type SimilarService struct {
+	repo     *repository.SimilarRepository
+	postRepo *repository.PostRepository
+	jobs     *job.Queue
+	notify   *NotificationService
+	block    *BlockService
+	rdb      *redis.Client
+}
+
+func NewSimilarService(repo *repository.SimilarRepository, post *repository.PostRepository, jobs *job.Queue, notify *NotificationService, block *BlockService, rdb *redis.Client) *SimilarService {
+	return &SimilarService{repo: repo, postRepo: post, jobs: jobs, notify: notify, block: block, rdb: rdb}
+}
+
+const CacheKeyPostRelated = "post:related:%d"
+
+// 查重时最多返回的条数
+const maxDuplicateSize = 10
+
+type similarHit struct {
+	ID    uint    `json:"id"`
+	Score float64 `json:"score"`
+}
+
+func postSignature(title, content string) []uint64 {
+	return search.MinHash(search.Shingles(title + "\n" + content))
+}
+
+// 索引任务：已发布的文章更新签名，其余的移除签名
+func (s *SimilarService) IndexPost(ctx context.Context, tx *gorm.DB, postID uint) error {
+	defer s.rdb.Del(ctx, fmt.Sprintf(CacheKeyPostRelated, postID))
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.repo.RemoveSignature(ctx, tx, postID)
+		}
+		return err
+	}
+	sig := postSignature(post.Title, post.Content)
+	if post.Status != model.PostStatusPublished || sig == nil {
+		return s.repo.RemoveSignature(ctx, tx, postID)
+	}
+	return s.repo.SaveSignature(ctx, tx, postID, search.EncodeSignature(sig), search.LSHBuckets(sig))
+}
+
+// 按LSH找候选，返回相似度不低于threshold的文章，相似度从高到低
+func (s *SimilarService) similarTo(ctx context.Context, tx *gorm.DB, sig []uint64, excludeID uint, threshold float64) ([]similarHit, error) {
+	if sig == nil {
+		return []similarHit{}, nil
+	}
+	ids, err := s.repo.CandidateIDs(ctx, tx, search.LSHBuckets(sig), excludeID, config.Setting.Similar.CandidateLimit)
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := s.repo.FindSignatures(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]similarHit, 0, len(sigs))
+	for _, other := range sigs {
+		if score := search.Similarity(sig, search.DecodeSignature(other.Signature)); score >= threshold {
+			hits = append(hits, similarHit{ID: other.PostID, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	return hits, nil
+}
+
+// 按viewerID的可见性过滤后取前limit条，postType不为0时只要该类型
+func (s *SimilarService) toVOs(ctx context.Context, tx *gorm.DB, hits []similarHit, viewerID uint, postType, limit int) ([]SimilarPostVO, error) {
+	list := make([]SimilarPostVO, 0, limit)
+	if len(hits) == 0 {
+		return list, nil
+	}
+	hidden, err := s.block.hiddenUserIDs(ctx, tx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(hits))
+	for _, h := range hits {
+		ids = append(ids, h.ID)
+	}
+	visible, err := s.postRepo.FilterSearchable(ctx, tx, ids, viewerID, hidden, repository.PostSearchFilter{PostType: postType})
+	if err != nil {
+		return nil, err
+	}
+	visibleSet := make(map[uint]bool, len(visible))
+	for _, p := range visible {
+		visibleSet[p.ID] = true
+	}
+	pageIDs := make([]string, 0, limit)
+	scores := make(map[uint]float64, limit)
+	for _, h := range hits {
+		if visibleSet[h.ID] && len(pageIDs) < limit {
+			pageIDs = append(pageIDs, strconv.FormatUint(uint64(h.ID), 10))
+			scores[h.ID] = h.Score
+		}
+	}
+	if len(pageIDs) == 0 {
+		return list, nil
+	}
+	posts, err := s.postRepo.FindPostsByIDs(ctx, tx, pageIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range sortPostsByIDs(posts, pageIDs) {
+		list = append(list, SimilarPostVO{ID: p.ID, Title: p.Title, Type: p.Type, Similarity: scores[p.ID], CreatedAt: p.CreatedAt})
+	}
+	return list, nil
+}
+
+// 提问前查重：返回可能重复的已发布问题
+func (s *SimilarService) FindDuplicates(ctx context.Context, tx *gorm.DB, viewerID uint, title, content string) ([]SimilarPostVO, error) {
+	hits, err := s.similarTo(ctx, tx, postSignature(title, content), 0, config.Setting.Similar.DuplicateThreshold)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	list, err := s.toVOs(ctx, tx, hits, viewerID, 2, maxDuplicateSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return list, nil
+}
+
+// 管理员查看某个问题可能的重复
+func (s *SimilarService) DuplicatesOf(ctx context.Context, tx *gorm.DB, postID uint) ([]SimilarPostVO, error) {
+	post, err := s.postRepo.FindPostByID(ctx, tx, postID)
+	if err != nil {
+		return nil, e.ErrPostNotFound
+	}
+	hits, err := s.similarTo(ctx, tx, postSignature(post.Title, post.Content), postID, config.Setting.Similar.DuplicateThreshold)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	list, err := s.toVOs(ctx, tx, hits, 0, post.Type, maxDuplicateSize)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	return list, nil
+}
+
+// 详情页的相关文章：相似度列表按文章缓存，可见性按读者过滤
+func (s *SimilarService) Related(ctx context.Context, tx *gorm.DB, postID, viewerID uint) ([]SimilarPostVO, error) {
+	cfg := config.Setting.Similar
+	cacheKey := fmt.Sprintf(CacheKeyPostRelated, postID)
+	var hits []similarHit
+	val, err := s.rdb.Get(ctx, cacheKey).Result()
+	if err == nil && json.Unmarshal([]byte(val), &hits) == nil {
+		return s.toVOs(ctx, tx, hits, viewerID, 0, cfg.RelatedSize)
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("redis error:%v", err)
+	}
+	sig, err := s.repo.FindSignature(ctx, tx, postID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []SimilarPostVO{}, nil
+		}
+		return nil, err
+	}
+	hits, err = s.similarTo(ctx, tx, search.DecodeSignature(sig.Signature), postID, cfg.RelatedThreshold)
+	if err != nil {
+		return nil, err
+	}
+	// 多缓存一些，留给可见性过滤
+	if len(hits) > cfg.RelatedSize*4 {
+		hits = hits[:cfg.RelatedSize*4]
+	}
+	data, _ := json.Marshal(hits)
+	s.rdb.Set(ctx, cacheKey, data, getRandomExpire(time.Duration(cfg.RelatedCacheMinutes)*time.Minute))
+	return s.toVOs(ctx, tx, hits, viewerID, 0, cfg.RelatedSize)
+}
+
+// 管理员把重复的问题src合并到dst，通知src的提问者
+func (s *SimilarService) MergeDuplicate(ctx context.Context, tx *gorm.DB, moderatorID, srcID, dstID uint) error {
+	if srcID == dstID {
+		return e.ErrMergeNotAllowed
+	}
+	src, err := s.postRepo.FindPostByID(ctx, tx, srcID)
+	if err != nil {
+		return e.ErrPostNotFound
+	}
+	dst, err := s.postRepo.FindPostByID(ctx, tx, dstID)
+	if err != nil {
+		return e.ErrPostNotFound
+	}
+	if src.Type != 2 || dst.Type != 2 || src.Status != model.PostStatusPublished || dst.Status != model.PostStatusPublished {
+		return e.ErrMergeNotAllowed
+	}
+	if err := s.postRepo.MergeInto(ctx, tx, srcID, dstID); err != nil {
+		return e.ErrServer
+	}
+	s.rdb.Del(ctx, fmt.Sprintf(CacheKeyPostDetail, srcID), fmt.Sprintf(CacheKeyPostDetail, dstID), fmt.Sprintf(CacheKeyPostRelated, srcID))
+	payload := postJob{PostID: srcID, AuthorID: src.AuthorID}
+	dispatch(ctx, s.jobs, JobFeedRemovePost, payload)
+	dispatch(ctx, s.jobs, JobSearchIndex, payload)
+	dispatch(ctx, s.jobs, JobSimilarIndex, payload)
+	s.notify.sendNotification(ctx, tx, src.AuthorID, moderatorID, model.NotifyTypeSystem, "你的问题与已有问题重复，已合并", model.TargetTypePost, dstID)
+	return nil
+}
+
+// 为已发布的文章全量计算签名，上线前的历史文章用它补齐
+func (s *SimilarService) RebuildSignatures(ctx context.Context, tx *gorm.DB) (int, error) {
+	var afterID uint
+	count := 0
+	for {
+		posts, err := s.postRepo.ListPublishedAfter(ctx, tx, afterID, config.Setting.Search.RebuildBatchSize)
+		if err != nil {
+			return count, err
+		}
+		if len(posts) == 0 {
+			return count, nil
+		}
+		for _, p := range posts {
+			sig := postSignature(p.Title, p.Content)
+			if sig == nil {
+				continue
+			}
+			if err := s.repo.SaveSignature(ctx, tx, p.ID, search.EncodeSignature(sig), search.LSHBuckets(sig)); err != nil {
+				return count, err
+			}
+			count++
+		}
+		afterID = posts[len(posts)-1].ID
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+	}
+}
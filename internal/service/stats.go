@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+type StatsService struct {
+	repo *repository.StatsRepository
+	rank *RankService
+	rdb  *redis.Client
+}
+
+func NewStatsService(repo *repository.StatsRepository, rank *RankService, rdb *redis.Client) *StatsService {
+	return &StatsService{repo: repo, rank: rank, rdb: rdb}
+}
+
+const (
+	ViewHLLKey = "post:view:hll:%d:%d"
+	// 待刷盘的阅读数按阅读发生的日期分开存，刷盘时记到对应那天
+	ViewPendingKey     = "post:view:pending:%s"
+	viewPendingDaysKey = "post:view:pending:days"
+	viewFlushLockKey   = "post:view:flush:lock"
+	viewFlushLockTTL   = 2 * time.Minute
+	MaxAuthorStatsDays = 90
+)
+
+// 原子地取出并删除某天的待刷盘计数，同时把这一天从待处理集合中去掉；
+// 之后的新阅读会重新建hash并加回集合
+var drainViewsScript = redis.NewScript(`
+local counts = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+redis.call("SREM", KEYS[2], ARGV[1])
+return counts`)
+
+// 阅读者标识：登录用户用ID，游客用IP
+func viewerKey(userID uint, clientIP string) string {
+	if userID > 0 {
+		return fmt.Sprintf("u:%d", userID)
+	}
+	return "ip:" + clientIP
+}
+
+// 记录一次阅读，同一阅读者在去重窗口内用HyperLogLog判重，只有新阅读才累加待刷盘的计数
+func (s *StatsService) RecordView(ctx context.Context, tx *gorm.DB, postID uint, postType int, userID uint, clientIP string) {
+	window := time.Duration(config.Setting.View.DedupWindowMinutes) * time.Minute
+	if window <= 0 {
+		window = 30 * time.Minute
+	}
+	slot := time.Now().Unix() / int64(window.Seconds())
+	hllKey := fmt.Sprintf(ViewHLLKey, postID, slot)
+	added, err := s.rdb.PFAdd(ctx, hllKey, viewerKey(userID, clientIP)).Result()
+	if err != nil {
+		log.Printf("failed to record view:%v", err)
+		return
+	}
+	if added == 0 {
+		return
+	}
+	day := time.Now().Format("20060102")
+	pipe := s.rdb.TxPipeline()
+	pipe.Expire(ctx, hllKey, window)
+	pipe.HIncrBy(ctx, fmt.Sprintf(ViewPendingKey, day), strconv.FormatUint(uint64(postID), 10), 1)
+	pipe.SAdd(ctx, viewPendingDaysKey, day)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("failed to record view:%v", err)
+		return
+	}
+	s.rank.RecordInteraction(ctx, tx, postID, postType, config.Setting.Rank.ViewWeight)
+}
+
+// 把待刷盘的阅读数写回mysql，多实例之间用锁保证同一时间只有一个在刷。
+// 每天的计数用脚本一次取出并删除，不会被两个实例重复写入；写库失败时把计数加回去等下次再刷
+func (s *StatsService) FlushViews(ctx context.Context, tx *gorm.DB) error {
+	lock, err := acquireLock(ctx, s.rdb, viewFlushLockKey, viewFlushLockTTL)
+	if err != nil || lock == nil {
+		return err
+	}
+	defer lock.Release(ctx)
+	days, err := s.rdb.SMembers(ctx, viewPendingDaysKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if err := s.flushDay(ctx, tx, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (s *StatsService) flushDay(ctx context.Context, tx *gorm.DB, day string) error {
+	date, err := time.ParseInLocation("20060102", day, time.Local)
+	if err != nil {
+		return s.rdb.SRem(ctx, viewPendingDaysKey, day).Err()
+	}
+	key := fmt.Sprintf(ViewPendingKey, day)
+	raw, err := drainViewsScript.Run(ctx, s.rdb, []string{key, viewPendingDaysKey}, day).StringSlice()
+	if err != nil {
+		return err
+	}
+	views := make(map[uint]int64, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		id, err := strconv.ParseUint(raw[i], 10, 64)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.ParseInt(raw[i+1], 10, 64)
+		if err != nil || count <= 0 {
+			continue
+		}
+		views[uint(id)] = count
+	}
+	if err := s.repo.FlushViews(ctx, tx, views, date); err != nil {
+		s.restoreViews(day, views)
+		return err
+	}
+	return nil
+}
+
+// 写库失败时把取出的计数加回redis，不跟随请求取消
+func (s *StatsService) restoreViews(day string, views map[uint]int64) {
+	ctx := context.Background()
+	pipe := s.rdb.TxPipeline()
+	for id, count := range views {
+		pipe.HIncrBy(ctx, fmt.Sprintf(ViewPendingKey, day), strconv.FormatUint(uint64(id), 10), count)
+	}
+	pipe.SAdd(ctx, viewPendingDaysKey, day)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("restore %d pending views of %s failed:%v", len(views), day, err)
+	}
+}
+
+// 后台定时刷盘，退出前再刷一次
+func (s *StatsService) StartViewFlushWorker(ctx context.Context) {
+	interval := time.Duration(config.Setting.View.FlushSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.FlushViews(context.Background(), nil); err != nil {
+				log.Printf("flush views failed:%v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.FlushViews(ctx, nil); err != nil {
+				log.Printf("flush views failed:%v", err)
+			}
+		}
+	}
+}
+
+// 作者最近days天的数据，按天分桶，没有数据的日期补0
+func (s *StatsService) GetAuthorStats(ctx context.Context, tx *gorm.DB, authorID uint, days int) (*AuthorStatsVO, error) {
+	if days < 1 || days > MaxAuthorStatsDays {
+		return nil, e.ErrInvalidArgs
+	}
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -(days - 1))
+	stats := &AuthorStatsVO{Days: make([]DailyStatVO, 0, days)}
+	index := make(map[string]int, days)
+	for i := 0; i < days; i++ {
+		day := since.AddDate(0, 0, i).Format("2006-01-02")
+		index[day] = i
+		stats.Days = append(stats.Days, DailyStatVO{Date: day})
+	}
+	queries := []struct {
+		fetch func(context.Context, *gorm.DB, uint, time.Time) ([]repository.DailyCount, error)
+		field func(*DailyStatVO) *int64
+	}{
+		{s.repo.DailyViewsByAuthor, func(d *DailyStatVO) *int64 { return &d.Views }},
+		{s.repo.DailyLikesByAuthor, func(d *DailyStatVO) *int64 { return &d.Likes }},
+		{s.repo.DailyCommentsByAuthor, func(d *DailyStatVO) *int64 { return &d.Comments }},
+		{s.repo.DailyBookmarksByAuthor, func(d *DailyStatVO) *int64 { return &d.Bookmarks }},
+		{s.repo.DailyNewFollowers, func(d *DailyStatVO) *int64 { return &d.NewFollowers }},
+	}
+	for _, q := range queries {
+		rows, err := q.fetch(ctx, tx, authorID, since)
+		if err != nil {
+			return nil, e.ErrServer
+		}
+		for _, row := range rows {
+			i, ok := index[row.Day]
+			if !ok {
+				continue
+			}
+			*q.field(&stats.Days[i]) += row.Count
+			*q.field(&stats.Total) += row.Count
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-zhihu/config"
+	"go-zhihu/internal/repository"
+	"go-zhihu/pkg/e"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// 可能认识的人：关注的人还关注了谁、常在感兴趣的话题下发文的作者、赞过相同文章的人，后台预先算好按用户缓存
+type SuggestService struct {
+	repo          *repository.SuggestRepository
+	recommendRepo *repository.RecommendRepository
+	relationRepo  *repository.RelationRepository
+	userRepo      *repository.UserRepository
+	topicRepo     *repository.TopicRepository
+	block         *BlockService
+	rdb           *redis.Client
+	sf            singleflight.Group
+}
+
+func NewSuggestService(repo *repository.SuggestRepository, recommend *repository.RecommendRepository, relation *repository.RelationRepository, user *repository.UserRepository, topic *repository.TopicRepository, block *BlockService, rdb *redis.Client) *SuggestService {
+	return &SuggestService{repo: repo, recommendRepo: recommend, relationRepo: relation, userRepo: user, topicRepo: topic, block: block, rdb: rdb}
+}
+
+const (
+	SuggestKeyPrefix   = "suggest:user:"
+	suggestDismissKey  = "suggest:dismissed:"
+	suggestDismissTTL  = 30 * 24 * time.Hour
+	suggestMaxTopics   = 10
+	suggestRecallRatio = 4
+)
+
+// 推荐理由的来源
+const (
+	suggestByFriends = iota + 1
+	suggestByTopic
+	suggestByCoLike
+)
+
+// 缓存里的一条推荐，理由在生成时拼好
+type suggestion struct {
+	UserID uint    `json:"user_id"`
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// 合并过程中的候选：累计得分，并记下贡献最大的一路用来生成理由
+type suggestCandidate struct {
+	score  float64
+	best   float64
+	source int
+	row    repository.UserScore
+}
+
+func suggestKey(userID uint) string {
+	return fmt.Sprintf("%s%d", SuggestKeyPrefix, userID)
+}
+func suggestDismissedKey(userID uint) string {
+	return fmt.Sprintf("%s%d", suggestDismissKey, userID)
+}
+
+// 已关注、拉黑、不感兴趣的人和自己都不推荐
+func (s *SuggestService) excluded(ctx context.Context, tx *gorm.DB, userID uint) (map[uint]bool, error) {
+	exclude, err := s.block.hiddenUsers(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exclude[userID] = true
+	followees, err := s.relationRepo.GetFolloweeIDs(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range followees {
+		exclude[id] = true
+	}
+	dismissed, err := s.rdb.SMembers(ctx, suggestDismissedKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range dismissed {
+		var uid uint
+		if _, err := fmt.Sscan(id, &uid); err == nil {
+			exclude[uid] = true
+		}
+	}
+	return exclude, nil
+}
+
+// 把一路召回按最大值归一化后乘权重累加
+func addSuggestRecall(candidates map[uint]*suggestCandidate, rows []repository.UserScore, weight float64, source int, exclude map[uint]bool) {
+	var max float64
+	for _, r := range rows {
+		if r.Score > max {
+			max = r.Score
+		}
+	}
+	if max <= 0 || weight == 0 {
+		return
+	}
+	for _, r := range rows {
+		if exclude[r.UserID] {
+			continue
+		}
+		part := r.Score / max * weight
+		c, ok := candidates[r.UserID]
+		if !ok {
+			c = &suggestCandidate{}
+			candidates[r.UserID] = c
+		}
+		c.score += part
+		if part > c.best {
+			c.best, c.source, c.row = part, source, r
+		}
+	}
+}
+
+// 离线为用户生成推荐并缓存
+func (s *SuggestService) BuildSuggestions(ctx context.Context, tx *gorm.DB, userID uint) ([]suggestion, error) {
+	cfg := config.Setting.Suggest
+	since := time.Now().AddDate(0, 0, -cfg.WindowDays)
+	limit := cfg.CacheSize * suggestRecallRatio
+	exclude, err := s.excluded(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[uint]*suggestCandidate)
+
+	friends, err := s.repo.FriendsOfFriends(ctx, tx, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	addSuggestRecall(candidates, friends, cfg.FriendWeight, suggestByFriends, exclude)
+
+	topics, err := s.recommendRepo.UserTopicWeights(ctx, tx, userID, since, suggestMaxTopics)
+	if err != nil {
+		return nil, err
+	}
+	if len(topics) > 0 {
+		topicIDs := make([]uint, 0, len(topics))
+		for _, t := range topics {
+			topicIDs = append(topicIDs, t.TopicID)
+		}
+		authors, err := s.repo.TopicAuthors(ctx, tx, userID, topicIDs, since, limit)
+		if err != nil {
+			return nil, err
+		}
+		addSuggestRecall(candidates, authors, cfg.TopicWeight, suggestByTopic, exclude)
+	}
+
+	coLikers, err := s.repo.CoLikers(ctx, tx, userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	addSuggestRecall(candidates, coLikers, cfg.CoLikeWeight, suggestByCoLike, exclude)
+
+	ids := make([]uint, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return candidates[ids[i]].score > candidates[ids[j]].score
+	})
+	if len(ids) > cfg.CacheSize {
+		ids = ids[:cfg.CacheSize]
+	}
+	list, err := s.renderReasons(ctx, tx, ids, candidates)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(list)
+	if err := s.rdb.Set(ctx, suggestKey(userID), data, time.Duration(cfg.CacheTTLHours)*time.Hour).Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// 生成推荐理由，共同关注的人和话题的名字批量查出
+func (s *SuggestService) renderReasons(ctx context.Context, tx *gorm.DB, ids []uint, candidates map[uint]*suggestCandidate) ([]suggestion, error) {
+	var viaUsers, viaTopics []uint
+	for _, id := range ids {
+		c := candidates[id]
+		switch c.source {
+		case suggestByFriends:
+			viaUsers = append(viaUsers, c.row.ViaID)
+		case suggestByTopic:
+			viaTopics = append(viaTopics, c.row.ViaID)
+		}
+	}
+	userNames := make(map[uint]string)
+	if len(viaUsers) > 0 {
+		users, err := s.userRepo.FindUsersByIDs(ctx, tx, viaUsers)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			userNames[u.ID] = u.Username
+		}
+	}
+	topicNames := make(map[uint]string)
+	if len(viaTopics) > 0 {
+		topics, err := s.topicRepo.FindTopicsByIDs(ctx, tx, viaTopics)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range topics {
+			topicNames[t.ID] = t.Name
+		}
+	}
+	list := make([]suggestion, 0, len(ids))
+	for _, id := range ids {
+		c := candidates[id]
+		var reason string
+		switch c.source {
+		case suggestByFriends:
+			if n := int(c.row.Score); n > 1 {
+				reason = fmt.Sprintf("你关注的%s和另外%d人也关注了TA", userNames[c.row.ViaID], n-1)
+			} else {
+				reason = fmt.Sprintf("你关注的%s也关注了TA", userNames[c.row.ViaID])
+			}
+		case suggestByTopic:
+			reason = fmt.Sprintf("常在你感兴趣的「%s」话题下发文", topicNames[c.row.ViaID])
+		case suggestByCoLike:
+			reason = fmt.Sprintf("和你赞过%d篇相同的文章", int(c.row.Score))
+		}
+		list = append(list, suggestion{UserID: id, Score: c.score, Reason: reason})
+	}
+	return list, nil
+}
+
+// 读取推荐；缓存没有时当场生成一次。缓存生成之后新关注、拉黑或封禁的人在这里过滤
+func (s *SuggestService) GetSuggestions(ctx context.Context, tx *gorm.DB, userID uint, limit int) ([]SuggestionVO, error) {
+	var list []suggestion
+	val, err := s.rdb.Get(ctx, suggestKey(userID)).Result()
+	if err == nil {
+		_ = json.Unmarshal([]byte(val), &list)
+	} else {
+		v, err, _ := s.sf.Do(suggestKey(userID), func() (interface{}, error) {
+			return s.BuildSuggestions(ctx, tx, userID)
+		})
+		if err != nil {
+			log.Printf("build suggestions failed:%v", err)
+			return nil, e.ErrServer
+		}
+		list = v.([]suggestion)
+	}
+	result := make([]SuggestionVO, 0, limit)
+	if len(list) == 0 {
+		return result, nil
+	}
+	exclude, err := s.excluded(ctx, tx, userID)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	ids := make([]uint, 0, len(list))
+	for _, sg := range list {
+		if !exclude[sg.UserID] {
+			ids = append(ids, sg.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return result, nil
+	}
+	users, err := s.userRepo.FindUsersByIDs(ctx, tx, ids)
+	if err != nil {
+		return nil, e.ErrServer
+	}
+	userMap := make(map[uint]*UserProfileVO, len(users))
+	for i := range users {
+		if users[i].Status != 0 {
+			userMap[users[i].ID] = newUserProfileVO(&users[i])
+		}
+	}
+	for _, sg := range list {
+		if len(result) >= limit {
+			break
+		}
+		if u, ok := userMap[sg.UserID]; ok && !exclude[sg.UserID] {
+			result = append(result, SuggestionVO{User: *u, Reason: sg.Reason})
+		}
+	}
+	return result, nil
+}
+
+// 不感兴趣：以后不再推荐这个人
+func (s *SuggestService) Dismiss(ctx context.Context, userID, targetID uint) error {
+	key := suggestDismissedKey(userID)
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(ctx, key, targetID)
+	pipe.Expire(ctx, key, suggestDismissTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return e.ErrServer
+	}
+	return nil
+}
+
+// 后台定时为活跃用户重算推荐
+func (s *SuggestService) StartSuggestWorker(ctx context.Context) {
+	cfg := config.Setting.Suggest
+	interval := time.Duration(cfg.RefreshMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		since := time.Now().AddDate(0, 0, -cfg.ActiveDays)
+		userIDs, err := s.recommendRepo.ActiveUserIDs(ctx, nil, since, cfg.MaxUsers)
+		if err != nil {
+			log.Printf("load active users failed:%v", err)
+		}
+		for _, uid := range userIDs {
+			if ctx.Err() != nil {
+				return
+			}
+			if _, err := s.BuildSuggestions(ctx, nil, uid); err != nil {
+				log.Printf("build suggestions for %d failed:%v", uid, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"go-zhihu/config"
+	"go-zhihu/internal/job"
 	"go-zhihu/internal/model"
 	"go-zhihu/internal/repository"
 	"go-zhihu/pkg/e"
@@ -23,12 +24,26 @@ import (
 type UserService struct {
 	repo   *repository.UserRepository
 	notify *NotificationService
+	jobs   *job.Queue
 	rdb    *redis.Client
 	secret string
 }
 
-func NewUserService(repo *repository.UserRepository, notify *NotificationService, rdb *redis.Client, secret string) *UserService {
-	return &UserService{repo: repo, notify: notify, rdb: rdb, secret: secret}
+func NewUserService(repo *repository.UserRepository, notify *NotificationService, jobs *job.Queue, rdb *redis.Client, secret string) *UserService {
+	return &UserService{repo: repo, notify: notify, jobs: jobs, rdb: rdb, secret: secret}
+}
+
+const CacheKeyUserProfile = "user:profile:%d"
+
+// 资料缓存里带着关注数和粉丝数，关注关系变化后一并失效
+func clearProfileCache(ctx context.Context, rdb *redis.Client, userIDs ...uint) {
+	keys := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		keys = append(keys, fmt.Sprintf(CacheKeyUserProfile, id))
+	}
+	if err := rdb.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("failed to invalidate user cache :%v", err)
+	}
 }
 
 type LoginResponse struct {
@@ -108,10 +123,7 @@ func (s *UserService) UpdateProfile(ctx context.Context, tx *gorm.DB, userID uin
 	if err := s.repo.UpdateProfile(ctx, tx, userID, avatar, bio); err != nil {
 		return e.ErrServer
 	}
-	cacheKey := fmt.Sprintf("user:profile:%d", userID)
-	if err := s.rdb.Del(ctx, cacheKey).Err(); err != nil {
-		log.Printf("failed to invalidate user cache :%v", err)
-	}
+	clearProfileCache(ctx, s.rdb, userID)
 	return nil
 }
 func (s *UserService) BanUser(ctx context.Context, tx *gorm.DB, id uint) error {
@@ -126,6 +138,7 @@ func (s *UserService) BanUser(ctx context.Context, tx *gorm.DB, id uint) error {
 		return e.ErrServer
 	}
 	_ = s.notify.SendSystemNotice(ctx, tx, id, "已被封禁")
+	dispatch(ctx, s.jobs, JobFeedBanAuthor, authorJob{AuthorID: id})
 	return nil
 
 }
@@ -137,13 +150,17 @@ func (s *UserService) UnbanUser(ctx context.Context, tx *gorm.DB, targetID uint)
 	if targetUser.Status == 1 {
 		return e.ErrUserNormal
 	}
-	return s.repo.UnbanUser(ctx, tx, targetID)
+	if err := s.repo.UnbanUser(ctx, tx, targetID); err != nil {
+		return e.ErrServer
+	}
+	dispatch(ctx, s.jobs, JobFeedRestoreAuthor, authorJob{AuthorID: targetID})
+	return nil
 }
 
 // 获取他人公开资料
 func (s *UserService) GetUserProfile(ct context.Context, tx *gorm.DB, targetID uint) (*UserProfileVO, error) {
 	ctx := context.Background()
-	cacheKey := fmt.Sprintf("user:profile:%d", targetID)
+	cacheKey := fmt.Sprintf(CacheKeyUserProfile, targetID)
 	val, err := s.rdb.Get(ctx, cacheKey).Result()
 	if err == nil {
 		if val == "NULL" {
@@ -159,13 +176,7 @@ func (s *UserService) GetUserProfile(ct context.Context, tx *gorm.DB, targetID u
 		s.rdb.Set(ctx, cacheKey, "NULL", time.Minute)
 		return nil, e.ErrUserNotFoundInstance
 	}
-	profile := &UserProfileVO{
-		ID:        user.ID,
-		Username:  user.Username,
-		Avatar:    user.Avatar,
-		Bio:       user.Bio,
-		CreatedAt: user.CreatedAt,
-	}
+	profile := newUserProfileVO(user)
 	data, _ := json.Marshal(profile)
 	s.rdb.Set(ctx, cacheKey, data, getRandomExpire(30*time.Minute))
 	return profile, nil
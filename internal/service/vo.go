@@ -9,13 +9,194 @@ import (
 type PostDetailVO struct {
 	*model.Post
 	LikeCount int64 `json:"like_count"`
+	// 打开的是已被合并的问题时，返回合并后的问题并带上原问题的id
+	MergedFrom uint            `json:"merged_from,omitempty"`
+	Related    []SimilarPostVO `json:"related,omitempty"`
 }
 
 // 新增用户公开信息
 type UserProfileVO struct {
-	ID        uint      `json:"id"`
-	Username  string    `json:"username"`
-	Avatar    string    `json:"avatar"`
-	Bio       string    `json:"bio"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            uint      `json:"id"`
+	Username      string    `json:"username"`
+	Avatar        string    `json:"avatar"`
+	Bio           string    `json:"bio"`
+	FollowerCount int64     `json:"follower_count"`
+	FolloweeCount int64     `json:"followee_count"`
+	IsPrivate     bool      `json:"is_private"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func newUserProfileVO(u *model.User) *UserProfileVO {
+	return &UserProfileVO{
+		ID:            u.ID,
+		Username:      u.Username,
+		Avatar:        u.Avatar,
+		Bio:           u.Bio,
+		FollowerCount: u.FollowerCount,
+		FolloweeCount: u.FolloweeCount,
+		IsPrivate:     u.IsPrivate,
+		CreatedAt:     u.CreatedAt,
+	}
+}
+
+// 粉丝/关注列表中的一项，Mutual表示互相关注
+type FollowUserVO struct {
+	UserProfileVO
+	Mutual bool `json:"mutual"`
+}
+
+// 当前用户与某人的关系
+type RelationStatusVO struct {
+	UserID     uint `json:"user_id"`
+	Following  bool `json:"following"`
+	FollowedBy bool `json:"followed_by"`
+	Mutual     bool `json:"mutual"`
+	Requested  bool `json:"requested"`
+}
+
+// 关注操作的结果，关注私密账号时为requested，等待对方审核
+type FollowResultVO struct {
+	Status string `json:"status"`
+}
+
+// 作者数据统计
+type DailyStatVO struct {
+	Date         string `json:"date,omitempty"`
+	Views        int64  `json:"views"`
+	Likes        int64  `json:"likes"`
+	Comments     int64  `json:"comments"`
+	Bookmarks    int64  `json:"bookmarks"`
+	NewFollowers int64  `json:"new_followers"`
+}
+type AuthorStatsVO struct {
+	Total DailyStatVO   `json:"total"`
+	Days  []DailyStatVO `json:"days"`
+}
+
+// 聚合后的一条动态，多人对同一对象做了同一件事时合并，Actors只带前几个人
+type ActivityVO struct {
+	Type       int             `json:"type"`
+	TypeName   string          `json:"type_name"`
+	Actors     []UserProfileVO `json:"actors"`
+	ActorCount int             `json:"actor_count"`
+	Post       *model.Post     `json:"post"`
+	Comment    *model.Comment  `json:"comment,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// 通知中心的一条：合并的通知带最近几个触发者和总人数，Summary为拼好的文案
+type NotificationVO struct {
+	ID         uint            `json:"id"`
+	Type       int             `json:"type"`
+	Summary    string          `json:"summary"`
+	Content    string          `json:"content"`
+	Actors     []UserProfileVO `json:"actors"`
+	ActorCount int             `json:"actor_count"`
+	TargetType int             `json:"target_type"`
+	TargetID   uint            `json:"target_id"`
+	PostID     uint            `json:"post_id"`
+	Snippet    string          `json:"snippet"`
+	IsRead     bool            `json:"is_read"`
+	CreatedAt  time.Time       `json:"created_at"`
+	LatestAt   time.Time       `json:"latest_at"`
+}
+type NotificationUnreadVO struct {
+	UnreadCount int64 `json:"unread_count"`
+	// 键为分类名：likes,comments,follows,system,messages
+	Categories map[string]int64 `json:"categories"`
+}
+
+// 实时推送的未读数：通知未读数和分类同NotificationUnreadVO，外加私信未读和合计
+type UnreadVO struct {
+	NotificationUnreadVO
+	MessageUnread int64 `json:"message_unread"`
+	TotalUnread   int64 `json:"total_unread"`
+}
+type NotificationPrefVO struct {
+	Type          int    `json:"type"`
+	Name          string `json:"name"`
+	InApp         bool   `json:"in_app"`
+	FollowingOnly bool   `json:"following_only"`
+	Digest        string `json:"digest"`
+}
+type NotificationSettingsVO struct {
+	Types        []NotificationPrefVO `json:"types"`
+	QuietEnabled bool                 `json:"quiet_enabled"`
+	Quiet        QuietHours           `json:"quiet_hours"`
+	Timezone     string               `json:"timezone"`
+}
+type ActivitySettingVO struct {
+	Type    int    `json:"type"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// 可能认识的人
+type SuggestionVO struct {
+	User   UserProfileVO `json:"user"`
+	Reason string        `json:"reason"`
+}
+
+// 搜索结果，带<em>标记的字段是高亮后的文本，已做html转义
+type SearchPostVO struct {
+	ID             uint          `json:"id"`
+	Type           int           `json:"type"`
+	Title          string        `json:"title"`
+	TitleHighlight string        `json:"title_highlight"`
+	Snippet        string        `json:"snippet"`
+	Author         UserProfileVO `json:"author"`
+	HotScore       float64       `json:"hot_score"`
+	ViewCount      int64         `json:"view_count"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+type SearchUserVO struct {
+	UserProfileVO
+	UsernameHighlight string `json:"username_highlight"`
+	BioHighlight      string `json:"bio_highlight"`
+}
+type SearchTopicVO struct {
+	ID                   uint   `json:"id"`
+	Name                 string `json:"name"`
+	Description          string `json:"description"`
+	PostCount            int64  `json:"post_count"`
+	NameHighlight        string `json:"name_highlight"`
+	DescriptionHighlight string `json:"description_highlight"`
+}
+
+// 统一搜索的结果按类型分组，type不是all时只有对应的一组有内容(question的结果也放在posts里)
+type SearchResultVO struct {
+	Type      string          `json:"type"`
+	Posts     []SearchPostVO  `json:"posts"`
+	Questions []SearchPostVO  `json:"questions"`
+	Users     []SearchUserVO  `json:"users"`
+	Topics    []SearchTopicVO `json:"topics"`
+}
+
+// 热搜榜的一项
+type TrendingQueryVO struct {
+	Rank  int     `json:"rank"`
+	Query string  `json:"query"`
+	Score float64 `json:"score"`
+}
+
+// 搜索联想词，type为post/topic/user
+type SearchSuggestionVO struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// 相似文章或可能重复的问题，similarity为0~1的相似度估计
+type SimilarPostVO struct {
+	ID         uint      `json:"id"`
+	Title      string    `json:"title"`
+	Type       int       `json:"type"`
+	Similarity float64   `json:"similarity"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// 发布结果：提问时发现可能重复的问题且没有force时不发布，返回duplicates供用户确认
+type CreatePostVO struct {
+	PostID     uint            `json:"post_id,omitempty"`
+	Created    bool            `json:"created"`
+	Duplicates []SimilarPostVO `json:"duplicates,omitempty"`
 }
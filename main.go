@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/swaggo/files"
@@ -18,6 +21,11 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 //@title Go-Zhihu API
@@ -42,6 +50,7 @@ func main() {
 		SkipDefaultTransaction:                   true,
 		Logger:                                   logger.Default.LogMode(logger.Info),
 		DisableAutomaticPing:                     true,
+		TranslateError:                           true,
 	})
 	if err != nil {
 		log.Fatalf("Mysql start failed:%v", err)
@@ -56,36 +65,68 @@ func main() {
 			log.Fatal(err)
 		}
 	}(sqlDB)
-	db.Exec("SET FOREIGN_KEY_CHECKS = 0")
-	tables := []interface{}{
-		&model.Notification{},
-		&model.Like{},
-		&model.User{},
-		&model.Post{},
-		&model.Relation{},
-		&model.Comment{},
-	}
-	for _, table := range tables {
-		err := db.Migrator().DropTable(table)
-		if err != nil {
-			return
+	// 带参数启动时作为命令行维护工具运行，不删表、不迁移
+	cli := len(os.Args) > 1
+	if !cli {
+		db.Exec("SET FOREIGN_KEY_CHECKS = 0")
+		tables := []interface{}{
+			&model.Notification{},
+			&model.NotificationActor{},
+			&model.Like{},
+			&model.User{},
+			&model.Post{},
+			&model.Relation{},
+			&model.Comment{},
+		}
+		for _, table := range tables {
+			err := db.Migrator().DropTable(table)
+			if err != nil {
+				return
+			}
+		}
+		if err := repository.MigrateMessageSessionColumn(db); err != nil {
+			log.Fatalf("Migrate messages failed:%v", err)
 		}
+		err = db.AutoMigrate(
+			&model.Notification{},
+			&model.NotificationActor{},
+			&model.NotificationPreference{},
+			&model.Like{},
+			&model.Comment{},
+			&model.Post{},
+			&model.Connection{},
+			&model.User{},
+			&model.Relation{},
+			&model.Topic{},
+			&model.PostTopic{},
+			&model.PostDailyStat{},
+			&model.Folder{},
+			&model.FolderFollow{},
+			&model.QuestionFollow{},
+			&model.Activity{},
+			&model.Block{},
+			&model.FollowRequest{},
+			&model.SearchLog{},
+			&model.PostSignature{},
+			&model.PostLSHBucket{},
+			&model.ReadHistory{},
+			&model.Message{})
+		db.Exec("SET FOREIGN_KEY_CHECKS = 1")
 	}
-	err = db.AutoMigrate(
-		&model.Notification{},
-		&model.Like{},
-		&model.Comment{},
-		&model.Post{},
-		&model.Connection{},
-		&model.User{},
-		&model.Relation{})
-	db.Exec("SET FOREIGN_KEY_CHECKS = 1")
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     config.Setting.Redis.GetAddr(),
 		Password: config.Setting.Redis.Password,
 		DB:       config.Setting.Redis.DB,
 	})
 	repos := repository.NewRepositories(db)
+	if !cli {
+		if err := repos.Folder.MigrateLegacyConnections(context.Background(), nil); err != nil {
+			log.Fatalf("Migrate connections failed:%v", err)
+		}
+		if err := repos.Post.EnsureFullTextIndex(context.Background()); err != nil {
+			log.Fatalf("Create fulltext index failed:%v", err)
+		}
+	}
 	jwtSecret := config.Setting.JWT.Secret
 	socialService := service.NewService(
 		db,
@@ -93,6 +134,17 @@ func main() {
 		repos,
 		jwtSecret,
 	)
+	if cli {
+		cliCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := start.RunCommand(cliCtx, socialService, os.Args[1:]); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	bgCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	socialService.StartBackground(bgCtx)
 	httpHandler := handler.NewHandler(socialService, db)
 	r := gin.Default()
 	err = r.SetTrustedProxies(nil)
@@ -104,4 +156,31 @@ func main() {
 	r.Use(middleware.RateLimit(rdb, 20))
 	start.SetRoute(r, httpHandler, repos, db)
 
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Setting.Server.Port),
+		Handler: r,
+	}
+	// Shutdown不会等被hijack的websocket，但会等sse请求结束，先把长连接全部断开
+	srv.RegisterOnShutdown(socialService.Realtime.Shutdown)
+	go func() {
+		fmt.Printf("start service on %d\n", config.Setting.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start service:", err)
+		}
+	}()
+	// 收到退出信号后先停止接收请求，再让后台任务处理完手头的工作
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-sigCtx.Done()
+	log.Println("shutting down...")
+	deadline := time.Now().Add(time.Duration(config.Setting.Job.ShutdownSeconds) * time.Second)
+	shutdownCtx, cancelShutdown := context.WithDeadline(context.Background(), deadline)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http server shutdown:%v", err)
+	}
+	cancel()
+	if !socialService.WaitBackground(time.Until(deadline)) {
+		log.Println("background jobs did not finish before timeout")
+	}
 }
@@ -7,15 +7,18 @@ const (
 	ErrorServer        = 500
 	ErrorInvalidParams = 400
 	//用户错误代码
-	ErrUserExist      = 10001
-	ErrUserNotFound   = 10002
-	ErrPassword       = 10003
-	ErrorUserBanned   = 10004
-	ErrorToken        = 10005
-	ErrPermisson      = 10006
-	ErrActionFailed   = 10007
-	ErrorPostNotFound = 20001
-	ErrUnAuthorized   = 40101
+	ErrUserExist        = 10001
+	ErrUserNotFound     = 10002
+	ErrPassword         = 10003
+	ErrorUserBanned     = 10004
+	ErrorToken          = 10005
+	ErrPermisson        = 10006
+	ErrActionFailed     = 10007
+	ErrorBlocked        = 10008
+	ErrorPrivate        = 10009
+	ErrorPostNotFound   = 20001
+	ErrorFolderNotFound = 20002
+	ErrUnAuthorized     = 40101
 )
 
 type Error struct {
@@ -42,10 +45,20 @@ var (
 	ErrPasswordInstance     = New(ErrPassword, "密码错误")
 	ErrUserBanned           = New(ErrorUserBanned, "用户被禁言")
 	ErrPostNotFound         = New(ErrorPostNotFound, "文章不存在")
+	ErrFolderNotFound       = New(ErrorFolderNotFound, "收藏夹不存在")
+	ErrDefaultFolder        = New(ErrActionFailed, "默认收藏夹不能删除")
 	ErrToken                = New(ErrorToken, "Token 生成失败")
 	ErrPermission           = New(ErrPermisson, "无权修改")
 	ErrSelfAction           = New(ErrActionFailed, "不能对自己执行此操作")
 	ErrAlreadyFollowing     = New(ErrActionFailed, "已经关注了")
 	ErrUserNormal           = New(ErrActionFailed, "用户状态正常，无需操作")
 	ErrUnAuthorizedInstance = New(ErrUnAuthorized, "未登录或token无效")
+	ErrFeedRebuildRunning   = New(ErrActionFailed, "时间线正在重建中")
+	ErrBlocked              = New(ErrorBlocked, "你们之间存在拉黑关系，无法执行此操作")
+	ErrPrivateContent       = New(ErrorPrivate, "该用户已设为私密，关注通过后可见")
+	ErrRequestNotFound      = New(ErrActionFailed, "关注请求不存在")
+	ErrMergeNotAllowed      = New(ErrActionFailed, "只能把已发布的问题合并到另一个已发布的问题")
+	ErrTooManyConnections   = New(ErrActionFailed, "实时连接数过多，请关闭其他页面后重试")
+	ErrInvalidUnsubscribe   = New(ErrorInvalidParams, "退订链接无效")
+	ErrNotificationNotFound = New(ErrActionFailed, "通知不存在")
 )